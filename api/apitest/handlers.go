@@ -0,0 +1,518 @@
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	models "github.com/eahrend/chestermodels"
+)
+
+// listedDatabase is an instance group as it appears in a list, with its
+// revision alongside since there's no per item ETag.
+type listedDatabase struct {
+	database
+	Revision string `json:"revision,omitempty"`
+}
+
+// listDatabasesResponse is a single page of a paged list.
+type listDatabasesResponse struct {
+	Databases     []listedDatabase `json:"databases"`
+	NextPageToken string           `json:"next_page_token"`
+}
+
+// handleRoot adds, modifies and removes instance groups.
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		s.addDatabase(w, r)
+	case http.MethodPatch:
+		s.modifyDatabase(w, r)
+	case http.MethodDelete:
+		s.removeDatabase(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) addDatabase(w http.ResponseWriter, r *http.Request) {
+	req := addDatabaseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to parse json", http.StatusBadRequest)
+		return
+	}
+	if req.InstanceName == "" || req.Username == "" || req.Password == "" {
+		http.Error(w, "instance_name, username and password are required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.groups[req.InstanceName]; ok {
+		http.Error(w, fmt.Sprintf("instance group %s already exists", req.InstanceName), http.StatusConflict)
+		return
+	}
+	queryRules := append([]queryRule{}, req.QueryRules...)
+	if len(queryRules) == 0 {
+		for _, rule := range defaultQueryRules(req.Username, DefaultReadHostGroup, DefaultWriteHostGroup) {
+			queryRules = append(queryRules, queryRule{ProxySqlMySqlQueryRule: rule})
+		}
+	}
+	readReplicas := append([]models.AddDatabaseRequestDatabaseInformation{}, req.ReadReplicas...)
+	group := &instanceGroup{
+		data: models.InstanceData{
+			InstanceName:    req.InstanceName,
+			ReadHostGroup:   DefaultReadHostGroup,
+			WriteHostGroup:  DefaultWriteHostGroup,
+			Username:        req.Username,
+			Password:        req.Password,
+			MasterInstance:  req.MasterInstance,
+			ReadReplicas:    readReplicas,
+			UseSSL:          req.EnableSSL,
+			ChesterMetaData: req.ChesterMetaData,
+		},
+		revision:              1,
+		key:                   req.KeyData,
+		cert:                  req.CertData,
+		hostgroups:            copyHostgroups(req.Hostgroups),
+		replicationHostgroups: copyReplicationHostgroups(req.ReplicationHostgroups),
+		monitor:               copyMonitor(req.Monitor),
+	}
+	if err := checkHostgroups(group.data, group.hostgroups); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkReplicationHostgroups(group.data, group.hostgroups, group.replicationHostgroups); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkMonitor(group.monitor); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.data.QueryRules = s.storeQueryRules(group, queryRules)
+	s.groups[req.InstanceName] = group
+	s.syncUser(group, "")
+	s.writeRevision(w, group)
+	writeJSON(w, addDatabaseResponse{
+		AddDatabaseResponse: models.AddDatabaseResponse{
+			Action:          "add",
+			InstanceName:    group.data.InstanceName,
+			Username:        group.data.Username,
+			Password:        "REDACTED",
+			WriteHostGroup:  group.data.WriteHostGroup,
+			ReadHostGroup:   group.data.ReadHostGroup,
+			SSLEnabled:      group.data.UseSSL,
+			ChesterMetaData: group.data.ChesterMetaData,
+		},
+		QueryRules: group.queryRules(),
+	})
+}
+
+func (s *Server) modifyDatabase(w http.ResponseWriter, r *http.Request) {
+	req := modifyDatabaseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to parse json", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[req.InstanceName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("instance group %s not found", req.InstanceName), http.StatusNotFound)
+		return
+	}
+	if !s.checkRevision(w, r, group) {
+		return
+	}
+	hostgroups := group.hostgroups
+	if req.Hostgroups != nil {
+		if err := checkHostgroups(group.data, *req.Hostgroups); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hostgroups = *req.Hostgroups
+	}
+	replicationHostgroups := group.replicationHostgroups
+	if req.ReplicationHostgroups != nil {
+		replicationHostgroups = *req.ReplicationHostgroups
+	}
+	if err := checkReplicationHostgroups(group.data, hostgroups, replicationHostgroups); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// empty monitor credentials go back to the chart's monitor user
+	if req.Monitor != nil && *req.Monitor != (monitorCredentials{}) {
+		if err := checkMonitor(req.Monitor); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	db := &group.data
+	previousUsername := db.Username
+	if req.NewUsername != "" {
+		for i := range db.QueryRules {
+			if db.QueryRules[i].Username == db.Username {
+				db.QueryRules[i].Username = req.NewUsername
+			}
+		}
+		db.Username = req.NewUsername
+	}
+	if req.NewPassword != "" {
+		db.Password = req.NewPassword
+	}
+	if req.RemoveQueryRules != nil {
+		remove := map[int]bool{}
+		for _, id := range req.RemoveQueryRules {
+			remove[id] = true
+		}
+		queryRules := []models.ProxySqlMySqlQueryRule{}
+		for _, qr := range db.QueryRules {
+			if !remove[qr.RuleID] {
+				queryRules = append(queryRules, qr)
+				continue
+			}
+			delete(group.columns, qr.RuleID)
+		}
+		db.QueryRules = queryRules
+	}
+	if req.AddQueryRules != nil {
+		db.QueryRules = append(db.QueryRules, s.storeQueryRules(group, req.AddQueryRules)...)
+	}
+	// read replicas are authoritative when they're sent
+	if req.ReadReplicas != nil {
+		db.ReadReplicas = append([]models.AddDatabaseRequestDatabaseInformation{}, req.ReadReplicas...)
+	}
+	if req.Hostgroups != nil {
+		group.hostgroups = copyHostgroups(*req.Hostgroups)
+	}
+	if req.ReplicationHostgroups != nil {
+		group.replicationHostgroups = copyReplicationHostgroups(*req.ReplicationHostgroups)
+	}
+	if req.Monitor != nil {
+		group.monitor = copyMonitor(req.Monitor)
+		if *req.Monitor == (monitorCredentials{}) {
+			group.monitor = nil
+		}
+	}
+	if req.ChesterMetaData != (models.ChesterMetaData{}) {
+		db.ChesterMetaData = req.ChesterMetaData
+	}
+	group.revision++
+	s.syncUser(group, previousUsername)
+	s.writeRevision(w, group)
+	writeJSON(w, map[string]string{"action": "modify", "instance_name": db.InstanceName})
+}
+
+func (s *Server) removeDatabase(w http.ResponseWriter, r *http.Request) {
+	req := models.RemoveDatabaseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to parse json", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[req.InstanceName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("instance group %s not found", req.InstanceName), http.StatusNotFound)
+		return
+	}
+	if !s.checkRevision(w, r, group) {
+		return
+	}
+	s.removeGroup(req.InstanceName)
+	writeJSON(w, map[string]string{"action": "remove", "instance_name": req.InstanceName})
+}
+
+// handleListDatabases lists instance groups. Requests with a page size
+// or page token get a paged response, anything else gets the plain list
+// older clients expect.
+func (s *Server) handleListDatabases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	labels := map[string]string{}
+	for _, label := range q["label"] {
+		parts := strings.SplitN(label, ":", 2)
+		if len(parts) != 2 {
+			http.Error(w, fmt.Sprintf("label %s must be key:value", label), http.StatusBadRequest)
+			return
+		}
+		labels[parts[0]] = parts[1]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matching := []listedDatabase{}
+	for _, name := range s.groupNames() {
+		group := s.groups[name]
+		if !strings.HasPrefix(name, q.Get("name_prefix")) || !hasLabels(group.labels, labels) {
+			continue
+		}
+		listed := listedDatabase{database: group.database()}
+		if !s.noRevisions {
+			listed.Revision = strconv.Itoa(group.revision)
+		}
+		matching = append(matching, listed)
+	}
+	if q.Get("page_size") == "" && q.Get("page_token") == "" {
+		writeJSON(w, matching)
+		return
+	}
+	start, pageSize := 0, len(matching)
+	var err error
+	if token := q.Get("page_token"); token != "" {
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || start > len(matching) {
+			http.Error(w, fmt.Sprintf("bad page token %s", token), http.StatusBadRequest)
+			return
+		}
+	}
+	if size := q.Get("page_size"); size != "" {
+		pageSize, err = strconv.Atoi(size)
+		if err != nil || pageSize < 1 {
+			http.Error(w, fmt.Sprintf("bad page size %s", size), http.StatusBadRequest)
+			return
+		}
+	}
+	end := start + pageSize
+	if end > len(matching) {
+		end = len(matching)
+	}
+	resp := listDatabasesResponse{Databases: matching[start:end]}
+	if end < len(matching) {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+	writeJSON(w, resp)
+}
+
+// hasLabels reports whether have contains every label in want.
+func hasLabels(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) handleGetDatabase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := pathName(r, "/databases/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("instance group %s not found", name), http.StatusNotFound)
+		return
+	}
+	s.writeRevision(w, group)
+	writeJSON(w, group.database())
+}
+
+// handleUsers creates and modifies users.
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		user := models.ProxySqlMySqlUser{}
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			http.Error(w, "failed to parse json", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.users[user.Username]; ok {
+			http.Error(w, fmt.Sprintf("user %s already exists", user.Username), http.StatusConflict)
+			return
+		}
+		if _, ok := s.groups[user.InstanceGroup]; !ok {
+			http.Error(w, fmt.Sprintf("instance group %s not found", user.InstanceGroup), http.StatusNotFound)
+			return
+		}
+		s.users[user.Username] = user
+		s.groups[user.InstanceGroup].revision++
+		writeJSON(w, user)
+	case http.MethodPatch:
+		req := models.ModifyUserRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "failed to parse json", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		user, ok := s.users[req.Username]
+		if !ok {
+			http.Error(w, fmt.Sprintf("user %s not found", req.Username), http.StatusNotFound)
+			return
+		}
+		if req.Password != "" {
+			user.Password = req.Password
+		}
+		if req.DefaultHostgroup != 0 {
+			user.DefaultHostgroup = req.DefaultHostgroup
+		}
+		if req.NewUsername != "" {
+			delete(s.users, user.Username)
+			user.Username = req.NewUsername
+		}
+		s.users[user.Username] = user
+		// an instance group's own user is also part of its instance data
+		if group, ok := s.groups[user.InstanceGroup]; ok {
+			if group.data.Username == req.Username {
+				group.data.Username = user.Username
+				group.data.Password = user.Password
+			}
+			group.revision++
+		}
+		writeJSON(w, user)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUser gets and deletes a single user.
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request) {
+	name := pathName(r, "/users/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("user %s not found", name), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, user)
+	case http.MethodDelete:
+		delete(s.users, name)
+		if group, ok := s.groups[user.InstanceGroup]; ok {
+			group.revision++
+		}
+		writeJSON(w, user)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleQueryRule patches a single query rule, matched on rule id and,
+// when set, the username.
+func (s *Server) handleQueryRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(pathName(r, "/queryrules/"))
+	if err != nil {
+		http.Error(w, "rule id must be a number", http.StatusBadRequest)
+		return
+	}
+	rule := queryRule{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "failed to parse json", http.StatusBadRequest)
+		return
+	}
+	rule.RuleID = id
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range s.groupNames() {
+		group := s.groups[name]
+		for i, qr := range group.data.QueryRules {
+			if qr.RuleID != id || (rule.Username != "" && qr.Username != rule.Username && group.data.Username != rule.Username) {
+				continue
+			}
+			if !s.checkRevision(w, r, group) {
+				return
+			}
+			group.data.QueryRules[i] = s.storeQueryRules(group, []queryRule{rule})[0]
+			group.revision++
+			s.writeRevision(w, group)
+			writeJSON(w, rule)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("query rule %d not found", id), http.StatusNotFound)
+}
+
+// handleMysqlVariables returns or replaces the mysql_variables of an
+// instance group.
+func (s *Server) handleMysqlVariables(w http.ResponseWriter, r *http.Request) {
+	name := pathName(r, "/mysqlvariables/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("instance group %s not found", name), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !s.checkRevision(w, r, group) {
+			return
+		}
+		body := struct {
+			MysqlVariables map[string]string `json:"mysql_variables"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "failed to parse json", http.StatusBadRequest)
+			return
+		}
+		group.variables = body.MysqlVariables
+		group.revision++
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	vars := map[string]string{}
+	for k, v := range group.variables {
+		vars[k] = v
+	}
+	s.writeRevision(w, group)
+	writeJSON(w, map[string]interface{}{"instance_group": name, "mysql_variables": vars})
+}
+
+func (s *Server) handleKey(w http.ResponseWriter, r *http.Request) {
+	s.handleSecret(w, r, "/key/", "key", func(group *instanceGroup, data string) {
+		group.key = data
+	})
+}
+
+func (s *Server) handleCert(w http.ResponseWriter, r *http.Request) {
+	s.handleSecret(w, r, "/cert/", "cert", func(group *instanceGroup, data string) {
+		group.cert = data
+	})
+}
+
+// handleSecret stores the key or cert uploaded for an instance group.
+func (s *Server) handleSecret(w http.ResponseWriter, r *http.Request, prefix, field string, set func(*instanceGroup, string)) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body := map[string]string{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "failed to parse json", http.StatusBadRequest)
+		return
+	}
+	name := pathName(r, prefix)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("instance group %s not found", name), http.StatusNotFound)
+		return
+	}
+	set(group, body[field])
+	group.revision++
+	s.writeRevision(w, group)
+	writeJSON(w, map[string]string{"instance_group": name})
+}
@@ -0,0 +1,56 @@
+package apitest
+
+import (
+	"fmt"
+
+	models "github.com/eahrend/chestermodels"
+)
+
+// hostgroup is a hostgroup of an instance group beyond its read and write
+// hostgroups.
+type hostgroup struct {
+	HostgroupID    int                                            `json:"hostgroup_id"`
+	Servers        []models.AddDatabaseRequestDatabaseInformation `json:"servers"`
+	MaxConnections int                                            `json:"max_connections,omitempty"`
+	Comment        string                                         `json:"comment,omitempty"`
+}
+
+// checkHostgroups rejects hostgroups that clash with the read or write
+// hostgroup of db or with each other.
+func checkHostgroups(db models.InstanceData, hostgroups []hostgroup) error {
+	seen := map[int]bool{db.ReadHostGroup: true, db.WriteHostGroup: true}
+	for _, hg := range hostgroups {
+		if seen[hg.HostgroupID] {
+			return fmt.Errorf("hostgroup %d is already used by instance group %s", hg.HostgroupID, db.InstanceName)
+		}
+		seen[hg.HostgroupID] = true
+	}
+	return nil
+}
+
+// copyHostgroups deep copies hostgroups, nil stays nil.
+func copyHostgroups(hostgroups []hostgroup) []hostgroup {
+	if hostgroups == nil {
+		return nil
+	}
+	copied := make([]hostgroup, len(hostgroups))
+	for i, hg := range hostgroups {
+		hg.Servers = append([]models.AddDatabaseRequestDatabaseInformation{}, hg.Servers...)
+		copied[i] = hg
+	}
+	return copied
+}
+
+// Hostgroups returns the servers of the hostgroups of an instance group
+// beyond its read and write hostgroups, by hostgroup.
+func (s *Server) Hostgroups(instanceName string) map[int][]models.AddDatabaseRequestDatabaseInformation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hostgroups := map[int][]models.AddDatabaseRequestDatabaseInformation{}
+	if group, ok := s.groups[instanceName]; ok {
+		for _, hg := range copyHostgroups(group.hostgroups) {
+			hostgroups[hg.HostgroupID] = hg.Servers
+		}
+	}
+	return hostgroups
+}
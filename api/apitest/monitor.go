@@ -0,0 +1,38 @@
+package apitest
+
+import "errors"
+
+// monitorCredentials are the credentials of an instance group's monitor
+// user.
+type monitorCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// checkMonitor rejects monitor credentials without a username or password.
+func checkMonitor(monitor *monitorCredentials) error {
+	if monitor != nil && (monitor.Username == "" || monitor.Password == "") {
+		return errors.New("monitor needs a username and a password")
+	}
+	return nil
+}
+
+// copyMonitor copies monitor, nil stays nil.
+func copyMonitor(monitor *monitorCredentials) *monitorCredentials {
+	if monitor == nil {
+		return nil
+	}
+	copied := *monitor
+	return &copied
+}
+
+// MonitorCredentials returns the monitor username and password of an
+// instance group, empty without monitor credentials of its own.
+func (s *Server) MonitorCredentials(instanceName string) (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group, ok := s.groups[instanceName]; ok && group.monitor != nil {
+		return group.monitor.Username, group.monitor.Password
+	}
+	return "", ""
+}
@@ -0,0 +1,143 @@
+package apitest
+
+import (
+	"encoding/json"
+
+	models "github.com/eahrend/chestermodels"
+)
+
+// modelColumns are the query rule columns models.ProxySqlMySqlQueryRule has.
+var modelColumns = []string{"rule_id", "username", "active", "match_digest", "destination_hostgroup", "apply", "comment"}
+
+// queryRule is a query rule as the client sent it. The columns models
+// doesn't have, like match_pattern or flagOUT, are kept as sent so they
+// make it back to the client the way chester-api passes them to proxysql.
+type queryRule struct {
+	models.ProxySqlMySqlQueryRule
+	columns map[string]json.RawMessage
+}
+
+func (qr *queryRule) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &qr.ProxySqlMySqlQueryRule); err != nil {
+		return err
+	}
+	columns := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &columns); err != nil {
+		return err
+	}
+	for _, column := range modelColumns {
+		delete(columns, column)
+	}
+	qr.columns = columns
+	return nil
+}
+
+func (qr queryRule) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(qr.ProxySqlMySqlQueryRule)
+	if err != nil || len(qr.columns) == 0 {
+		return b, err
+	}
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, err
+	}
+	for column, value := range qr.columns {
+		merged[column] = value
+	}
+	return json.Marshal(merged)
+}
+
+// addDatabaseRequest is api.AddDatabaseRequest as the server reads it.
+type addDatabaseRequest struct {
+	models.AddDatabaseRequest
+	QueryRules            []queryRule            `json:"query_rules"`
+	Hostgroups            []hostgroup            `json:"hostgroups"`
+	ReplicationHostgroups []replicationHostgroup `json:"replication_hostgroups"`
+	Monitor               *monitorCredentials    `json:"monitor"`
+}
+
+// addDatabaseResponse is models.AddDatabaseResponse with every rule column.
+type addDatabaseResponse struct {
+	models.AddDatabaseResponse
+	QueryRules []queryRule `json:"query_rules"`
+}
+
+// modifyDatabaseRequest is api.ModifyDatabaseRequest as the server reads it.
+type modifyDatabaseRequest struct {
+	models.ModifyDatabaseRequest
+	AddQueryRules         []queryRule             `json:"add_query_rules"`
+	Hostgroups            *[]hostgroup            `json:"hostgroups"`
+	ReplicationHostgroups *[]replicationHostgroup `json:"replication_hostgroups"`
+	Monitor               *monitorCredentials     `json:"monitor"`
+}
+
+// database is api.InstanceData as the server sends it.
+type database struct {
+	models.InstanceData
+	QueryRules            []queryRule            `json:"query_rules"`
+	Hostgroups            []hostgroup            `json:"hostgroups,omitempty"`
+	ReplicationHostgroups []replicationHostgroup `json:"replication_hostgroups,omitempty"`
+	Monitor               *monitorCredentials    `json:"monitor,omitempty"`
+}
+
+// storeQueryRules assigns ids to rules and keeps their extra columns on
+// group, keyed by rule id. Callers must hold the lock.
+func (s *Server) storeQueryRules(group *instanceGroup, rules []queryRule) []models.ProxySqlMySqlQueryRule {
+	stored := make([]models.ProxySqlMySqlQueryRule, len(rules))
+	for i, rule := range rules {
+		stored[i] = rule.ProxySqlMySqlQueryRule
+	}
+	stored = s.assignRuleIDs(stored)
+	if group.columns == nil {
+		group.columns = map[int]map[string]json.RawMessage{}
+	}
+	for i, rule := range stored {
+		if len(rules[i].columns) == 0 {
+			delete(group.columns, rule.RuleID)
+			continue
+		}
+		group.columns[rule.RuleID] = rules[i].columns
+	}
+	return stored
+}
+
+// queryRules returns the rules of group with their extra columns, callers
+// must hold the lock.
+func (group *instanceGroup) queryRules() []queryRule {
+	if group.data.QueryRules == nil {
+		return nil
+	}
+	rules := make([]queryRule, len(group.data.QueryRules))
+	for i, rule := range group.data.QueryRules {
+		rules[i] = queryRule{ProxySqlMySqlQueryRule: rule, columns: group.columns[rule.RuleID]}
+	}
+	return rules
+}
+
+// database returns a copy of group as the server sends it, callers must
+// hold the lock.
+func (group *instanceGroup) database() database {
+	return database{
+		InstanceData:          copyInstanceData(group.data),
+		QueryRules:            group.queryRules(),
+		Hostgroups:            copyHostgroups(group.hostgroups),
+		ReplicationHostgroups: copyReplicationHostgroups(group.replicationHostgroups),
+		Monitor:               copyMonitor(group.monitor),
+	}
+}
+
+// QueryRuleColumns returns the columns of a query rule that models doesn't
+// have, as the client sent them.
+func (s *Server) QueryRuleColumns(instanceName string, ruleID int) map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[instanceName]
+	if !ok {
+		return nil
+	}
+	columns := map[string]json.RawMessage{}
+	for column, value := range group.columns[ruleID] {
+		columns[column] = value
+	}
+	return columns
+}
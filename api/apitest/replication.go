@@ -0,0 +1,132 @@
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	models "github.com/eahrend/chestermodels"
+)
+
+// replicationHostgroup is a mysql_replication_hostgroups entry of an
+// instance group.
+type replicationHostgroup struct {
+	WriterHostgroup int    `json:"writer_hostgroup"`
+	ReaderHostgroup int    `json:"reader_hostgroup"`
+	CheckType       string `json:"check_type,omitempty"`
+	Comment         string `json:"comment,omitempty"`
+}
+
+// checkTypes are the check types proxysql knows.
+var checkTypes = map[string]bool{
+	"":                           true,
+	"read_only":                  true,
+	"innodb_read_only":           true,
+	"super_read_only":            true,
+	"read_only|innodb_read_only": true,
+	"read_only&innodb_read_only": true,
+}
+
+// checkReplicationHostgroups rejects replication hostgroups that pair a
+// hostgroup with itself, pair hostgroups the instance group doesn't have
+// or use a hostgroup in more than one pair, the way proxysql's unique
+// constraints do.
+func checkReplicationHostgroups(db models.InstanceData, hostgroups []hostgroup, pairs []replicationHostgroup) error {
+	known := map[int]bool{db.ReadHostGroup: true, db.WriteHostGroup: true}
+	for _, hg := range hostgroups {
+		known[hg.HostgroupID] = true
+	}
+	used := map[int]bool{}
+	for _, pair := range pairs {
+		if pair.WriterHostgroup == pair.ReaderHostgroup {
+			return fmt.Errorf("replication hostgroup pairs hostgroup %d with itself", pair.WriterHostgroup)
+		}
+		for _, id := range []int{pair.WriterHostgroup, pair.ReaderHostgroup} {
+			if !known[id] {
+				return fmt.Errorf("hostgroup %d isn't a hostgroup of instance group %s", id, db.InstanceName)
+			}
+			if used[id] {
+				return fmt.Errorf("hostgroup %d is in more than one replication hostgroup", id)
+			}
+			used[id] = true
+		}
+		if !checkTypes[pair.CheckType] {
+			return fmt.Errorf("unknown check_type %q", pair.CheckType)
+		}
+	}
+	return nil
+}
+
+// copyReplicationHostgroups copies pairs, nil stays nil.
+func copyReplicationHostgroups(pairs []replicationHostgroup) []replicationHostgroup {
+	if pairs == nil {
+		return nil
+	}
+	return append([]replicationHostgroup{}, pairs...)
+}
+
+// handleSwitchover makes a read replica the master instance, the old
+// master takes the replica's place among the read replicas.
+func (s *Server) handleSwitchover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := pathName(r, "/switchover/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("instance group %s not found", name), http.StatusNotFound)
+		return
+	}
+	if !s.checkRevision(w, r, group) {
+		return
+	}
+	body := struct {
+		NewMaster string `json:"new_master"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "failed to parse json", http.StatusBadRequest)
+		return
+	}
+	db := &group.data
+	if db.MasterInstance.Name != body.NewMaster {
+		i := -1
+		for j, replica := range db.ReadReplicas {
+			if replica.Name == body.NewMaster {
+				i = j
+			}
+		}
+		if i < 0 {
+			http.Error(w, fmt.Sprintf("%s isn't a read replica of instance group %s", body.NewMaster, name), http.StatusBadRequest)
+			return
+		}
+		db.ReadReplicas[i], db.MasterInstance = db.MasterInstance, db.ReadReplicas[i]
+		group.revision++
+	}
+	s.writeRevision(w, group)
+	writeJSON(w, map[string]string{"action": "switchover", "instance_name": name, "master_instance": db.MasterInstance.Name})
+}
+
+// Failover swaps the master instance of an instance group with one of its
+// read replicas, the way proxysql's monitor moves servers between the
+// hostgroups of a replication hostgroup when a replica stops being read
+// only. It's runtime state, so the revision stays the same. It reports
+// whether the instance group has a read replica called replicaName.
+func (s *Server) Failover(instanceName, replicaName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[instanceName]
+	if !ok {
+		return false
+	}
+	db := &group.data
+	for i, replica := range db.ReadReplicas {
+		if replica.Name == replicaName {
+			db.ReadReplicas[i], db.MasterInstance = db.MasterInstance, replica
+			return true
+		}
+	}
+	return false
+}
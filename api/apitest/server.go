@@ -0,0 +1,425 @@
+// Package apitest is an in-memory stand-in for chester-api, used to test
+// the api client and the terraform provider without a GKE cluster,
+// IAP or Cloud SQL.
+//
+//	srv := apitest.NewServer()
+//	defer srv.Close()
+//	client, _ := api.NewClientWithOptions(
+//		api.WithHost(srv.URL),
+//		api.WithUsername(srv.Username),
+//		api.WithPassword(srv.Password),
+//	)
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	models "github.com/eahrend/chestermodels"
+)
+
+const (
+	// DefaultUsername is the basic auth username the server accepts
+	// unless WithCredentials is used.
+	DefaultUsername = "chester"
+	// DefaultPassword is the basic auth password the server accepts
+	// unless WithCredentials is used.
+	DefaultPassword = "bison"
+	// DefaultWriteHostGroup is the write host group given to instance
+	// groups added without one.
+	DefaultWriteHostGroup = 5
+	// DefaultReadHostGroup is the read host group given to instance
+	// groups added without one.
+	DefaultReadHostGroup = 10
+)
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithCredentials sets the basic auth credentials the server accepts.
+func WithCredentials(username, password string) Option {
+	return func(s *Server) {
+		s.Username = username
+		s.Password = password
+	}
+}
+
+// WithProxyAuthorization makes the server reject requests without the
+// IAP Proxy-Authorization bearer token.
+func WithProxyAuthorization(token string) Option {
+	return func(s *Server) {
+		s.proxyToken = token
+	}
+}
+
+// WithoutRevisions makes the server behave like an older chester-api
+// that doesn't send ETags and ignores If-Match.
+func WithoutRevisions() Option {
+	return func(s *Server) {
+		s.noRevisions = true
+	}
+}
+
+// Server is a chester-api backed by an in-memory store. It serves the
+// databases, users, query rules, mysql variables, switchover, key and cert
+// endpoints.
+type Server struct {
+	*httptest.Server
+	// Username is the basic auth username the server accepts
+	Username string
+	// Password is the basic auth password the server accepts
+	Password string
+
+	proxyToken  string
+	noRevisions bool
+
+	mu       sync.Mutex
+	groups   map[string]*instanceGroup
+	users    map[string]models.ProxySqlMySqlUser
+	nextRule int
+	requests []string
+}
+
+// instanceGroup is everything the server stores about one instance group.
+type instanceGroup struct {
+	data models.InstanceData
+	// columns are the query rule columns models doesn't have, by rule id
+	columns  map[int]map[string]json.RawMessage
+	revision int
+	labels   map[string]string
+	key      string
+	cert     string
+	// variables are the mysql_variables set for the instance group
+	variables map[string]string
+	// hostgroups are the hostgroups beyond the read and write hostgroups
+	hostgroups []hostgroup
+	// replicationHostgroups are the mysql_replication_hostgroups entries
+	replicationHostgroups []replicationHostgroup
+	// monitor are the monitor credentials, nil for the chart's monitor user
+	monitor *monitorCredentials
+}
+
+// NewServer starts a Server, call Close when done with it.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		Username: DefaultUsername,
+		Password: DefaultPassword,
+		groups:   map[string]*instanceGroup{},
+		users:    map[string]models.ProxySqlMySqlUser{},
+		nextRule: 1,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(s.authenticate(s.routes()))
+	return s
+}
+
+// routes maps every chester-api endpoint to its handler.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleRoot)
+	mux.HandleFunc("/databases", s.handleListDatabases)
+	mux.HandleFunc("/databases/", s.handleGetDatabase)
+	mux.HandleFunc("/users", s.handleUsers)
+	mux.HandleFunc("/users/", s.handleUser)
+	mux.HandleFunc("/queryrules/", s.handleQueryRule)
+	mux.HandleFunc("/mysqlvariables/", s.handleMysqlVariables)
+	mux.HandleFunc("/switchover/", s.handleSwitchover)
+	mux.HandleFunc("/key/", s.handleKey)
+	mux.HandleFunc("/cert/", s.handleCert)
+	return mux
+}
+
+// authenticate checks basic auth, and the IAP token when configured.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, fmt.Sprintf("%s %s", r.Method, r.URL.EscapedPath()))
+		s.mu.Unlock()
+		if s.proxyToken != "" && r.Header.Get("Proxy-Authorization") != fmt.Sprintf("Bearer %s", s.proxyToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.Username || pass != s.Password {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Requests returns every request received so far as "METHOD /path", with
+// the path escaped the way it was sent.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// PutDatabase seeds or replaces an instance group, as if it was changed
+// outside of the client under test.
+func (s *Server) PutDatabase(db models.InstanceData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[db.InstanceName]
+	if !ok {
+		group = &instanceGroup{}
+		s.groups[db.InstanceName] = group
+	}
+	previousUsername := group.data.Username
+	group.data = copyInstanceData(db)
+	group.data.QueryRules = s.assignRuleIDs(group.data.QueryRules)
+	group.columns = nil
+	group.revision++
+	s.syncUser(group, previousUsername)
+}
+
+// UpdateDatabase changes an instance group in place, as if it was changed
+// outside of the client under test. It returns false if it doesn't exist.
+func (s *Server) UpdateDatabase(instanceName string, update func(*models.InstanceData)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[instanceName]
+	if !ok {
+		return false
+	}
+	previousUsername := group.data.Username
+	update(&group.data)
+	group.revision++
+	s.syncUser(group, previousUsername)
+	return true
+}
+
+// DeleteDatabase removes an instance group, as if it was removed outside
+// of the client under test.
+func (s *Server) DeleteDatabase(instanceName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeGroup(instanceName)
+}
+
+// Database returns a copy of an instance group.
+func (s *Server) Database(instanceName string) (models.InstanceData, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[instanceName]
+	if !ok {
+		return models.InstanceData{}, false
+	}
+	return copyInstanceData(group.data), true
+}
+
+// Databases returns a copy of every instance group sorted by name.
+func (s *Server) Databases() []models.InstanceData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dbs := []models.InstanceData{}
+	for _, name := range s.groupNames() {
+		dbs = append(dbs, copyInstanceData(s.groups[name].data))
+	}
+	return dbs
+}
+
+// SetLabels sets the labels ListDatabases can filter an instance group on.
+func (s *Server) SetLabels(instanceName string, labels map[string]string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[instanceName]
+	if !ok {
+		return false
+	}
+	group.labels = map[string]string{}
+	for k, v := range labels {
+		group.labels[k] = v
+	}
+	return true
+}
+
+// Revision returns the current revision of an instance group.
+func (s *Server) Revision(instanceName string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[instanceName]
+	if !ok {
+		return ""
+	}
+	return strconv.Itoa(group.revision)
+}
+
+// User returns a proxysql user, either an instance group's own user or
+// one created through the users endpoint.
+func (s *Server) User(username string) (models.ProxySqlMySqlUser, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	return user, ok
+}
+
+// MysqlVariables returns the mysql_variables set for an instance group.
+func (s *Server) MysqlVariables(instanceGroup string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vars := map[string]string{}
+	if group, ok := s.groups[instanceGroup]; ok {
+		for k, v := range group.variables {
+			vars[k] = v
+		}
+	}
+	return vars
+}
+
+// Key returns the key last uploaded for an instance group.
+func (s *Server) Key(instanceGroup string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group, ok := s.groups[instanceGroup]; ok {
+		return group.key
+	}
+	return ""
+}
+
+// Cert returns the cert last uploaded for an instance group.
+func (s *Server) Cert(instanceGroup string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if group, ok := s.groups[instanceGroup]; ok {
+		return group.cert
+	}
+	return ""
+}
+
+// groupNames returns the instance group names in order, callers must
+// hold the lock.
+func (s *Server) groupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// syncUser keeps the users endpoint in line with an instance group's
+// own user, previousUsername is dropped if the user was renamed. Callers
+// must hold the lock.
+func (s *Server) syncUser(group *instanceGroup, previousUsername string) {
+	if previousUsername != "" && previousUsername != group.data.Username {
+		delete(s.users, previousUsername)
+	}
+	s.users[group.data.Username] = models.ProxySqlMySqlUser{
+		Username:         group.data.Username,
+		Password:         group.data.Password,
+		DefaultHostgroup: group.data.WriteHostGroup,
+		Active:           1,
+		InstanceGroup:    group.data.InstanceName,
+	}
+}
+
+// removeGroup deletes an instance group and its users, callers must
+// hold the lock.
+func (s *Server) removeGroup(instanceName string) {
+	delete(s.groups, instanceName)
+	for name, user := range s.users {
+		if user.InstanceGroup == instanceName {
+			delete(s.users, name)
+		}
+	}
+}
+
+// checkRevision enforces If-Match, callers must hold the lock.
+func (s *Server) checkRevision(w http.ResponseWriter, r *http.Request, group *instanceGroup) bool {
+	match := r.Header.Get("If-Match")
+	if s.noRevisions || match == "" || match == strconv.Itoa(group.revision) {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("instance group %s is at revision %d", group.data.InstanceName, group.revision), http.StatusPreconditionFailed)
+	return false
+}
+
+// writeRevision sets the ETag, callers must hold the lock.
+func (s *Server) writeRevision(w http.ResponseWriter, group *instanceGroup) {
+	if !s.noRevisions {
+		w.Header().Set("ETag", strconv.Itoa(group.revision))
+	}
+}
+
+// assignRuleIDs gives every rule without an id the next free one,
+// callers must hold the lock.
+func (s *Server) assignRuleIDs(rules []models.ProxySqlMySqlQueryRule) []models.ProxySqlMySqlQueryRule {
+	for i := range rules {
+		if rules[i].RuleID == 0 {
+			rules[i].RuleID = s.nextRule
+		}
+		if rules[i].RuleID >= s.nextRule {
+			s.nextRule = rules[i].RuleID + 1
+		}
+	}
+	return rules
+}
+
+// defaultQueryRules mirrors the read/write split chester-api generates
+// when an instance group is added without query rules.
+func defaultQueryRules(username string, readHostGroup, writeHostGroup int) []models.ProxySqlMySqlQueryRule {
+	return []models.ProxySqlMySqlQueryRule{
+		{
+			Username:             username,
+			Active:               1,
+			MatchDigest:          "^SELECT .* FOR UPDATE",
+			DestinationHostgroup: writeHostGroup,
+			Apply:                1,
+			Comment:              "select for update goes to the writer",
+		},
+		{
+			Username:             username,
+			Active:               1,
+			MatchDigest:          "^SELECT",
+			DestinationHostgroup: readHostGroup,
+			Apply:                1,
+			Comment:              "selects go to the reader",
+		},
+		{
+			Username:             username,
+			Active:               1,
+			MatchDigest:          ".*",
+			DestinationHostgroup: writeHostGroup,
+			Apply:                1,
+			Comment:              "catch-all to writer",
+		},
+	}
+}
+
+// copyInstanceData deep copies the slices of an instance group.
+func copyInstanceData(db models.InstanceData) models.InstanceData {
+	if db.QueryRules != nil {
+		db.QueryRules = append([]models.ProxySqlMySqlQueryRule{}, db.QueryRules...)
+	}
+	if db.ReadReplicas != nil {
+		db.ReadReplicas = append([]models.AddDatabaseRequestDatabaseInformation{}, db.ReadReplicas...)
+	}
+	return db
+}
+
+// writeJSON writes v as a 200 json response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "failed to marshal json", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// pathName returns the last path segment after prefix.
+func pathName(r *http.Request, prefix string) string {
+	return strings.TrimPrefix(r.URL.Path, prefix)
+}
@@ -0,0 +1,391 @@
+package apitest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/api/apitest"
+)
+
+func newClient(t *testing.T, srv *apitest.Server) *api.Client {
+	t.Helper()
+	client, err := api.NewClientWithOptions(api.WithHost(srv.URL), api.WithUsername(srv.Username), api.WithPassword(srv.Password))
+	if err != nil {
+		t.Fatalf("failed to create client %s", err.Error())
+	}
+	return client
+}
+
+// TestServer_Auth checks that bad credentials and missing IAP tokens are rejected.
+func TestServer_Auth(t *testing.T) {
+	srv := apitest.NewServer(apitest.WithProxyAuthorization("token"))
+	defer srv.Close()
+	client := newClient(t, srv)
+	if _, err := client.GetDatabases(); err == nil {
+		t.Fatal("expected a missing proxy token to be rejected")
+	}
+	badClient, _ := api.NewClientWithOptions(api.WithHost(srv.URL), api.WithUsername("nope"), api.WithPassword(srv.Password))
+	if _, err := badClient.GetDatabases(); err == nil {
+		t.Fatal("expected a bad username to be rejected")
+	}
+}
+
+// TestServer_DatabaseLifecycle adds, reads, modifies and removes an instance group.
+func TestServer_DatabaseLifecycle(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	resp, err := client.AddDatabase(models.AddDatabaseRequest{
+		InstanceName:   "foo",
+		Username:       "foo",
+		Password:       "bar",
+		MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "foo", IPAddress: "1.2.3.4"},
+		ChesterMetaData: models.ChesterMetaData{
+			InstanceGroup:       "foo",
+			MaxChesterInstances: 2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.QueryRules) != 3 || resp.QueryRules[0].RuleID != 1 {
+		t.Fatalf("expected default query rules, got %v", resp.QueryRules)
+	}
+	if _, err := client.AddDatabase(models.AddDatabaseRequest{InstanceName: "foo", Username: "foo", Password: "bar"}); err == nil {
+		t.Fatal("expected adding a duplicate instance group to fail")
+	}
+	_, revision, err := client.GetDatabaseRevision("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.ModifyDatabase(models.ModifyDatabaseRequest{
+		InstanceName:     "foo",
+		NewPassword:      "baz",
+		RemoveQueryRules: []int{1},
+		ReadReplicas:     []models.AddDatabaseRequestDatabaseInformation{{Name: "foo-read", IPAddress: "1.2.3.5"}},
+	}, api.IfMatch(revision))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := client.GetDatabase("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Password != "baz" || len(db.QueryRules) != 2 || len(db.ReadReplicas) != 1 || db.ChesterMetaData.MaxChesterInstances != 2 {
+		t.Fatalf("modify wasn't applied %+v", db)
+	}
+	err = client.ModifyDatabase(models.ModifyDatabaseRequest{InstanceName: "foo", NewPassword: "qux"}, api.IfMatch(revision))
+	if !errors.Is(err, api.ErrPreconditionFailed) {
+		t.Fatalf("expected a stale revision to fail, got %v", err)
+	}
+	if err := client.RemoveDatabase(models.RemoveDatabaseRequest{InstanceName: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetDatabase("foo"); err == nil {
+		t.Fatal("expected removed instance group to be gone")
+	}
+	if _, ok := srv.User("foo"); ok {
+		t.Fatal("expected the instance group's user to be removed with it")
+	}
+}
+
+// TestServer_ListDatabases checks paging, prefixes and labels.
+func TestServer_ListDatabases(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	for _, name := range []string{"orders-a", "orders-b", "orders-c", "users-a"} {
+		srv.PutDatabase(models.InstanceData{InstanceName: name, Username: name})
+	}
+	srv.SetLabels("orders-b", map[string]string{"env": "prod"})
+	dbs, err := client.ListAllDatabases(context.Background(), api.ListOptions{PageSize: 2, NamePrefix: "orders-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 3 {
+		t.Fatalf("expected 3 orders databases, got %d", len(dbs))
+	}
+	dbs, err = client.ListAllDatabases(context.Background(), api.ListOptions{Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 1 || dbs[0].InstanceName != "orders-b" {
+		t.Fatalf("expected only orders-b, got %v", dbs)
+	}
+	all, err := client.GetDatabases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("expected 4 databases, got %d", len(all))
+	}
+}
+
+// TestServer_UsersQueryRulesAndCerts covers the secondary endpoints.
+func TestServer_UsersQueryRulesAndCerts(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	srv.PutDatabase(models.InstanceData{
+		InstanceName: "foo",
+		Username:     "foo",
+		Password:     "bar",
+		QueryRules:   []models.ProxySqlMySqlQueryRule{{Username: "foo", Active: 1, MatchDigest: "^SELECT", Apply: 1}},
+	})
+	if err := client.CreateUser(models.ProxySqlMySqlUser{Username: "reporting", Password: "pw", InstanceGroup: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := client.GetUser("reporting")
+	if err != nil || user.InstanceGroup != "foo" {
+		t.Fatalf("failed to get created user %v %v", user, err)
+	}
+	if err := client.ModifyUser(models.ModifyUserRequest{Username: "reporting", Password: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := srv.User("reporting"); user.Password != "new" {
+		t.Fatal("expected the user's password to change")
+	}
+	if err := client.DeleteUser("reporting"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetUser("reporting"); err == nil {
+		t.Fatal("expected deleted user to be gone")
+	}
+	err = client.ModifyQueryRuleByID(models.ProxySqlMySqlQueryRule{RuleID: 1, Username: "foo", Active: 0, MatchDigest: "^SELECT", Apply: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db, _ := srv.Database("foo"); db.QueryRules[0].Active != 0 {
+		t.Fatal("expected the query rule to be deactivated")
+	}
+	if err := client.ModifyQueryRuleByID(models.ProxySqlMySqlQueryRule{RuleID: 99}); err == nil {
+		t.Fatal("expected a missing query rule to fail")
+	}
+	if err := client.UpdateCert("cert-data", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.UpdateKey("key-data", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Cert("foo") != "cert-data" || srv.Key("foo") != "key-data" {
+		t.Fatal("expected cert and key to be stored")
+	}
+}
+
+// TestServer_QueryRuleColumns checks that the query rule columns models
+// doesn't have survive adds, modifies, reads and lists.
+func TestServer_QueryRuleColumns(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	ttl, flagOUT := 5000, 2
+	_, err := client.AddInstanceGroup(api.AddDatabaseRequest{
+		AddDatabaseRequest: models.AddDatabaseRequest{InstanceName: "foo", Username: "foo", Password: "bar"},
+		QueryRules: []api.QueryRule{
+			{
+				ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{Username: "foo", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 0},
+				SchemaName:             "orders",
+				FlagOUT:                &flagOUT,
+				CacheTTL:               &ttl,
+			},
+			{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{Username: "foo", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, revision, err := client.GetInstanceGroup("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := db.QueryRules[0]
+	if rule.SchemaName != "orders" || rule.FlagOUT == nil || *rule.FlagOUT != 2 || rule.CacheTTL == nil || *rule.CacheTTL != 5000 || rule.Timeout != nil {
+		t.Fatalf("expected the extra columns back, got %+v", rule)
+	}
+	if db.QueryRules[1].FlagOUT != nil || db.QueryRules[1].SchemaName != "" {
+		t.Fatalf("expected NULL columns to stay NULL, got %+v", db.QueryRules[1])
+	}
+
+	errorMsg := "reports are read only"
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "foo", RemoveQueryRules: []int{rule.RuleID}},
+		AddQueryRules: []api.QueryRule{{
+			ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{Username: "foo", Active: 1, Apply: 1},
+			MatchPattern:           "^DELETE FROM reports",
+			ErrorMsg:               errorMsg,
+		}},
+	}, api.IfMatch(revision))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns := srv.QueryRuleColumns("foo", rule.RuleID); len(columns) != 0 {
+		t.Fatalf("expected the removed rule's columns to be dropped, got %v", columns)
+	}
+	dbs, err := client.ListAllDatabases(context.Background(), api.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := dbs[0].QueryRules[1]
+	if added.MatchPattern != "^DELETE FROM reports" || added.ErrorMsg != errorMsg {
+		t.Fatalf("expected the added rule's columns in the list, got %+v", added)
+	}
+
+	added.ErrorMsg = ""
+	added.Log = &flagOUT
+	if err := client.ModifyQueryRule(added); err != nil {
+		t.Fatal(err)
+	}
+	columns := srv.QueryRuleColumns("foo", added.RuleID)
+	if _, ok := columns["error_msg"]; ok || string(columns["log"]) != "2" || string(columns["match_pattern"]) != `"^DELETE FROM reports"` {
+		t.Fatalf("expected the patch to replace the columns, got %v", columns)
+	}
+	// the models calls keep working and drop the extra columns
+	old, err := client.GetDatabase("foo")
+	if err != nil || len(old.QueryRules) != 2 {
+		t.Fatalf("expected 2 rules, got %v %v", old.QueryRules, err)
+	}
+}
+
+func TestServer_Hostgroups(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	mirror := []models.AddDatabaseRequestDatabaseInformation{{Name: "foo-mirror", IPAddress: "10.0.0.9"}}
+	_, err := client.AddInstanceGroup(api.AddDatabaseRequest{
+		AddDatabaseRequest: models.AddDatabaseRequest{InstanceName: "foo", Username: "foo", Password: "bar"},
+		Hostgroups:         []api.Hostgroup{{HostgroupID: 20, Servers: mirror, Comment: "mirror"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _, err := client.GetInstanceGroup("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Hostgroups) != 1 || db.Hostgroups[0].HostgroupID != 20 || db.Hostgroups[0].Comment != "mirror" || len(db.Hostgroups[0].Servers) != 1 {
+		t.Fatalf("unexpected hostgroups %+v", db.Hostgroups)
+	}
+
+	clash := []api.Hostgroup{{HostgroupID: apitest.DefaultReadHostGroup, Servers: mirror}}
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "foo"},
+		Hostgroups:            &clash,
+	})
+	statusErr := &api.StatusError{}
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 400 {
+		t.Fatalf("expected the read hostgroup to be rejected, got %v", err)
+	}
+
+	// leaving hostgroups out of a modify keeps them
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "foo", NewPassword: "baz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers := srv.Hostgroups("foo")[20]; len(servers) != 1 {
+		t.Fatalf("expected the mirror hostgroup to be kept, got %v", srv.Hostgroups("foo"))
+	}
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "foo"},
+		Hostgroups:            &[]api.Hostgroup{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostgroups := srv.Hostgroups("foo"); len(hostgroups) != 0 {
+		t.Fatalf("expected the hostgroups to be removed, got %v", hostgroups)
+	}
+}
+
+func TestServer_ReplicationHostgroups(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	pair := api.ReplicationHostgroup{WriterHostgroup: apitest.DefaultWriteHostGroup, ReaderHostgroup: apitest.DefaultReadHostGroup, CheckType: "read_only"}
+	_, err := client.AddInstanceGroup(api.AddDatabaseRequest{
+		AddDatabaseRequest: models.AddDatabaseRequest{
+			InstanceName:   "foo",
+			Username:       "foo",
+			Password:       "bar",
+			MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "foo", IPAddress: "10.0.0.2"},
+			ReadReplicas:   []models.AddDatabaseRequestDatabaseInformation{{Name: "foo-read", IPAddress: "10.0.0.3"}},
+		},
+		ReplicationHostgroups: []api.ReplicationHostgroup{pair},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision := srv.Revision("foo")
+	if !srv.Failover("foo", "foo-read") {
+		t.Fatal("expected foo-read to be promoted")
+	}
+	db, _, err := client.GetInstanceGroup("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.ReplicationHostgroups) != 1 || db.ReplicationHostgroups[0] != pair {
+		t.Fatalf("unexpected replication hostgroups %+v", db.ReplicationHostgroups)
+	}
+	if db.MasterInstance.Name != "foo-read" || db.ReadReplicas[0].Name != "foo" || srv.Revision("foo") != revision {
+		t.Fatalf("expected foo-read to be the writer at the same revision, got %+v", db.InstanceData)
+	}
+
+	unknown := []api.ReplicationHostgroup{{WriterHostgroup: apitest.DefaultWriteHostGroup, ReaderHostgroup: 99}}
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "foo"},
+		ReplicationHostgroups: &unknown,
+	})
+	statusErr := &api.StatusError{}
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 400 {
+		t.Fatalf("expected an unknown reader hostgroup to be rejected, got %v", err)
+	}
+}
+
+func TestServer_Monitor(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	client := newClient(t, srv)
+	_, err := client.AddInstanceGroup(api.AddDatabaseRequest{
+		AddDatabaseRequest: models.AddDatabaseRequest{
+			InstanceName:   "foo",
+			Username:       "foo",
+			Password:       "bar",
+			MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "foo", IPAddress: "10.0.0.2"},
+		},
+		Monitor: &api.MonitorCredentials{Username: "foo-monitor", Password: "baz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, _, err := client.GetInstanceGroup("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Monitor == nil || *db.Monitor != (api.MonitorCredentials{Username: "foo-monitor", Password: "baz"}) {
+		t.Fatalf("unexpected monitor credentials %+v", db.Monitor)
+	}
+
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "foo"},
+		Monitor:               &api.MonitorCredentials{Username: "foo-monitor"},
+	})
+	statusErr := &api.StatusError{}
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 400 {
+		t.Fatalf("expected a monitor without a password to be rejected, got %v", err)
+	}
+
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "foo"},
+		Monitor:               &api.MonitorCredentials{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username, password := srv.MonitorCredentials("foo"); username != "" || password != "" {
+		t.Fatalf("expected the chart's monitor user, got %s/%s", username, password)
+	}
+}
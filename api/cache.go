@@ -0,0 +1,208 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// bulkKey is the flight key used when every instance group is
+// fetched with a single GetDatabases call.
+const bulkKey = "*"
+
+// readCache is a short lived cache of GetDatabase responses. Concurrent
+// reads of the same instance group share one request, and any mutation
+// of an instance group drops it from the cache.
+//
+// The cache hands out the same InstanceData to every caller,
+// callers must not modify the slices inside of it.
+type readCache struct {
+	ttl  time.Duration
+	bulk bool
+	now  func() time.Time
+
+	mu sync.Mutex
+	// generation is bumped on every invalidation, fetches that started
+	// in an older generation don't get stored.
+	generation uint64
+	entries    map[string]cacheEntry
+	bulkUntil  time.Time
+	flights    map[string]*flight
+}
+
+type cacheEntry struct {
+	db       InstanceData
+	revision string
+	// hasRevision is false for entries filled by a bulk fetch from a
+	// server that doesn't return revisions in the list
+	hasRevision bool
+	expires     time.Time
+}
+
+// flight is a single in-progress fetch that other callers wait on.
+type flight struct {
+	wg    sync.WaitGroup
+	entry cacheEntry
+	err   error
+}
+
+// WithReadCache creates a ClientOption that caches GetDatabase responses
+// for ttl and collapses concurrent reads of the same instance group into
+// a single request. Mutations through the client invalidate the cache.
+func WithReadCache(ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		c.cache = newReadCache(ttl, false)
+	}
+}
+
+// WithBulkReadCache is WithReadCache, but a cache miss fetches every
+// instance group with a single GetDatabases call. Useful when a plan
+// reads most of the instance groups in a chester deployment.
+func WithBulkReadCache(ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		c.cache = newReadCache(ttl, true)
+	}
+}
+
+func newReadCache(ttl time.Duration, bulk bool) *readCache {
+	return &readCache{
+		ttl:     ttl,
+		bulk:    bulk,
+		now:     time.Now,
+		entries: map[string]cacheEntry{},
+		flights: map[string]*flight{},
+	}
+}
+
+// InvalidateCache drops instanceName from the read cache, an empty
+// instanceName drops everything. It's a no-op without a read cache.
+func (c *Client) InvalidateCache(instanceName string) {
+	if c.cache == nil {
+		return
+	}
+	c.cache.invalidate(instanceName)
+}
+
+func (rc *readCache) invalidate(instanceName string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.generation++
+	rc.bulkUntil = time.Time{}
+	if instanceName == "" {
+		rc.entries = map[string]cacheEntry{}
+		return
+	}
+	delete(rc.entries, instanceName)
+}
+
+// lookup returns a cached instance group that hasn't expired. When
+// needRevision is set, entries without a known revision are skipped.
+func (rc *readCache) lookup(instanceName string, needRevision bool) (cacheEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[instanceName]
+	if !ok || rc.now().After(entry.expires) || (needRevision && !entry.hasRevision) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// do runs fetch once per key and generation, every concurrent caller
+// with the same key gets the same result.
+func (rc *readCache) do(key string, fetch func() (cacheEntry, error)) (cacheEntry, error) {
+	rc.mu.Lock()
+	flightKey := fmt.Sprintf("%d/%s", rc.generation, key)
+	if f, ok := rc.flights[flightKey]; ok {
+		rc.mu.Unlock()
+		f.wg.Wait()
+		return f.entry, f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	rc.flights[flightKey] = f
+	rc.mu.Unlock()
+
+	f.entry, f.err = fetch()
+	f.wg.Done()
+
+	rc.mu.Lock()
+	delete(rc.flights, flightKey)
+	rc.mu.Unlock()
+	return f.entry, f.err
+}
+
+// store saves entries in the cache, unless the cache was invalidated
+// since the fetch started in generation.
+func (rc *readCache) store(generation uint64, entries ...cacheEntry) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if generation != rc.generation {
+		return
+	}
+	expires := rc.now().Add(rc.ttl)
+	for _, entry := range entries {
+		entry.expires = expires
+		rc.entries[entry.db.InstanceName] = entry
+	}
+}
+
+func (rc *readCache) currentGeneration() uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.generation
+}
+
+// bulkFresh reports whether a bulk fetch happened within the ttl, in
+// which case a missing instance group is fetched on its own rather than
+// triggering another bulk fetch.
+func (rc *readCache) bulkFresh() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.now().Before(rc.bulkUntil)
+}
+
+// cachedGetDatabase serves instanceName from the cache, fetching it on a miss.
+func (c *Client) cachedGetDatabase(instanceName string, needRevision bool) (InstanceData, string, error) {
+	rc := c.cache
+	if entry, ok := rc.lookup(instanceName, needRevision); ok {
+		return entry.db, entry.revision, nil
+	}
+	if rc.bulk && !rc.bulkFresh() {
+		generation := rc.currentGeneration()
+		_, err := rc.do(bulkKey, func() (cacheEntry, error) {
+			dbs, revisions, err := c.getDatabases()
+			if err != nil {
+				return cacheEntry{}, err
+			}
+			entries := make([]cacheEntry, len(dbs))
+			for i, db := range dbs {
+				entries[i] = cacheEntry{db: db, revision: revisions[i], hasRevision: revisions[i] != ""}
+			}
+			rc.store(generation, entries...)
+			rc.mu.Lock()
+			if generation == rc.generation {
+				rc.bulkUntil = rc.now().Add(rc.ttl)
+			}
+			rc.mu.Unlock()
+			return cacheEntry{}, nil
+		})
+		// a failed bulk fetch falls through to a single fetch, which
+		// surfaces its own error
+		if err == nil {
+			if entry, ok := rc.lookup(instanceName, needRevision); ok {
+				return entry.db, entry.revision, nil
+			}
+		}
+	}
+	generation := rc.currentGeneration()
+	entry, err := rc.do(instanceName, func() (cacheEntry, error) {
+		db, revision, err := c.getDatabase(instanceName)
+		if err != nil {
+			return cacheEntry{}, err
+		}
+		entry := cacheEntry{db: db, revision: revision, hasRevision: true}
+		rc.store(generation, entry)
+		return entry, nil
+	})
+	return entry.db, entry.revision, err
+}
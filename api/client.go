@@ -7,6 +7,8 @@ import (
 	"golang.org/x/oauth2"
 	"google.golang.org/api/idtoken"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 // ClientOption is an option wrapper for the client
@@ -17,6 +19,8 @@ type ClientOption func(*Client)
 type Client struct {
 	HostURL    string
 	HTTPClient *http.Client
+	baseURL    *url.URL
+	cache      *readCache
 	token      *oauth2.Token
 	Username   string
 	Password   string
@@ -55,7 +59,10 @@ func NewClient(host, user, pass, audience string) (*Client, error) {
 	c.HostURL = host
 	c.Username = user
 	c.Password = pass
-
+	c.baseURL, err = parseHostURL(host)
+	if err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
@@ -67,6 +74,13 @@ func NewClientWithOptions(opts ...ClientOption) (*Client, error) {
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.HostURL != "" {
+		u, err := parseHostURL(c.HostURL)
+		if err != nil {
+			return nil, err
+		}
+		c.baseURL = u
+	}
 	if c.audience != "" && c.token == nil {
 		ts, err := idtoken.NewTokenSource(context.Background(), c.audience)
 		if err != nil {
@@ -81,8 +95,26 @@ func NewClientWithOptions(opts ...ClientOption) (*Client, error) {
 	return c, nil
 }
 
+// parseHostURL parses the chester-api host once so every endpoint
+// can be joined onto it. The host may carry a base path, i.e.
+// https://example.com/chester, and trailing slashes are ignored.
+func parseHostURL(host string) (*url.URL, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host %s, error: %s", host, err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("host %s must be an absolute url, i.e. https://chester.example.com", host)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u, nil
+}
+
 // WithHost creates a ClientOption that modifies the client's
-// base host url
+// base host url, which may include a base path prefix
 func WithHost(host string) ClientOption {
 	return func(c *Client) {
 		c.HostURL = host
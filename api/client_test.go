@@ -1,197 +1,98 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api/apitest"
 )
 
-var (
-	mux       *http.ServeMux
-	server    *httptest.Server
-	client    *Client
-	username  string
-	password  string
-	databases []models.InstanceData
-)
-
-func testAuthenciateMiddleWare(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		basicuser, basicpass, ok := r.BasicAuth()
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		} else if basicuser != username || basicpass != password {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-func emptyResponse(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	dbList := []models.InstanceData{}
-	b, _ := json.Marshal(&dbList)
-	w.Write(b)
-}
-
-func setupBadHost() func() {
-	mux = http.NewServeMux()
-	server = httptest.NewServer(mux)
-	username = "foo"
-	password = "bar"
-	// skipping token for IAP auth here.
-	client, _ = NewClientWithOptions(WithHost("http://notarealplace.co.uk"), WithPassword(password), WithUsername(username))
-	return func() {
-		server.Close()
-	}
-}
-
-func setupBadPassword() func() {
-	mux = http.NewServeMux()
-	server = httptest.NewServer(mux)
-	username = "foo"
-	password = "bar"
-	// skipping token for IAP auth here.
-	client, _ = NewClientWithOptions(WithHost(server.URL), WithPassword("not_a_real_password"), WithUsername(username))
-	return func() {
-		server.Close()
-	}
-}
-
-func setupBadUser() func() {
-	mux = http.NewServeMux()
-	server = httptest.NewServer(mux)
-	password = "bar"
-	// skipping token for IAP auth here.
-	client, _ = NewClientWithOptions(WithHost(server.URL), WithPassword(password), WithUsername("not_a_real_user"))
-	return func() {
-		server.Close()
-	}
-}
-
-func setup() func() {
-	mux = http.NewServeMux()
-	server = httptest.NewServer(mux)
-	username = "foo"
-	password = "bar"
-	// reverting to empty list here
-	databases = []models.InstanceData{}
-	dbOneReadReplicas := []models.AddDatabaseRequestDatabaseInformation{}
-	dbOneReadOne := models.AddDatabaseRequestDatabaseInformation{
-		Name:      "foo-reader-one",
-		IPAddress: "1.2.3.5",
-	}
-	dbOneReadTwo := models.AddDatabaseRequestDatabaseInformation{
-		Name:      "foo-reader-two",
-		IPAddress: "1.2.3.6",
-	}
-	dbOneReadReplicas = append(dbOneReadReplicas, dbOneReadOne, dbOneReadTwo)
-	dbOneQueryRules := []models.ProxySqlMySqlQueryRule{}
-	dbOneQueryRuleOne := models.ProxySqlMySqlQueryRule{
-		RuleID:               1,
-		Username:             "foo",
-		Active:               1,
-		MatchDigest:          "foo",
-		DestinationHostgroup: 5,
-		Apply:                1,
-		Comment:              "bar",
-	}
-	dbOneQueryRuleTwo := models.ProxySqlMySqlQueryRule{
-		RuleID:               2,
-		Username:             "foo",
-		Active:               10,
-		MatchDigest:          "foo",
-		DestinationHostgroup: 5,
-		Apply:                1,
-		Comment:              "bar",
-	}
-	dbOneQueryRules = append(dbOneQueryRules, dbOneQueryRuleOne, dbOneQueryRuleTwo)
-	dbOne := models.InstanceData{
-		InstanceName:   "foo",
-		ReadHostGroup:  5,
-		WriteHostGroup: 10,
-		Username:       "foo",
-		Password:       "bar",
-		QueryRules:     dbOneQueryRules,
-		MasterInstance: models.AddDatabaseRequestDatabaseInformation{
-			Name:      "foo",
-			IPAddress: "1.2.3.4",
+// testDatabases are the instance groups newTestServer seeds.
+func testDatabases() []models.InstanceData {
+	return []models.InstanceData{
+		{
+			InstanceName:   "foo",
+			ReadHostGroup:  5,
+			WriteHostGroup: 10,
+			Username:       "foo",
+			Password:       "bar",
+			QueryRules: []models.ProxySqlMySqlQueryRule{
+				{RuleID: 1, Username: "foo", Active: 1, MatchDigest: "foo", DestinationHostgroup: 5, Apply: 1, Comment: "bar"},
+				{RuleID: 2, Username: "foo", Active: 1, MatchDigest: "foo", DestinationHostgroup: 10, Apply: 1, Comment: "bar"},
+			},
+			MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "foo", IPAddress: "1.2.3.4"},
+			ReadReplicas: []models.AddDatabaseRequestDatabaseInformation{
+				{Name: "foo-reader-one", IPAddress: "1.2.3.5"},
+				{Name: "foo-reader-two", IPAddress: "1.2.3.6"},
+			},
+			ChesterMetaData: models.ChesterMetaData{InstanceGroup: "foo", MaxChesterInstances: 2},
 		},
-		ReadReplicas: dbOneReadReplicas,
-		UseSSL:       0,
-		ChesterMetaData: models.ChesterMetaData{
-			InstanceGroup:       "foo",
-			MaxChesterInstances: 2,
+		{
+			InstanceName:   "shaz",
+			ReadHostGroup:  5,
+			WriteHostGroup: 10,
+			Username:       "shaz",
+			Password:       "bot",
+			QueryRules: []models.ProxySqlMySqlQueryRule{
+				{RuleID: 3, Username: "shaz", Active: 1, MatchDigest: "shaz", DestinationHostgroup: 5, Apply: 1, Comment: "bot"},
+				{RuleID: 4, Username: "shaz", Active: 1, MatchDigest: "shaz", DestinationHostgroup: 10, Apply: 1, Comment: "bot"},
+			},
+			MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "shaz", IPAddress: "1.2.3.8"},
+			ReadReplicas: []models.AddDatabaseRequestDatabaseInformation{
+				{Name: "shaz-reader-one", IPAddress: "1.2.3.7"},
+				{Name: "shaz-reader-two", IPAddress: "1.2.3.8"},
+			},
+			ChesterMetaData: models.ChesterMetaData{InstanceGroup: "shaz", MaxChesterInstances: 3},
 		},
 	}
-	dbTwoReadReplicas := []models.AddDatabaseRequestDatabaseInformation{}
-	dbTwoReadOne := models.AddDatabaseRequestDatabaseInformation{
-		Name:      "shaz-reader-one",
-		IPAddress: "1.2.3.7",
-	}
-	dbTwoReadTwo := models.AddDatabaseRequestDatabaseInformation{
-		Name:      "shaz-reader-two",
-		IPAddress: "1.2.3.8",
-	}
-	dbTwoReadReplicas = append(dbTwoReadReplicas, dbTwoReadOne, dbTwoReadTwo)
-	dbTwoQueryRules := []models.ProxySqlMySqlQueryRule{}
-	dbTwoQueryRuleOne := models.ProxySqlMySqlQueryRule{
-		RuleID:               1,
-		Username:             "shaz",
-		Active:               1,
-		MatchDigest:          "shaz",
-		DestinationHostgroup: 5,
-		Apply:                1,
-		Comment:              "bot",
-	}
-	dbTwoQueryRuleTwo := models.ProxySqlMySqlQueryRule{
-		RuleID:               2,
-		Username:             "shaz",
-		Active:               1,
-		MatchDigest:          "shaz",
-		DestinationHostgroup: 10,
-		Apply:                1,
-		Comment:              "bot",
-	}
-	dbTwoQueryRules = append(dbTwoQueryRules, dbTwoQueryRuleOne, dbTwoQueryRuleTwo)
-	dbTwo := models.InstanceData{
-		InstanceName:   "shaz",
-		ReadHostGroup:  5,
-		WriteHostGroup: 10,
-		Username:       "shaz",
-		Password:       "bot",
-		QueryRules:     dbTwoQueryRules,
-		MasterInstance: models.AddDatabaseRequestDatabaseInformation{
-			Name:      "shaz",
-			IPAddress: "1.2.3.8",
-		},
-		ReadReplicas: dbTwoReadReplicas,
-		UseSSL:       0,
-		ChesterMetaData: models.ChesterMetaData{
-			InstanceGroup:       "shaz",
-			MaxChesterInstances: 3,
-		},
+}
+
+// newTestServer starts an apitest server seeded with testDatabases, and a
+// client for it built with opts on top of the server's host and
+// credentials.
+func newTestServer(t *testing.T, opts ...ClientOption) (*apitest.Server, *Client) {
+	t.Helper()
+	srv := apitest.NewServer()
+	t.Cleanup(srv.Close)
+	for _, db := range testDatabases() {
+		srv.PutDatabase(db)
+	}
+	opts = append([]ClientOption{WithHost(srv.URL), WithUsername(srv.Username), WithPassword(srv.Password)}, opts...)
+	client, err := NewClientWithOptions(opts...)
+	if err != nil {
+		t.Fatalf("failed to create client %s", err.Error())
 	}
-	databases = append(databases, dbOne, dbTwo)
-	// skipping token for IAP auth here.
-	client, _ = NewClientWithOptions(WithHost(server.URL), WithPassword(password), WithUsername(username))
-	return func() {
-		server.Close()
+	return srv, client
+}
+
+// countRequests counts the requests srv received that equal request.
+func countRequests(srv *apitest.Server, request string) int {
+	count := 0
+	for _, r := range srv.Requests() {
+		if r == request {
+			count++
+		}
 	}
+	return count
 }
 
 // TestNewClient_AuthPass is the basic functionality of our basic auth setup
 func TestNewClient_AuthPass(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-	emptyHandler := http.HandlerFunc(emptyResponse)
-	mux.Handle("/databases", testAuthenciateMiddleWare(emptyHandler))
+	_, client := newTestServer(t)
 	_, err := client.GetDatabases()
 	if err != nil {
 		t.Error(err)
@@ -202,10 +103,7 @@ func TestNewClient_AuthPass(t *testing.T) {
 // TestNewClient_AuthFailBadUser checks for a bad username
 // if no error is returned, this fails.
 func TestNewClient_AuthFailBadUser(t *testing.T) {
-	teardown := setupBadUser()
-	defer teardown()
-	emptyHandler := http.HandlerFunc(emptyResponse)
-	mux.Handle("/databases", testAuthenciateMiddleWare(emptyHandler))
+	_, client := newTestServer(t, WithUsername("not_a_real_user"))
 	_, err := client.GetDatabases()
 	if err == nil {
 		t.FailNow()
@@ -215,10 +113,7 @@ func TestNewClient_AuthFailBadUser(t *testing.T) {
 // TestNewClient_AuthFailBadPassword checks for a bad password
 // if no error is returned, this fails.
 func TestNewClient_AuthFailBadPassword(t *testing.T) {
-	teardown := setupBadPassword()
-	defer teardown()
-	emptyHandler := http.HandlerFunc(emptyResponse)
-	mux.Handle("/databases", testAuthenciateMiddleWare(emptyHandler))
+	_, client := newTestServer(t, WithPassword("not_a_real_password"))
 	_, err := client.GetDatabases()
 	if err == nil {
 		t.FailNow()
@@ -228,38 +123,25 @@ func TestNewClient_AuthFailBadPassword(t *testing.T) {
 // TestNewClient_AuthFailBadHost checks for a bad password
 // if no error is returned, this fails.
 func TestNewClient_AuthFailBadHost(t *testing.T) {
-	teardown := setupBadHost()
-	defer teardown()
-	emptyHandler := http.HandlerFunc(emptyResponse)
-	mux.Handle("/databases", testAuthenciateMiddleWare(emptyHandler))
+	_, client := newTestServer(t, WithHost("http://notarealplace.co.uk"))
 	_, err := client.GetDatabases()
 	if err == nil {
 		t.FailNow()
 	}
 }
 
-func getDatabasesHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	b, err := json.Marshal(&databases)
-	if err != nil {
-		http.Error(w, "failed to marshal json", http.StatusInternalServerError)
-		return
-	}
-	w.Write(b)
-}
-
 // TestClient_GetDatabases checks that we're able to query all
 // affected databases.
 func TestClient_GetDatabases(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-	mux.HandleFunc("/databases", http.HandlerFunc(getDatabasesHandler))
+	_, client := newTestServer(t)
 	dbs, err := client.GetDatabases()
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
 	}
+	if len(dbs) != len(testDatabases()) {
+		t.Fatalf("expected %d databases, got %d", len(testDatabases()), len(dbs))
+	}
 	// doing some checking that we're good on our end
 	for _, db := range dbs {
 		if db.MasterInstance.Name != db.InstanceName {
@@ -269,20 +151,7 @@ func TestClient_GetDatabases(t *testing.T) {
 }
 
 func TestClient_GetDatabaseByName(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-	mux.HandleFunc("/databases/foo", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		for _, db := range databases {
-			if db.InstanceName == "foo" {
-				b, err := json.Marshal(db)
-				if err != nil {
-					t.Fatalf("failed to marshal response from mock server %s", err.Error())
-				}
-				w.Write(b)
-			}
-		}
-	})
+	_, client := newTestServer(t)
 	db, err := client.GetDatabase("foo")
 	if err != nil {
 		t.Error(err)
@@ -296,112 +165,17 @@ func TestClient_GetDatabaseByName(t *testing.T) {
 // TestClient_GetDatabaseByNameFail tests that we're returning a 404
 // on a databases that doesn't exist
 func TestClient_GetDatabaseByNameFail(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-	mux.HandleFunc("/databases/foo", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, "not found", http.StatusNotFound)
-	})
-	_, err := client.GetDatabase("foo")
-	if err == nil {
-		t.FailNow()
+	_, client := newTestServer(t)
+	_, err := client.GetDatabase("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
 	}
 }
 
 // TestClient_AddDatabase tests the functionality of adding a new instance
 // group, as well as the ability to retreive that instance group
 func TestClient_AddDatabase(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		addDbRequest := models.AddDatabaseRequest{}
-		err := json.NewDecoder(r.Body).Decode(&addDbRequest)
-		if err != nil {
-			http.Error(w, "failed to parse json", http.StatusBadRequest)
-		}
-		newDatabase := models.InstanceData{
-			InstanceName:    addDbRequest.InstanceName,
-			ReadHostGroup:   10,
-			WriteHostGroup:  5,
-			Username:        addDbRequest.Username,
-			Password:        addDbRequest.Password,
-			QueryRules:      addDbRequest.QueryRules,
-			MasterInstance:  addDbRequest.MasterInstance,
-			ReadReplicas:    addDbRequest.ReadReplicas,
-			UseSSL:          0,
-			ChesterMetaData: addDbRequest.ChesterMetaData,
-		}
-		databases = append(databases, newDatabase)
-		addDataBaseResponse := models.AddDatabaseResponse{
-			Action:          "add",
-			QueryRules:      addDbRequest.QueryRules,
-			InstanceName:    addDbRequest.InstanceName,
-			Username:        addDbRequest.Username,
-			Password:        addDbRequest.Password,
-			WriteHostGroup:  5,
-			ReadHostGroup:   10,
-			SSLEnabled:      0,
-			ChesterMetaData: addDbRequest.ChesterMetaData,
-		}
-		respBytes, err := json.Marshal(&addDataBaseResponse)
-		if err != nil {
-			http.Error(w, "failed to marshal response", http.StatusInternalServerError)
-		}
-		w.WriteHeader(200)
-		w.Write(respBytes)
-	})
-	mux.HandleFunc("/databases/temp", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		dbCheck := false
-		for _, db := range databases {
-			if db.InstanceName == "temp" {
-				dbCheck = true
-				b, err := json.Marshal(db)
-				if err != nil {
-					t.Fatalf("failed to marshal response from mock server %s", err.Error())
-				}
-				w.Write(b)
-			}
-		}
-		if !dbCheck {
-			http.Error(w, "instance not found", http.StatusNotFound)
-		}
-	})
-	mux.HandleFunc("/databases", http.HandlerFunc(getDatabasesHandler))
-
-	addDbReadReplicaOne := models.AddDatabaseRequestDatabaseInformation{
-		Name:      "foo-read-1",
-		IPAddress: "2.3.4.6",
-	}
-	addDbReadReplicaTwo := models.AddDatabaseRequestDatabaseInformation{
-		Name:      "foo-read-2",
-		IPAddress: "2.3.4.7",
-	}
-	addDbReadReplicas := []models.AddDatabaseRequestDatabaseInformation{
-		addDbReadReplicaOne, addDbReadReplicaTwo,
-	}
-	addDbQueryRuleOne := models.ProxySqlMySqlQueryRule{
-		RuleID:               1,
-		Username:             "foo",
-		Active:               1,
-		MatchDigest:          "bar",
-		DestinationHostgroup: 5,
-		Apply:                1,
-		Comment:              "baz",
-	}
-	addDbQueryRuleTwo := models.ProxySqlMySqlQueryRule{
-		RuleID:               2,
-		Username:             "foo",
-		Active:               1,
-		MatchDigest:          "barzoople",
-		DestinationHostgroup: 10,
-		Apply:                1,
-		Comment:              "baz",
-	}
-	addDbQueryRules := []models.ProxySqlMySqlQueryRule{
-		addDbQueryRuleOne, addDbQueryRuleTwo,
-	}
+	_, client := newTestServer(t)
 	addDbRequest := models.AddDatabaseRequest{
 		Action:       "add",
 		InstanceName: "temp",
@@ -411,16 +185,18 @@ func TestClient_AddDatabase(t *testing.T) {
 			Name:      "foo",
 			IPAddress: "2.3.4.5",
 		},
-		ReadReplicas: addDbReadReplicas,
-		QueryRules:   addDbQueryRules,
+		ReadReplicas: []models.AddDatabaseRequestDatabaseInformation{
+			{Name: "foo-read-1", IPAddress: "2.3.4.6"},
+			{Name: "foo-read-2", IPAddress: "2.3.4.7"},
+		},
+		QueryRules: []models.ProxySqlMySqlQueryRule{
+			{Username: "foo", Active: 1, MatchDigest: "bar", DestinationHostgroup: 5, Apply: 1, Comment: "baz"},
+			{Username: "foo", Active: 1, MatchDigest: "barzoople", DestinationHostgroup: 10, Apply: 1, Comment: "baz"},
+		},
 		ChesterMetaData: models.ChesterMetaData{
 			InstanceGroup:       "temp",
 			MaxChesterInstances: 3,
 		},
-		KeyData:   "",
-		CertData:  "",
-		CAData:    "",
-		EnableSSL: 0,
 	}
 	_, err := client.AddDatabase(addDbRequest)
 	if err != nil {
@@ -433,60 +209,22 @@ func TestClient_AddDatabase(t *testing.T) {
 		t.FailNow()
 	}
 	if len(dbs) != 3 {
-		t.Errorf("wtf %v", len(dbs))
-		t.FailNow()
+		t.Fatalf("expected 3 databases, got %d", len(dbs))
 	}
 	db, err := client.GetDatabase("temp")
 	if err != nil {
 		t.Error(err)
 		t.FailNow()
 	}
-	if db.InstanceName != "temp" {
-		t.Errorf("wrong instance %s", db.InstanceName)
-		t.FailNow()
+	if db.InstanceName != "temp" || len(db.ReadReplicas) != 2 || len(db.QueryRules) != 2 {
+		t.Fatalf("unexpected instance group %+v", db)
 	}
 }
 
 // TestClient_RemoveDatabase tests the functionality of removing an
 // existing instance group.
 func TestClient_RemoveDatabase(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-	mux.HandleFunc("/databases", http.HandlerFunc(getDatabasesHandler))
-	mux.HandleFunc("/databases/foo", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		dbCheck := false
-		for _, db := range databases {
-			if db.InstanceName == "foo" {
-				dbCheck = true
-				b, err := json.Marshal(&db)
-				if err != nil {
-					http.Error(w, "failed to marshal response", http.StatusInternalServerError)
-					return
-				}
-				w.Write(b)
-				return
-			}
-		}
-		if !dbCheck {
-			http.Error(w, "database not found", http.StatusNotFound)
-			return
-		}
-	})
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		removeDbRequest := models.RemoveDatabaseRequest{}
-		err := json.NewDecoder(r.Body).Decode(&removeDbRequest)
-		if err != nil {
-			http.Error(w, "failed to parse json", http.StatusBadRequest)
-			return
-		}
-		for index, db := range databases {
-			if db.InstanceName == removeDbRequest.InstanceName {
-				databases = append(databases[:index], databases[index+1:]...)
-			}
-		}
-	})
+	_, client := newTestServer(t)
 	removeDataBaseReq := models.RemoveDatabaseRequest{
 		Action:       "remove",
 		InstanceName: "foo",
@@ -514,110 +252,10 @@ func TestClient_RemoveDatabase(t *testing.T) {
 }
 
 func TestClient_ModifyDatabase(t *testing.T) {
-	teardown := setup()
-	defer teardown()
-	mux.HandleFunc("/databases", http.HandlerFunc(getDatabasesHandler))
-	mux.HandleFunc("/databases/foo", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		dbCheck := false
-		for _, db := range databases {
-			if db.InstanceName == "foo" {
-				dbCheck = true
-				b, err := json.Marshal(&db)
-				if err != nil {
-					http.Error(w, "failed to marshal response", http.StatusInternalServerError)
-					return
-				}
-				w.Write(b)
-				return
-			}
-		}
-		if !dbCheck {
-			http.Error(w, "database not found", http.StatusNotFound)
-			return
-		}
-	})
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPatch {
-			http.Error(w, "invalid method", http.StatusMethodNotAllowed)
-			return
-		}
-		modifyRequest := models.ModifyDatabaseRequest{}
-		err := json.NewDecoder(r.Body).Decode(&modifyRequest)
-		if err != nil {
-			http.Error(w, "failed to decode", http.StatusInternalServerError)
-		}
-		// get the DB
-		database := models.InstanceData{}
-		for _, db := range databases {
-			if db.InstanceName == "foo" {
-				database = db
-				break
-			}
-		}
-		if modifyRequest.ReadReplicas != nil {
-			database.ReadReplicas = modifyRequest.ReadReplicas
-			for index, db := range databases {
-				if db.InstanceName == modifyRequest.InstanceName {
-					// remove the database
-					databases = append(databases[:index], databases[index+1:]...)
-					// add the same one back
-					databases = append(databases, database)
-					break
-				}
-			}
-		}
-		if modifyRequest.RemoveQueryRules != nil {
-			newQueryRules := []models.ProxySqlMySqlQueryRule{}
-			for _, qr := range database.QueryRules {
-				if qr.RuleID != modifyRequest.RemoveQueryRules[0] {
-					newQueryRules = append(newQueryRules, qr)
-				}
-			}
-			database.QueryRules = newQueryRules
-			for index, db := range databases {
-				if db.InstanceName == modifyRequest.InstanceName {
-					// remove the database
-					databases = append(databases[:index], databases[index+1:]...)
-					// add the same one back
-					databases = append(databases, database)
-					break
-				}
-			}
-		}
-		if modifyRequest.AddQueryRules != nil {
-			database.QueryRules = append(database.QueryRules, modifyRequest.AddQueryRules...)
-			for index, db := range databases {
-				if db.InstanceName == modifyRequest.InstanceName {
-					// remove the database
-					databases = append(databases[:index], databases[index+1:]...)
-					// add the same one back
-					databases = append(databases, database)
-					break
-				}
-			}
-		}
-		if database.ChesterMetaData.MaxChesterInstances != modifyRequest.ChesterMetaData.MaxChesterInstances {
-			database.ChesterMetaData = modifyRequest.ChesterMetaData
-			// just replacing the database here since there isn't any "backend" to update.
-			for index, db := range databases {
-				if db.InstanceName == modifyRequest.InstanceName {
-					// remove the database
-					databases = append(databases[:index], databases[index+1:]...)
-					// add the same one back
-					databases = append(databases, database)
-					break
-				}
-			}
-		}
-
-	})
+	_, client := newTestServer(t)
 	modifyDatabaseRequest := models.ModifyDatabaseRequest{
-		Action:           "modify",
-		InstanceName:     "foo",
-		AddQueryRules:    nil,
-		RemoveQueryRules: nil,
-		ReadReplicas:     nil,
+		Action:       "modify",
+		InstanceName: "foo",
 		ChesterMetaData: models.ChesterMetaData{
 			InstanceGroup:       "foo",
 			MaxChesterInstances: 20,
@@ -652,27 +290,11 @@ func TestClient_ModifyDatabase(t *testing.T) {
 		t.Error("failed to remove query rule")
 		t.FailNow()
 	}
-	newQueryRuleOne := models.ProxySqlMySqlQueryRule{
-		RuleID:               3,
-		Username:             "rick",
-		Active:               1,
-		MatchDigest:          "never",
-		DestinationHostgroup: 5,
-		Apply:                1,
-		Comment:              "gonna",
-	}
-	newQueryRuleTwo := models.ProxySqlMySqlQueryRule{
-		RuleID:               4,
-		Username:             "astley",
-		Active:               1,
-		MatchDigest:          "give",
-		DestinationHostgroup: 10,
-		Apply:                1,
-		Comment:              "you",
-	}
 	modifyDatabaseRequest.RemoveQueryRules = nil
-	newQueryRules := []models.ProxySqlMySqlQueryRule{newQueryRuleOne, newQueryRuleTwo}
-	modifyDatabaseRequest.AddQueryRules = newQueryRules
+	modifyDatabaseRequest.AddQueryRules = []models.ProxySqlMySqlQueryRule{
+		{RuleID: 5, Username: "rick", Active: 1, MatchDigest: "never", DestinationHostgroup: 5, Apply: 1, Comment: "gonna"},
+		{RuleID: 6, Username: "astley", Active: 1, MatchDigest: "give", DestinationHostgroup: 10, Apply: 1, Comment: "you"},
+	}
 	err = client.ModifyDatabase(modifyDatabaseRequest)
 	if err != nil {
 		t.Errorf("failed to add query rules %s", err.Error())
@@ -687,11 +309,10 @@ func TestClient_ModifyDatabase(t *testing.T) {
 		t.Error("failed to add more query rules")
 		t.FailNow()
 	}
-	readReplica := models.AddDatabaseRequestDatabaseInformation{
-		Name:      "reader-three",
-		IPAddress: "2.3.4.6",
+	modifyDatabaseRequest.AddQueryRules = nil
+	modifyDatabaseRequest.ReadReplicas = []models.AddDatabaseRequestDatabaseInformation{
+		{Name: "reader-three", IPAddress: "2.3.4.6"},
 	}
-	modifyDatabaseRequest.ReadReplicas = []models.AddDatabaseRequestDatabaseInformation{readReplica}
 	err = client.ModifyDatabase(modifyDatabaseRequest)
 	if err != nil {
 		t.Errorf("failed to modify database: %s", err.Error())
@@ -707,3 +328,566 @@ func TestClient_ModifyDatabase(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// TestClient_BasePathAndTrailingSlash checks that a host with a base path
+// and a trailing slash still resolves to the right endpoints.
+func TestClient_BasePathAndTrailingSlash(t *testing.T) {
+	srv, _ := newTestServer(t)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// chester-api behind a proxy that serves it under /chester, where
+	// add, modify and remove go to the base path itself
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/chester":
+			r.URL.Path = "/"
+		case strings.HasPrefix(r.URL.Path, "/chester/") && r.URL.Path != "/chester/":
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, "/chester")
+		default:
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == "/databases" && r.URL.Query().Get("filter") != "true" {
+			http.Error(w, "missing filter", http.StatusBadRequest)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer front.Close()
+	basePathClient, err := NewClientWithOptions(WithHost(front.URL+"/chester/"), WithPassword(srv.Password), WithUsername(srv.Username))
+	if err != nil {
+		t.Fatalf("failed to create client %s", err.Error())
+	}
+	dbs, err := basePathClient.GetDatabases()
+	if err != nil {
+		t.Fatalf("failed to get databases %s", err.Error())
+	}
+	if len(dbs) != len(testDatabases()) {
+		t.Fatalf("expected %d databases, got %d", len(testDatabases()), len(dbs))
+	}
+	_, err = basePathClient.AddDatabase(models.AddDatabaseRequest{InstanceName: "temp", Username: "temp", Password: "temp"})
+	if err != nil {
+		t.Fatalf("failed to post to the base path %s", err.Error())
+	}
+	if _, ok := srv.Database("temp"); !ok {
+		t.Fatal("expected the add to reach chester-api")
+	}
+}
+
+// TestClient_WithoutConstructor checks that a Client built as a struct
+// literal can be shared between goroutines, run with -race.
+func TestClient_WithoutConstructor(t *testing.T) {
+	srv, _ := newTestServer(t)
+	literal := &Client{HostURL: srv.URL, HTTPClient: &http.Client{}, Username: srv.Username, Password: srv.Password}
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		name := fmt.Sprintf("temp-%d", i)
+		go func() {
+			_, err := literal.AddDatabase(models.AddDatabaseRequest{InstanceName: name, Username: name, Password: "bar"})
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("failed to add database %s", err.Error())
+		}
+	}
+	if requests := countRequests(srv, "POST /"); requests != cap(errs) {
+		t.Fatalf("expected %d adds on /, got %v", cap(errs), srv.Requests())
+	}
+}
+
+// TestClient_EscapesPathSegments checks that names with special
+// characters are sent as a single escaped path segment.
+func TestClient_EscapesPathSegments(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.PutDatabase(models.InstanceData{
+		InstanceName: "foo bar",
+		Username:     "foo/bar?baz",
+		Password:     "bar",
+		QueryRules:   []models.ProxySqlMySqlQueryRule{{RuleID: 12, Username: "foo/bar?baz", Active: 1, MatchDigest: ".*", Apply: 1}},
+	})
+	if _, err := client.GetUser("foo/bar?baz"); err != nil {
+		t.Fatalf("failed to get user %s", err.Error())
+	}
+	if _, err := client.GetDatabase("foo bar"); err != nil {
+		t.Fatalf("failed to get database %s", err.Error())
+	}
+	if err := client.ModifyQueryRuleByID(models.ProxySqlMySqlQueryRule{RuleID: 12}); err != nil {
+		t.Fatalf("failed to modify query rule %s", err.Error())
+	}
+	want := []string{"GET /users/foo%2Fbar%3Fbaz", "GET /databases/foo%20bar", "PATCH /queryrules/12"}
+	if requests := srv.Requests(); !reflect.DeepEqual(requests, want) {
+		t.Fatalf("expected requests %v, got %v", want, requests)
+	}
+}
+
+// TestNewClientWithOptions_BadHost checks that relative or unparsable
+// hosts are rejected when the client is built.
+func TestNewClientWithOptions_BadHost(t *testing.T) {
+	for _, host := range []string{"notarealplace.co.uk", "http://[::1", "/chester"} {
+		if _, err := NewClientWithOptions(WithHost(host)); err == nil {
+			t.Errorf("expected an error for host %s", host)
+		}
+	}
+}
+
+// TestClient_ListDatabases checks the query string and a single page.
+func TestClient_ListDatabases(t *testing.T) {
+	srv, client := newTestServer(t)
+	srv.PutDatabase(models.InstanceData{InstanceName: "shazam", Username: "shazam", Password: "bot"})
+	srv.SetLabels("shaz", map[string]string{"team": "orders", "env": "prod"})
+	srv.SetLabels("shazam", map[string]string{"team": "orders", "env": "dev"})
+	page, err := client.ListDatabases(context.Background(), ListOptions{
+		Filter:     true,
+		PageSize:   1,
+		NamePrefix: "sh",
+		Labels:     map[string]string{"team": "orders", "env": "prod"},
+	})
+	if err != nil {
+		t.Fatalf("failed to list databases %s", err.Error())
+	}
+	if len(page.Databases) != 1 || page.Databases[0].InstanceName != "shaz" {
+		t.Fatalf("unexpected page %v", page.Databases)
+	}
+	if page.NextPageToken != "" {
+		t.Fatalf("expected last page, got token %s", page.NextPageToken)
+	}
+	page, err = client.ListDatabases(context.Background(), ListOptions{PageSize: 1, NamePrefix: "sh"})
+	if err != nil {
+		t.Fatalf("failed to list databases %s", err.Error())
+	}
+	if len(page.Databases) != 1 || page.NextPageToken == "" {
+		t.Fatalf("expected the first of two pages, got %v with token %q", page.Databases, page.NextPageToken)
+	}
+}
+
+// TestClient_ListAllDatabases checks that the iterator walks every page.
+func TestClient_ListAllDatabases(t *testing.T) {
+	srv, client := newTestServer(t)
+	dbs, err := client.ListAllDatabases(context.Background(), ListOptions{Filter: true, PageSize: 1})
+	if err != nil {
+		t.Fatalf("failed to list databases %s", err.Error())
+	}
+	if len(dbs) != len(testDatabases()) {
+		t.Fatalf("expected %d databases, got %d", len(testDatabases()), len(dbs))
+	}
+	if requests := countRequests(srv, "GET /databases"); requests != len(testDatabases()) {
+		t.Fatalf("expected a request per page, got %v", srv.Requests())
+	}
+}
+
+// TestClient_ListDatabasesUnpaged checks that servers returning a plain
+// list are read as a single page.
+func TestClient_ListDatabasesUnpaged(t *testing.T) {
+	_, client := newTestServer(t)
+	dbs, err := client.ListAllDatabases(context.Background(), ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list databases %s", err.Error())
+	}
+	if len(dbs) != len(testDatabases()) {
+		t.Fatalf("expected %d databases, got %d", len(testDatabases()), len(dbs))
+	}
+}
+
+// TestClient_ListDatabasesRepeatedToken checks that a server handing back
+// the same page token doesn't loop forever. apitest hands out proper
+// tokens, so this one misbehaves on purpose.
+func TestClient_ListDatabasesRepeatedToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"databases": [], "next_page_token": "again"}`))
+	}))
+	defer server.Close()
+	client, err := NewClientWithOptions(WithHost(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ListAllDatabases(context.Background(), ListOptions{})
+	if err == nil {
+		t.Fatal("expected an error for a repeated page token")
+	}
+}
+
+// TestClient_ReadCacheCoalesces checks that concurrent reads share a
+// request and that later reads are served from the cache.
+func TestClient_ReadCacheCoalesces(t *testing.T) {
+	srv, cachedClient := newTestServer(t, WithReadCache(time.Minute))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cachedClient.GetDatabase("foo"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := cachedClient.GetDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := countRequests(srv, "GET /databases/foo"); got != 1 {
+		t.Fatalf("expected 1 request, got %d", got)
+	}
+	if _, err := cachedClient.GetDatabase("missing"); err == nil {
+		t.Fatal("expected an error for a missing database")
+	}
+}
+
+// TestClient_ReadCacheInvalidation checks that mutating an instance group
+// drops it from the cache and that entries expire.
+func TestClient_ReadCacheInvalidation(t *testing.T) {
+	srv, cachedClient := newTestServer(t, WithReadCache(time.Minute))
+	now := time.Now()
+	cachedClient.cache.now = func() time.Time { return now }
+	gets := func() int {
+		return countRequests(srv, "GET /databases/foo") + countRequests(srv, "GET /databases/shaz")
+	}
+	cachedClient.GetDatabase("foo")
+	cachedClient.GetDatabase("shaz")
+	if err := cachedClient.ModifyDatabase(models.ModifyDatabaseRequest{InstanceName: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	cachedClient.GetDatabase("foo")
+	cachedClient.GetDatabase("shaz")
+	if got := gets(); got != 3 {
+		t.Fatalf("expected 3 requests, got %d", got)
+	}
+	now = now.Add(2 * time.Minute)
+	cachedClient.GetDatabase("shaz")
+	if got := gets(); got != 4 {
+		t.Fatalf("expected expired entry to be fetched again, got %d requests", got)
+	}
+}
+
+// TestClient_BulkReadCache checks that a bulk cache fills every instance
+// group from one GetDatabases call.
+func TestClient_BulkReadCache(t *testing.T) {
+	srv, cachedClient := newTestServer(t, WithBulkReadCache(time.Minute))
+	for _, name := range []string{"foo", "shaz", "foo"} {
+		db, err := cachedClient.GetDatabase(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.InstanceName != name {
+			t.Fatalf("expected %s, got %s", name, db.InstanceName)
+		}
+	}
+	if _, err := cachedClient.GetDatabase("missing"); err == nil {
+		t.Fatal("expected an error for a missing database")
+	}
+	want := []string{"GET /databases", "GET /databases/missing"}
+	if requests := srv.Requests(); !reflect.DeepEqual(requests, want) {
+		t.Fatalf("expected 1 list and 1 get, got %v", requests)
+	}
+}
+
+// TestClient_Revisions checks that revisions are read from the ETag, sent
+// as If-Match and that a 412 surfaces as a PreconditionFailedError.
+func TestClient_Revisions(t *testing.T) {
+	srv, client := newTestServer(t)
+	_, rev, err := client.GetDatabaseRevision("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev == "" || rev != srv.Revision("foo") {
+		t.Fatalf("expected revision %s, got %s", srv.Revision("foo"), rev)
+	}
+	var newRev string
+	err = client.ModifyDatabase(models.ModifyDatabaseRequest{InstanceName: "foo"}, IfMatch(rev), CaptureRevision(&newRev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newRev == rev || newRev != srv.Revision("foo") {
+		t.Fatalf("expected captured revision %s, got %s", srv.Revision("foo"), newRev)
+	}
+	err = client.ModifyDatabase(models.ModifyDatabaseRequest{InstanceName: "foo"}, IfMatch(rev))
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected a precondition failure, got %v", err)
+	}
+	pfe := &PreconditionFailedError{}
+	if !errors.As(err, &pfe) || pfe.InstanceName != "foo" || pfe.Revision != rev {
+		t.Fatalf("unexpected precondition error %v", err)
+	}
+	err = client.RemoveDatabase(models.RemoveDatabaseRequest{InstanceName: "foo"}, IfMatch(rev))
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected a precondition failure, got %v", err)
+	}
+	err = client.ModifyQueryRuleByID(models.ProxySqlMySqlQueryRule{RuleID: 1}, IfMatch(rev))
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected a precondition failure, got %v", err)
+	}
+	// no If-Match means a blind write, same as before revisions existed
+	if err := client.ModifyDatabase(models.ModifyDatabaseRequest{InstanceName: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestClient_BulkReadCacheRevisions checks that revisions listed by the
+// fake server are kept by the bulk cache and still guard modifications.
+func TestClient_BulkReadCacheRevisions(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{InstanceName: "foo", Username: "foo", Password: "bar"})
+	srv.PutDatabase(models.InstanceData{InstanceName: "shaz", Username: "shaz", Password: "bot"})
+	cachedClient, err := NewClientWithOptions(WithHost(srv.URL), WithUsername(srv.Username), WithPassword(srv.Password), WithBulkReadCache(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, revision, err := cachedClient.GetDatabaseRevision("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision != srv.Revision("foo") {
+		t.Fatalf("expected revision %s, got %s", srv.Revision("foo"), revision)
+	}
+	if _, _, err := cachedClient.GetDatabaseRevision("shaz"); err != nil {
+		t.Fatal(err)
+	}
+	if requests := srv.Requests(); len(requests) != 1 || requests[0] != "GET /databases" {
+		t.Fatalf("expected a single list request, got %v", requests)
+	}
+	srv.UpdateDatabase("foo", func(db *models.InstanceData) { db.Password = "changed" })
+	err = cachedClient.ModifyDatabase(models.ModifyDatabaseRequest{InstanceName: "foo", NewPassword: "mine"}, IfMatch(revision))
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected a precondition failure, got %v", err)
+	}
+}
+
+func TestClient_MysqlVariables(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{InstanceName: "foo", Username: "foo", Password: "bar"})
+	c, err := NewClientWithOptions(WithHost(srv.URL), WithUsername(srv.Username), WithPassword(srv.Password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := c.GetMysqlVariables("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 0 {
+		t.Fatalf("expected no variables, got %v", vars)
+	}
+	revision := srv.Revision("foo")
+	var newRevision string
+	if err := c.SetMysqlVariables("foo", map[string]string{"query_cache_size_MB": "256"}, IfMatch(revision), CaptureRevision(&newRevision)); err != nil {
+		t.Fatal(err)
+	}
+	if newRevision == revision || newRevision != srv.Revision("foo") {
+		t.Fatalf("expected the new revision %s, got %s", srv.Revision("foo"), newRevision)
+	}
+	vars, err = c.GetMysqlVariables("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 1 || vars["query_cache_size_MB"] != "256" {
+		t.Fatalf("unexpected variables %v", vars)
+	}
+	err = c.SetMysqlVariables("foo", nil, IfMatch(revision))
+	pfe := &PreconditionFailedError{}
+	if !errors.As(err, &pfe) || pfe.InstanceName != "foo" {
+		t.Fatalf("expected a precondition failure, got %v", err)
+	}
+	if err := c.SetMysqlVariables("foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	if vars := srv.MysqlVariables("foo"); len(vars) != 0 {
+		t.Fatalf("expected the variables to be cleared, got %v", vars)
+	}
+	if _, err := c.GetMysqlVariables("bar"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestClient_MysqlVariablesIfSupported(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{InstanceName: "foo", Username: "foo", Password: "bar"})
+	faults := NewFaultTransport(nil)
+	c := faultClient(t, srv, faults, time.Second)
+	if err := c.SetMysqlVariables("foo", map[string]string{"query_cache_size_MB": "256"}); err != nil {
+		t.Fatal(err)
+	}
+	if vars, err := c.GetMysqlVariablesIfSupported("foo"); err != nil || vars["query_cache_size_MB"] != "256" {
+		t.Fatalf("unexpected variables %v %v", vars, err)
+	}
+	// chester-api from before the endpoint
+	for _, statusCode := range []int{http.StatusNotFound, http.StatusMethodNotAllowed} {
+		faults.Inject(Fault{Method: http.MethodGet, Path: "/mysqlvariables/*", StatusCode: statusCode, Times: 1})
+		if vars, err := c.GetMysqlVariablesIfSupported("foo"); err != nil || vars == nil || len(vars) != 0 {
+			t.Fatalf("%d: expected no variables, got %v %v", statusCode, vars, err)
+		}
+	}
+	faults.Inject(Fault{Method: http.MethodGet, Path: "/mysqlvariables/*", StatusCode: http.StatusInternalServerError, Times: 1})
+	if _, err := c.GetMysqlVariablesIfSupported("foo"); err == nil {
+		t.Fatal("expected other failures to be returned")
+	}
+}
+
+// faultClient creates a client for srv that sends every call through faults.
+func faultClient(t *testing.T, srv *apitest.Server, faults *FaultTransport, timeout time.Duration) *Client {
+	t.Helper()
+	faultyClient, err := NewClientWithOptions(WithHost(srv.URL), WithUsername(srv.Username), WithPassword(srv.Password), WithHTTPClient(&http.Client{Transport: faults, Timeout: timeout}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return faultyClient
+}
+
+func TestFaultTransport(t *testing.T) {
+	addRequest := models.AddDatabaseRequest{
+		InstanceName:   "foo",
+		Username:       "foo",
+		Password:       "bar",
+		MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "foo", IPAddress: "10.0.0.2"},
+	}
+	tests := []struct {
+		name  string
+		fault Fault
+		// applied is whether the add should have reached the server
+		applied bool
+		check   func(err error) bool
+	}{
+		{
+			name:  "reset",
+			fault: Fault{Reset: true},
+			check: func(err error) bool { return errors.Is(err, syscall.ECONNRESET) },
+		},
+		{
+			name:    "reset after delivery",
+			fault:   Fault{Reset: true, Delivered: true},
+			applied: true,
+			check:   func(err error) bool { return errors.Is(err, syscall.ECONNRESET) },
+		},
+		{
+			name:  "status code",
+			fault: Fault{StatusCode: http.StatusServiceUnavailable, Body: "try again"},
+			check: func(err error) bool {
+				statusErr := &StatusError{}
+				return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusServiceUnavailable && statusErr.Body == "try again"
+			},
+		},
+		{
+			name:    "truncated body",
+			fault:   Fault{TruncateBody: 5},
+			applied: true,
+			check:   func(err error) bool { return errors.Is(err, io.ErrUnexpectedEOF) },
+		},
+		{
+			name:  "invalid json",
+			fault: Fault{InvalidJSON: true},
+			check: func(err error) bool { return errors.Is(err, io.ErrUnexpectedEOF) },
+		},
+		{
+			name:    "timeout after delivery",
+			fault:   Fault{Latency: time.Second, Delivered: true},
+			applied: true,
+			check: func(err error) bool {
+				netErr, ok := err.(net.Error)
+				return ok && netErr.Timeout()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := apitest.NewServer()
+			defer srv.Close()
+			faults := NewFaultTransport(nil)
+			tt.fault.Method = http.MethodPost
+			tt.fault.Path = "/"
+			tt.fault.Times = 1
+			faults.Inject(tt.fault)
+			faultyClient := faultClient(t, srv, faults, 100*time.Millisecond)
+			_, err := faultyClient.AddDatabase(addRequest)
+			if err == nil || !tt.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if _, ok := srv.Database("foo"); ok != tt.applied {
+				t.Fatalf("expected the add to be applied: %t, got %t", tt.applied, ok)
+			}
+			// the fault only applied once
+			if _, err := faultyClient.GetDatabase("foo"); tt.applied && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFaultTransport_Matching(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{InstanceName: "foo", Username: "foo", Password: "bar"})
+	faults := NewFaultTransport(nil)
+	faults.Inject(Fault{Method: http.MethodGet, Path: "/databases/*", StatusCode: http.StatusBadGateway, Times: 2})
+	faultyClient := faultClient(t, srv, faults, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := faultyClient.GetDatabase("foo"); err == nil {
+			t.Fatalf("expected call %d to fail", i)
+		}
+	}
+	if _, err := faultyClient.GetDatabase("foo"); err != nil {
+		t.Fatalf("expected the fault to be used up, got %v", err)
+	}
+	faults.Inject(Fault{Path: "/databases/*", Reset: true})
+	if _, err := faultyClient.GetDatabases(); err != nil {
+		t.Fatalf("expected the list to go through, got %v", err)
+	}
+	faults.Clear()
+	if _, err := faultyClient.GetDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_SwitchoverMaster(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{
+		InstanceName:   "foo",
+		Username:       "foo",
+		Password:       "bar",
+		MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "foo", IPAddress: "10.0.0.2"},
+		ReadReplicas: []models.AddDatabaseRequestDatabaseInformation{
+			{Name: "foo-read-1", IPAddress: "10.0.0.3"},
+			{Name: "foo-read-2", IPAddress: "10.0.0.4"},
+		},
+	})
+	c, err := NewClientWithOptions(WithHost(srv.URL), WithUsername(srv.Username), WithPassword(srv.Password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision := srv.Revision("foo")
+	var newRevision string
+	if err := c.SwitchoverMaster("foo", "foo-read-2", IfMatch(revision), CaptureRevision(&newRevision)); err != nil {
+		t.Fatal(err)
+	}
+	if newRevision == revision || newRevision != srv.Revision("foo") {
+		t.Fatalf("expected the new revision %s, got %s", srv.Revision("foo"), newRevision)
+	}
+	db, _, err := c.GetInstanceGroup("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.MasterInstance.Name != "foo-read-2" || db.ReadReplicas[0].Name != "foo-read-1" || db.ReadReplicas[1].Name != "foo" {
+		t.Fatalf("expected foo-read-2 to swap places with foo, got %+v", db.InstanceData)
+	}
+	// switching over to the master changes nothing
+	if err := c.SwitchoverMaster("foo", "foo-read-2"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Revision("foo") != newRevision {
+		t.Fatal("expected a switchover to the master to leave the revision alone")
+	}
+	err = c.SwitchoverMaster("foo", "foo-read-1", IfMatch(revision))
+	pfe := &PreconditionFailedError{}
+	if !errors.As(err, &pfe) || pfe.InstanceName != "foo" {
+		t.Fatalf("expected a precondition failure, got %v", err)
+	}
+	statusErr := &StatusError{}
+	if err := c.SwitchoverMaster("foo", "nope"); !errors.As(err, &statusErr) || statusErr.StatusCode != 400 {
+		t.Fatalf("expected an unknown replica to be rejected, got %v", err)
+	}
+}
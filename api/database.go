@@ -3,8 +3,9 @@ package api
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	models "github.com/eahrend/chestermodels"
 )
@@ -14,6 +15,9 @@ import (
 	data. On a successful call it will return the list and a nil error.
 	On an unsuccessful call, it will return an empty list and a non-nil error.
 
+	GetDatabases makes a single unpaged request, use ListDatabases or
+	Databases for large chester deployments.
+
 		package main
 		import github.com/eahrend/terraform-provider-chester/api
 
@@ -26,16 +30,49 @@ import (
 		}
 */
 func (c *Client) GetDatabases() ([]models.InstanceData, error) {
-	resp, err := c.makeRequest(nil, fmt.Sprintf("%s/databases?filter=true", c.HostURL), http.MethodGet)
+	id, _, err := c.getDatabases()
 	if err != nil {
 		return nil, err
 	}
-	id := []models.InstanceData{}
+	dbs := make([]models.InstanceData, len(id))
+	for i, db := range id {
+		dbs[i] = db.Model()
+	}
+	return dbs, nil
+}
+
+// getDatabases is GetDatabases with every query rule column, plus the
+// revision of every instance group for servers that include one in the list.
+func (c *Client) getDatabases() ([]InstanceData, []string, error) {
+	u, err := c.endpoint(ListOptions{Filter: true}.values(), "databases")
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.makeRequest(nil, u, http.MethodGet)
+	if err != nil {
+		return nil, nil, err
+	}
+	id := []InstanceData{}
 	err = json.NewDecoder(bytes.NewBuffer(resp)).Decode(&id)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	revs := []revisioned{}
+	err = json.NewDecoder(bytes.NewBuffer(resp)).Decode(&revs)
+	if err != nil {
+		return nil, nil, err
 	}
-	return id, nil
+	revisions := make([]string, len(id))
+	for i, rev := range revs {
+		revisions[i] = rev.Revision
+	}
+	return id, revisions, nil
+}
+
+// revisioned picks the revision out of an instance group, for servers
+// that put it in the body instead of the ETag header.
+type revisioned struct {
+	Revision string `json:"revision"`
 }
 
 /*
@@ -53,16 +90,86 @@ func (c *Client) GetDatabases() ([]models.InstanceData, error) {
 		fmt.Println(db.InstanceName)
 */
 func (c *Client) GetDatabase(instanceName string) (models.InstanceData, error) {
-	resp, err := c.makeRequest(nil, fmt.Sprintf("%s/databases/%s?filter=true", c.HostURL, instanceName), http.MethodGet)
+	if c.cache != nil {
+		id, _, err := c.cachedGetDatabase(instanceName, false)
+		return id.Model(), err
+	}
+	id, _, err := c.getDatabase(instanceName)
+	return id.Model(), err
+}
+
+/*
+	GetDatabaseRevision is GetDatabase, but it also returns the revision of the
+	instance group. Pass the revision to IfMatch on a modify or remove call
+	so the call fails if anything else changed the instance group since.
+	The revision is empty if chester-api doesn't support revisions.
+
+		package main
+		import github.com/eahrend/terraform-provider-chester/api
+
+		db, revision, err := client.GetDatabaseRevision("sql-instance")
+		if err != nil {
+			// handle error here
+		}
+		err = client.ModifyDatabase(modifyDatabaseRequest, api.IfMatch(revision))
+		if errors.Is(err, api.ErrPreconditionFailed) {
+			// someone else changed the instance group, re-read it
+		}
+*/
+func (c *Client) GetDatabaseRevision(instanceName string) (models.InstanceData, string, error) {
+	id, revision, err := c.GetInstanceGroup(instanceName)
+	return id.Model(), revision, err
+}
+
+/*
+	GetInstanceGroup is GetDatabaseRevision with every query rule column,
+	like match_pattern, flagIN or cache_ttl, that models.ProxySqlMySqlQueryRule
+	doesn't have.
+
+		package main
+		import github.com/eahrend/terraform-provider-chester/api
+
+		db, revision, err := client.GetInstanceGroup("sql-instance")
+		if err != nil {
+			// handle error here
+		}
+		for _, rule := range db.QueryRules {
+			fmt.Println(rule.RuleID, rule.MatchPattern)
+		}
+*/
+func (c *Client) GetInstanceGroup(instanceName string) (InstanceData, string, error) {
+	if c.cache != nil {
+		return c.cachedGetDatabase(instanceName, true)
+	}
+	return c.getDatabase(instanceName)
+}
+
+// getDatabase fetches instanceName and its revision from chester-api,
+// skipping the read cache.
+func (c *Client) getDatabase(instanceName string) (InstanceData, string, error) {
+	u, err := c.endpoint(url.Values{"filter": []string{"true"}}, "databases", instanceName)
+	if err != nil {
+		return InstanceData{}, "", err
+	}
+	var revision string
+	resp, err := c.makeRequest(nil, u, http.MethodGet, CaptureRevision(&revision))
 	if err != nil {
-		return models.InstanceData{}, err
+		return InstanceData{}, "", err
 	}
-	id := models.InstanceData{}
+	id := InstanceData{}
 	err = json.NewDecoder(bytes.NewBuffer(resp)).Decode(&id)
 	if err != nil {
-		return models.InstanceData{}, err
+		return InstanceData{}, "", err
 	}
-	return id, nil
+	if revision == "" {
+		rev := revisioned{}
+		err = json.NewDecoder(bytes.NewBuffer(resp)).Decode(&rev)
+		if err != nil {
+			return InstanceData{}, "", err
+		}
+		revision = rev.Revision
+	}
+	return id, revision, nil
 }
 
 /*
@@ -95,11 +202,24 @@ func (c *Client) GetDatabase(instanceName string) (models.InstanceData, error) {
 
 */
 func (c *Client) AddDatabase(database models.AddDatabaseRequest) (models.AddDatabaseResponse, error) {
+	return c.AddInstanceGroup(AddDatabaseRequest{
+		AddDatabaseRequest: database,
+		QueryRules:         NewQueryRules(database.QueryRules),
+	})
+}
+
+// AddInstanceGroup is AddDatabase with every query rule column.
+func (c *Client) AddInstanceGroup(database AddDatabaseRequest) (models.AddDatabaseResponse, error) {
 	b, err := json.Marshal(&database)
 	if err != nil {
 		return models.AddDatabaseResponse{}, err
 	}
-	resp, err := c.makeRequest(b, fmt.Sprintf("%s", c.HostURL), http.MethodPost)
+	u, err := c.endpoint(nil)
+	if err != nil {
+		return models.AddDatabaseResponse{}, err
+	}
+	resp, err := c.makeRequest(b, u, http.MethodPost)
+	c.InvalidateCache(database.InstanceName)
 	if err != nil {
 		return models.AddDatabaseResponse{}, err
 	}
@@ -127,14 +247,21 @@ func (c *Client) AddDatabase(database models.AddDatabaseRequest) (models.AddData
 		if err != nil {
 			// handle error here
 		}
+
+	Pass api.IfMatch to only remove the instance group if it's unchanged.
 */
-func (c *Client) RemoveDatabase(database models.RemoveDatabaseRequest) error {
+func (c *Client) RemoveDatabase(database models.RemoveDatabaseRequest, opts ...RequestOption) error {
 	b, err := json.Marshal(&database)
 	if err != nil {
 		return err
 	}
-	_, err = c.makeRequest(b, fmt.Sprintf("%s", c.HostURL), http.MethodDelete)
-	return err
+	u, err := c.endpoint(nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodDelete, opts...)
+	c.InvalidateCache(database.InstanceName)
+	return wrapPrecondition(err, database.InstanceName, newRequestOptions(opts))
 }
 
 /*
@@ -155,25 +282,54 @@ func (c *Client) RemoveDatabase(database models.RemoveDatabaseRequest) error {
 			},
 		}
 		err := client.ModifyDatabase(modifyDatabaseRequest)
+
+	Pass api.IfMatch to only modify the instance group if it's unchanged,
+	and api.CaptureRevision to get the revision after the change.
 */
-func (c *Client) ModifyDatabase(database models.ModifyDatabaseRequest) error {
+func (c *Client) ModifyDatabase(database models.ModifyDatabaseRequest, opts ...RequestOption) error {
+	return c.ModifyInstanceGroup(ModifyDatabaseRequest{
+		ModifyDatabaseRequest: database,
+		AddQueryRules:         NewQueryRules(database.AddQueryRules),
+	}, opts...)
+}
+
+// ModifyInstanceGroup is ModifyDatabase with every query rule column.
+func (c *Client) ModifyInstanceGroup(database ModifyDatabaseRequest, opts ...RequestOption) error {
 	b, err := json.Marshal(&database)
 	if err != nil {
 		return err
 	}
-	_, err = c.makeRequest(b, fmt.Sprintf("%s", c.HostURL), http.MethodPatch)
-	return err
+	u, err := c.endpoint(nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPatch, opts...)
+	c.InvalidateCache(database.InstanceName)
+	return wrapPrecondition(err, database.InstanceName, newRequestOptions(opts))
 }
 
 // ModifyQueryRuleByID shouldn't be used. Query Rules need to be authoritive.
-func (c *Client) ModifyQueryRuleByID(queryRule models.ProxySqlMySqlQueryRule) error {
+// It takes the same api.IfMatch and api.CaptureRevision options as ModifyDatabase.
+func (c *Client) ModifyQueryRuleByID(queryRule models.ProxySqlMySqlQueryRule, opts ...RequestOption) error {
+	return c.ModifyQueryRule(QueryRule{ProxySqlMySqlQueryRule: queryRule}, opts...)
+}
+
+// ModifyQueryRule is ModifyQueryRuleByID with every query rule column, the
+// rule replaces the one with the same rule_id.
+func (c *Client) ModifyQueryRule(queryRule QueryRule, opts ...RequestOption) error {
 	queryRuleID := queryRule.RuleID
 	b, err := json.Marshal(&queryRule)
 	if err != nil {
 		return err
 	}
-	_, err = c.makeRequest(b, fmt.Sprintf("%s/queryrules/%v", c.HostURL, queryRuleID), http.MethodPatch)
-	return err
+	u, err := c.endpoint(nil, "queryrules", strconv.Itoa(queryRuleID))
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPatch, opts...)
+	// rule ids don't tell us the instance group, so drop everything
+	c.InvalidateCache("")
+	return wrapPrecondition(err, "", newRequestOptions(opts))
 }
 
 // ModifyUser isn't currently used, will have to add at a later date when it becomes necessary
@@ -182,13 +338,23 @@ func (c *Client) ModifyUser(userData models.ModifyUserRequest) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.makeRequest(b, fmt.Sprintf("%s/users", c.HostURL), http.MethodPatch)
+	u, err := c.endpoint(nil, "users")
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPatch)
+	c.InvalidateCache(userData.InstanceGroup)
 	return err
 }
 
 // DeleteUser shouldn't be used, deleting an instance group should delete all associated users
 func (c *Client) DeleteUser(username string) error {
-	_, err := c.makeRequest(nil, fmt.Sprintf("%s/users/%s", c.HostURL, username), http.MethodDelete)
+	u, err := c.endpoint(nil, "users", username)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(nil, u, http.MethodDelete)
+	c.InvalidateCache("")
 	return err
 }
 
@@ -198,13 +364,22 @@ func (c *Client) CreateUser(user models.ProxySqlMySqlUser) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.makeRequest(b, fmt.Sprintf("%s/users", c.HostURL), http.MethodPost)
+	u, err := c.endpoint(nil, "users")
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPost)
+	c.InvalidateCache(user.InstanceGroup)
 	return err
 }
 
 // GetUser isn't currently used, these tweaks will have to be made at a later date
 func (c *Client) GetUser(userName string) (models.ProxySqlMySqlUser, error) {
-	b, err := c.makeRequest(nil, fmt.Sprintf("%s/users/%s", c.HostURL, userName), http.MethodGet)
+	u, err := c.endpoint(nil, "users", userName)
+	if err != nil {
+		return models.ProxySqlMySqlUser{}, err
+	}
+	b, err := c.makeRequest(nil, u, http.MethodGet)
 	if err != nil {
 		return models.ProxySqlMySqlUser{}, err
 	}
@@ -225,7 +400,12 @@ func (c *Client) UpdateKey(keyData, instanceGroup string) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.makeRequest(b, fmt.Sprintf("%s/key/%s", c.HostURL, instanceGroup), http.MethodPatch)
+	u, err := c.endpoint(nil, "key", instanceGroup)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPatch)
+	c.InvalidateCache(instanceGroup)
 	return err
 }
 
@@ -238,6 +418,11 @@ func (c *Client) UpdateCert(certData, instanceGroup string) error {
 	if err != nil {
 		return err
 	}
-	_, err = c.makeRequest(b, fmt.Sprintf("%s/cert/%s", c.HostURL, instanceGroup), http.MethodPatch)
+	u, err := c.endpoint(nil, "cert", instanceGroup)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPatch)
+	c.InvalidateCache(instanceGroup)
 	return err
 }
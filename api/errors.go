@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrPreconditionFailed matches, using errors.Is, any error caused by
+// chester-api rejecting an If-Match revision.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ErrNotFound matches, using errors.Is, any 404 from chester-api, i.e.
+// reading an instance group that was removed.
+var ErrNotFound = errors.New("not found")
+
+// StatusError is returned when chester-api responds with anything
+// other than a 200.
+type StatusError struct {
+	// StatusCode is the http status code of the response
+	StatusCode int
+	// Body is the response body, which usually explains the failure
+	Body string
+}
+
+// Error keeps the same message the client always returned for a bad status.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("bad status code: %s", e.Body)
+}
+
+// Is lets errors.Is match a 412 against ErrPreconditionFailed and a 404
+// against ErrNotFound.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// PreconditionFailedError is returned from the modify and remove calls
+// when the instance group changed since Revision was read.
+type PreconditionFailedError struct {
+	// InstanceName is the instance group, empty for query rule changes
+	InstanceName string
+	// Revision is the revision the caller sent in If-Match
+	Revision string
+	// Err is the underlying StatusError
+	Err error
+}
+
+func (e *PreconditionFailedError) Error() string {
+	if e.InstanceName == "" {
+		return fmt.Sprintf("changed since revision %s was read: %s", e.Revision, e.Err.Error())
+	}
+	return fmt.Sprintf("instance group %s changed since revision %s was read: %s", e.InstanceName, e.Revision, e.Err.Error())
+}
+
+// Unwrap returns the underlying StatusError.
+func (e *PreconditionFailedError) Unwrap() error {
+	return e.Err
+}
+
+// Is lets errors.Is match ErrPreconditionFailed.
+func (e *PreconditionFailedError) Is(target error) bool {
+	return target == ErrPreconditionFailed
+}
+
+// wrapPrecondition converts a 412 into a PreconditionFailedError, any
+// other error is returned as is.
+func wrapPrecondition(err error, instanceName string, o requestOptions) error {
+	if err == nil || !errors.Is(err, ErrPreconditionFailed) {
+		return err
+	}
+	return &PreconditionFailedError{
+		InstanceName: instanceName,
+		Revision:     o.ifMatch,
+		Err:          err,
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Fault is a failure FaultTransport injects into matching calls. Only
+// the first of Reset, StatusCode, TruncateBody and InvalidJSON that is
+// set applies, Latency applies on top of any of them.
+type Fault struct {
+	// Method matches the http method, empty matches every method
+	Method string
+	// Path is a path.Match pattern for the url path, i.e. "/databases/*",
+	// empty matches every path
+	Path string
+	// Times is how many calls the fault applies to, 0 is every call
+	Times int
+	// Delivered sends the request on to chester-api before failing, so
+	// the change is applied but the response is lost, like a timeout
+	// mid-create. Without it the request never leaves the client.
+	Delivered bool
+	// Latency delays the call, and fails it if the request's context is
+	// done first, so it can trip http.Client.Timeout
+	Latency time.Duration
+	// Reset fails the call with a connection reset
+	Reset bool
+	// StatusCode replaces the response with this status and Body
+	StatusCode int
+	// Body is the response body sent with StatusCode
+	Body string
+	// TruncateBody cuts the response body off after this many bytes,
+	// the read then fails with io.ErrUnexpectedEOF. It always sends the
+	// request on, since it needs a real response to cut.
+	TruncateBody int
+	// InvalidJSON replaces the response body with a 200 of malformed json
+	InvalidJSON bool
+}
+
+// matches reports whether the fault applies to req.
+func (f *Fault) matches(req *http.Request) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, req.Method) {
+		return false
+	}
+	if f.Path == "" {
+		return true
+	}
+	p := req.URL.Path
+	if p == "" {
+		// the base url of a host without a base path is sent as /
+		p = "/"
+	}
+	ok, err := path.Match(f.Path, p)
+	return err == nil && ok
+}
+
+// FaultTransport is an http.RoundTripper that injects faults into calls
+// to chester-api, for testing how callers handle timeouts, resets and
+// garbage responses. Pass it to WithHTTPClient.
+//
+//	faults := api.NewFaultTransport(nil)
+//	faults.Inject(api.Fault{Method: http.MethodPost, Path: "/", Delivered: true, Reset: true})
+//	client, _ := api.NewClientWithOptions(
+//		api.WithHost(host),
+//		api.WithHTTPClient(&http.Client{Transport: faults}),
+//	)
+type FaultTransport struct {
+	// Next sends the calls that go through, http.DefaultTransport if nil
+	Next http.RoundTripper
+
+	mu     sync.Mutex
+	faults []*faultState
+}
+
+// faultState tracks how many calls a Fault has left.
+type faultState struct {
+	fault     Fault
+	remaining int
+}
+
+// NewFaultTransport creates a FaultTransport without any faults that
+// sends calls on to next, or http.DefaultTransport if next is nil.
+func NewFaultTransport(next http.RoundTripper) *FaultTransport {
+	return &FaultTransport{Next: next}
+}
+
+// Inject adds a fault. Faults are matched in the order they're injected,
+// the first match applies.
+func (t *FaultTransport) Inject(f Fault) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.faults = append(t.faults, &faultState{fault: f, remaining: f.Times})
+}
+
+// Clear removes every fault.
+func (t *FaultTransport) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.faults = nil
+}
+
+// next returns the fault for req and uses up one of its calls.
+func (t *FaultTransport) next(req *http.Request) (Fault, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, state := range t.faults {
+		if !state.fault.matches(req) {
+			continue
+		}
+		if state.fault.Times > 0 {
+			state.remaining--
+			if state.remaining == 0 {
+				t.faults = append(t.faults[:i:i], t.faults[i+1:]...)
+			}
+		}
+		return state.fault, true
+	}
+	return Fault{}, false
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *FaultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	f, ok := t.next(req)
+	if !ok {
+		return next.RoundTrip(req)
+	}
+	var resp *http.Response
+	if f.Delivered {
+		// the request reaches chester-api even if the caller stops
+		// waiting for the response
+		var err error
+		resp, err = next.RoundTrip(req.WithContext(context.Background()))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.Latency > 0 {
+		timer := time.NewTimer(f.Latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, req.Context().Err()
+		}
+	}
+	switch {
+	case f.Reset:
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	case f.StatusCode != 0:
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return newResponse(req, f.StatusCode, ioutil.NopCloser(strings.NewReader(f.Body))), nil
+	case f.InvalidJSON:
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return newResponse(req, http.StatusOK, ioutil.NopCloser(strings.NewReader(`{"instance_name": `))), nil
+	case f.TruncateBody > 0:
+		if resp == nil {
+			var err error
+			resp, err = next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if f.TruncateBody < len(b) {
+			b = b[:f.TruncateBody]
+		}
+		resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(b), errReader{io.ErrUnexpectedEOF}))
+		return resp, nil
+	}
+	if resp != nil {
+		return resp, nil
+	}
+	return next.RoundTrip(req)
+}
+
+// newResponse builds a response to req with body.
+func newResponse(req *http.Request, statusCode int, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       body,
+		Request:    req,
+	}
+}
+
+// errReader fails every read with err.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
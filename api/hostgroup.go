@@ -0,0 +1,22 @@
+package api
+
+import (
+	models "github.com/eahrend/chestermodels"
+)
+
+// Hostgroup is a hostgroup of an instance group beyond its read and write
+// hostgroups, like one for analytics replicas or the one query mirroring
+// sends copies of statements to. Its servers are managed like the read
+// replicas.
+type Hostgroup struct {
+	// HostgroupID is the proxysql hostgroup, it can't be the read or write
+	// hostgroup of the instance group
+	HostgroupID int `json:"hostgroup_id"`
+	// Servers are the instances in the hostgroup
+	Servers []models.AddDatabaseRequestDatabaseInformation `json:"servers"`
+	// MaxConnections caps the connections to every server in the
+	// hostgroup, chester's default of 100 when it's 0
+	MaxConnections int `json:"max_connections,omitempty"`
+	// Comment says what the hostgroup is for
+	Comment string `json:"comment,omitempty"`
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// ListOptions narrows down a ListDatabases call. The zero value
+// lists every instance group using the server's default page size.
+type ListOptions struct {
+	// Filter asks chester-api to strip chester-added read replicas
+	// from the response, the same as GetDatabases does.
+	Filter bool
+	// PageSize is the maximum number of instance groups per page,
+	// zero leaves it up to the server.
+	PageSize int
+	// PageToken is the NextPageToken of a previous ListDatabasesResponse.
+	PageToken string
+	// NamePrefix only returns instance groups whose name starts with it.
+	NamePrefix string
+	// Labels only returns instance groups that carry every label.
+	Labels map[string]string
+}
+
+// values converts the options into query parameters.
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	if o.Filter {
+		v.Set("filter", "true")
+	}
+	if o.PageSize > 0 {
+		v.Set("page_size", strconv.Itoa(o.PageSize))
+	}
+	if o.PageToken != "" {
+		v.Set("page_token", o.PageToken)
+	}
+	if o.NamePrefix != "" {
+		v.Set("name_prefix", o.NamePrefix)
+	}
+	// sorted so the same options always produce the same url
+	keys := make([]string, 0, len(o.Labels))
+	for k := range o.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.Add("label", fmt.Sprintf("%s:%s", k, o.Labels[k]))
+	}
+	return v
+}
+
+// ListDatabasesResponse is a single page of instance groups.
+type ListDatabasesResponse struct {
+	// Databases are the instance groups on this page
+	Databases []InstanceData `json:"databases"`
+	// NextPageToken is empty on the last page
+	NextPageToken string `json:"next_page_token"`
+}
+
+/*
+ListDatabases returns a single page of instance groups matching opts.
+On a successful call it will return the page and a nil error.
+On an unsuccessful call, it will return an empty page and a non-nil error.
+
+Older chester-api servers that don't page respond with a plain list, that
+list is returned as the only page.
+
+	package main
+	import github.com/eahrend/terraform-provider-chester/api
+
+	page, err := client.ListDatabases(ctx, api.ListOptions{
+		Filter:     true,
+		PageSize:   50,
+		NamePrefix: "orders-",
+	})
+	if err != nil {
+		// handle errors here
+	}
+	for _, db := range page.Databases {
+		fmt.Println(db.InstanceName)
+	}
+*/
+func (c *Client) ListDatabases(ctx context.Context, opts ListOptions) (ListDatabasesResponse, error) {
+	u, err := c.endpoint(opts.values(), "databases")
+	if err != nil {
+		return ListDatabasesResponse{}, err
+	}
+	resp, err := c.makeRequestContext(ctx, nil, u, http.MethodGet)
+	if err != nil {
+		return ListDatabasesResponse{}, err
+	}
+	resp = bytes.TrimSpace(resp)
+	if len(resp) > 0 && resp[0] == '[' {
+		id := []InstanceData{}
+		err = json.NewDecoder(bytes.NewBuffer(resp)).Decode(&id)
+		if err != nil {
+			return ListDatabasesResponse{}, err
+		}
+		return ListDatabasesResponse{Databases: id}, nil
+	}
+	ldr := ListDatabasesResponse{}
+	err = json.NewDecoder(bytes.NewBuffer(resp)).Decode(&ldr)
+	if err != nil {
+		return ListDatabasesResponse{}, err
+	}
+	return ldr, nil
+}
+
+// DatabaseIterator walks every page of a ListDatabases call.
+//
+//	it := client.Databases(ctx, api.ListOptions{Filter: true})
+//	for it.Next() {
+//		fmt.Println(it.Database().InstanceName)
+//	}
+//	if err := it.Err(); err != nil {
+//		// handle errors here
+//	}
+type DatabaseIterator struct {
+	ctx     context.Context
+	client  *Client
+	opts    ListOptions
+	page    []InstanceData
+	index   int
+	current InstanceData
+	started bool
+	err     error
+}
+
+// Databases creates a DatabaseIterator, no request is made until the
+// first call to Next.
+func (c *Client) Databases(ctx context.Context, opts ListOptions) *DatabaseIterator {
+	return &DatabaseIterator{
+		ctx:    ctx,
+		client: c,
+		opts:   opts,
+	}
+}
+
+// Next advances to the next instance group, fetching the next page when
+// the current one runs out. It returns false once every page has been
+// read or a request failed, check Err to tell the two apart.
+func (it *DatabaseIterator) Next() bool {
+	for it.index >= len(it.page) {
+		if it.err != nil || (it.started && it.opts.PageToken == "") {
+			return false
+		}
+		page, err := it.client.ListDatabases(it.ctx, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if it.started && page.NextPageToken == it.opts.PageToken {
+			it.err = fmt.Errorf("chester-api returned the same page token %s twice", page.NextPageToken)
+			return false
+		}
+		it.started = true
+		it.opts.PageToken = page.NextPageToken
+		it.page = page.Databases
+		it.index = 0
+	}
+	it.current = it.page[it.index]
+	it.index++
+	return true
+}
+
+// Database returns the instance group Next advanced to.
+func (it *DatabaseIterator) Database() InstanceData {
+	return it.current
+}
+
+// Err returns the first error the iterator ran into.
+func (it *DatabaseIterator) Err() error {
+	return it.err
+}
+
+// ListAllDatabases reads every page of a ListDatabases call into one list.
+func (c *Client) ListAllDatabases(ctx context.Context, opts ListOptions) ([]InstanceData, error) {
+	dbs := []InstanceData{}
+	it := c.Databases(ctx, opts)
+	for it.Next() {
+		dbs = append(dbs, it.Database())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return dbs, nil
+}
@@ -0,0 +1,10 @@
+package api
+
+// MonitorCredentials are the mysql credentials proxysql's monitor logs in
+// to the servers of an instance group with, to check replication lag and
+// read_only. Without them the monitor uses the chart's shared monitor user,
+// which has to exist on every Cloud SQL cluster.
+type MonitorCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	models "github.com/eahrend/chestermodels"
+)
+
+// QueryRule is a proxysql mysql_query_rules row with every column chester
+// passes through to proxysql. models.ProxySqlMySqlQueryRule only has the
+// columns chester started with, the rest are here. Columns proxysql allows
+// to be NULL are pointers, nil is NULL.
+type QueryRule struct {
+	models.ProxySqlMySqlQueryRule
+	// MatchPattern is matched against the statement itself, not its digest
+	MatchPattern string `libconfig:"match_pattern" json:"match_pattern,omitempty"`
+	// NegateMatchPattern is a int(bool), 1 makes the rule match statements
+	// match_pattern doesn't match
+	NegateMatchPattern int `libconfig:"negate_match_pattern" json:"negate_match_pattern,omitempty"`
+	// SchemaName filters on the default schema of the connection
+	SchemaName string `libconfig:"schemaname" json:"schemaname,omitempty"`
+	// ClientAddr filters on the address of the client
+	ClientAddr string `libconfig:"client_addr" json:"client_addr,omitempty"`
+	// FlagIN is the flag a statement needs to be evaluated against the rule,
+	// statements start with 0
+	FlagIN int `libconfig:"flagIN" json:"flagIN,omitempty"`
+	// FlagOUT is the flag a matching statement continues with
+	FlagOUT *int `libconfig:"flagOUT" json:"flagOUT,omitempty"`
+	// ReplacePattern rewrites what match_pattern matched
+	ReplacePattern string `libconfig:"replace_pattern" json:"replace_pattern,omitempty"`
+	// CacheTTL caches results of matching statements for this many milliseconds
+	CacheTTL *int `libconfig:"cache_ttl" json:"cache_ttl,omitempty"`
+	// Timeout kills matching statements after this many milliseconds
+	Timeout *int `libconfig:"timeout" json:"timeout,omitempty"`
+	// Retries is how often a matching statement is retried on failure
+	Retries *int `libconfig:"retries" json:"retries,omitempty"`
+	// Delay holds matching statements back for this many milliseconds
+	Delay *int `libconfig:"delay" json:"delay,omitempty"`
+	// MirrorHostgroup also sends matching statements to this hostgroup
+	MirrorHostgroup *int `libconfig:"mirror_hostgroup" json:"mirror_hostgroup,omitempty"`
+	// Multiplex is 0 to disable multiplexing, 1 to enable it and 2 to not
+	// let the statement disable it
+	Multiplex *int `libconfig:"multiplex" json:"multiplex,omitempty"`
+	// ErrorMsg is returned to the client instead of running the statement
+	ErrorMsg string `libconfig:"error_msg" json:"error_msg,omitempty"`
+	// Log is a int(bool), 1 logs matching statements to the events log
+	Log *int `libconfig:"log" json:"log,omitempty"`
+	// MirrorFlagOUT is the flag the copy mirror_hostgroup gets is
+	// evaluated with, the copy is sent as is when it's NULL
+	MirrorFlagOUT *int `libconfig:"mirror_flagOUT" json:"mirror_flagOUT,omitempty"`
+}
+
+// InstanceData is models.InstanceData with what chester-api has beyond it:
+// every query rule column, extra hostgroups, replication hostgroups and
+// monitor credentials.
+type InstanceData struct {
+	models.InstanceData
+	QueryRules            []QueryRule            `json:"query_rules"`
+	Hostgroups            []Hostgroup            `json:"hostgroups,omitempty"`
+	ReplicationHostgroups []ReplicationHostgroup `json:"replication_hostgroups,omitempty"`
+	Monitor               *MonitorCredentials    `json:"monitor,omitempty"`
+}
+
+// Model returns db as a models.InstanceData, dropping the query rule
+// columns models doesn't have.
+func (db InstanceData) Model() models.InstanceData {
+	id := db.InstanceData
+	id.QueryRules = ModelQueryRules(db.QueryRules)
+	return id
+}
+
+// AddDatabaseRequest is models.AddDatabaseRequest with the fields
+// InstanceData adds.
+type AddDatabaseRequest struct {
+	models.AddDatabaseRequest
+	QueryRules            []QueryRule            `json:"query_rules,omitempty"`
+	Hostgroups            []Hostgroup            `json:"hostgroups,omitempty"`
+	ReplicationHostgroups []ReplicationHostgroup `json:"replication_hostgroups,omitempty"`
+	Monitor               *MonitorCredentials    `json:"monitor,omitempty"`
+}
+
+// ModifyDatabaseRequest is models.ModifyDatabaseRequest with the fields
+// InstanceData adds.
+type ModifyDatabaseRequest struct {
+	models.ModifyDatabaseRequest
+	AddQueryRules []QueryRule `json:"add_query_rules"`
+	// Hostgroups replace every hostgroup beyond the read and write
+	// hostgroups when they're sent, nil leaves them alone
+	Hostgroups *[]Hostgroup `json:"hostgroups,omitempty"`
+	// ReplicationHostgroups replace the replication hostgroups when
+	// they're sent, nil leaves them alone
+	ReplicationHostgroups *[]ReplicationHostgroup `json:"replication_hostgroups,omitempty"`
+	// Monitor replaces the monitor credentials when it's sent, empty
+	// credentials go back to the chart's monitor user and nil leaves them
+	// alone
+	Monitor *MonitorCredentials `json:"monitor,omitempty"`
+}
+
+// NewQueryRules converts rules to QueryRules with the extra columns left NULL.
+// A nil slice stays nil.
+func NewQueryRules(rules []models.ProxySqlMySqlQueryRule) []QueryRule {
+	if rules == nil {
+		return nil
+	}
+	qrs := make([]QueryRule, len(rules))
+	for i, rule := range rules {
+		qrs[i] = QueryRule{ProxySqlMySqlQueryRule: rule}
+	}
+	return qrs
+}
+
+// ModelQueryRules converts rules to models, dropping the extra columns.
+// A nil slice stays nil.
+func ModelQueryRules(rules []QueryRule) []models.ProxySqlMySqlQueryRule {
+	if rules == nil {
+		return nil
+	}
+	mrs := make([]models.ProxySqlMySqlQueryRule, len(rules))
+	for i, rule := range rules {
+		mrs[i] = rule.ProxySqlMySqlQueryRule
+	}
+	return mrs
+}
@@ -0,0 +1,21 @@
+package api
+
+// ReplicationHostgroup is a mysql_replication_hostgroups entry. proxysql's
+// monitor checks the servers of both hostgroups with CheckType and moves
+// the writable one into WriterHostgroup and the read only ones into
+// ReaderHostgroup, so a failover or a promoted replica takes the writes
+// without the instance group being changed.
+type ReplicationHostgroup struct {
+	// WriterHostgroup is the hostgroup of the writable server
+	WriterHostgroup int `libconfig:"writer_hostgroup" json:"writer_hostgroup"`
+	// ReaderHostgroup is the hostgroup of the read only servers
+	ReaderHostgroup int `libconfig:"reader_hostgroup" json:"reader_hostgroup"`
+	// CheckType is the variable that tells whether a server is read only,
+	// one of CheckTypes
+	CheckType string `libconfig:"check_type" json:"check_type,omitempty"`
+	// Comment says what the pair is for
+	Comment string `libconfig:"comment" json:"comment,omitempty"`
+}
+
+// CheckTypes are the check types proxysql knows, read_only is its default.
+var CheckTypes = []string{"read_only", "innodb_read_only", "super_read_only", "read_only|innodb_read_only", "read_only&innodb_read_only"}
@@ -1,19 +1,59 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
+// RequestOption modifies a single call to chester-api.
+type RequestOption func(*requestOptions)
+
+// requestOptions is the result of applying every RequestOption to a call.
+type requestOptions struct {
+	ifMatch  string
+	revision *string
+}
+
+// IfMatch creates a RequestOption that only applies a change if the
+// instance group is still at revision. An empty revision is ignored.
+// If the instance group changed, the call returns a *PreconditionFailedError.
+func IfMatch(revision string) RequestOption {
+	return func(o *requestOptions) {
+		o.ifMatch = revision
+	}
+}
+
+// CaptureRevision creates a RequestOption that stores the revision
+// chester-api returned in the response's ETag header in revision.
+func CaptureRevision(revision *string) RequestOption {
+	return func(o *requestOptions) {
+		o.revision = revision
+	}
+}
+
+// newRequestOptions applies every RequestOption.
+func newRequestOptions(opts []RequestOption) requestOptions {
+	o := requestOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // doRequest wraps all http requests with the proper authorization
 // details.
 // On a successful request, it will return a byte slice, and a non-nil error.
 // On a failure, it will return a byte slice with details from the API server
 // and a non-nil error. Since the REST server should be behind IAP, we'll add the
 // proxy-auth header.
-func (c *Client) doRequest(req *http.Request) ([]byte, error) {
+func (c *Client) doRequest(req *http.Request, o requestOptions) ([]byte, error) {
+	if o.ifMatch != "" {
+		req.Header.Set("If-Match", o.ifMatch)
+	}
 	if c.token != nil {
 		req.Header.Set("Proxy-Authorization", fmt.Sprintf("Bearer %s", c.token.AccessToken))
 	}
@@ -22,31 +62,74 @@ func (c *Client) doRequest(req *http.Request) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status code: %s", string(b))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if o.revision != nil {
+		*o.revision = resp.Header.Get("ETag")
 	}
 	return b, nil
 }
 
+// endpoint joins the escaped path segments onto the client's base url
+// and encodes the query parameters. Segments are escaped individually,
+// so instance or user names containing a '/' or '?' stay a single segment.
+func (c *Client) endpoint(query url.Values, segments ...string) (string, error) {
+	base := c.baseURL
+	if base == nil {
+		// clients built without a constructor parse the host on every
+		// call rather than caching it on a client that may be shared
+		if c.HostURL == "" {
+			return "", fmt.Errorf("no host found")
+		}
+		var err error
+		base, err = parseHostURL(c.HostURL)
+		if err != nil {
+			return "", err
+		}
+	}
+	u := *base
+	// without segments it's the base url itself, i.e. the add call
+	if len(segments) > 0 {
+		escaped := make([]string, len(segments))
+		for i, segment := range segments {
+			escaped[i] = url.PathEscape(segment)
+		}
+		u.RawPath = base.EscapedPath() + "/" + strings.Join(escaped, "/")
+		u.Path = base.Path + "/" + strings.Join(segments, "/")
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+
 // makeRequest is a helper function that builds the http request object
 // and then sends it to doRequest.
-func (c *Client) makeRequest(b []byte, url, method string) ([]byte, error) {
+func (c *Client) makeRequest(b []byte, u, method string, opts ...RequestOption) ([]byte, error) {
+	return c.makeRequestContext(context.Background(), b, u, method, opts...)
+}
+
+// makeRequestContext is makeRequest bound to a context, so callers
+// can cancel long running calls like paging through every database.
+func (c *Client) makeRequestContext(ctx context.Context, b []byte, u, method string, opts ...RequestOption) ([]byte, error) {
 	var req *http.Request
 	var err error
 	if b == nil {
-		req, err = http.NewRequest(method, url, nil)
+		req, err = http.NewRequestWithContext(ctx, method, u, nil)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		req, err = http.NewRequest(method, url, strings.NewReader(string(b)))
+		req, err = http.NewRequestWithContext(ctx, method, u, strings.NewReader(string(b)))
 		if err != nil {
 			return nil, err
 		}
 	}
-	return c.doRequest(req)
+	return c.doRequest(req, newRequestOptions(opts))
 }
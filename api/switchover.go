@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// switchoverRequest is the body of the switchover endpoint.
+type switchoverRequest struct {
+	InstanceGroup string `json:"instance_group"`
+	NewMaster     string `json:"new_master"`
+}
+
+// SwitchoverMaster makes the read replica called replicaName the master
+// instance of an instance group in one ordered operation: chester-api
+// drains the writes of the master, swaps it with the replica, so the old
+// master takes the replica's place among the read replicas, and takes
+// writes again. The write hostgroup is never left empty, unlike removing
+// the master and adding the replica. Switching over to the master instance
+// changes nothing. It takes the same api.IfMatch and api.CaptureRevision
+// options as ModifyDatabase.
+func (c *Client) SwitchoverMaster(instanceGroup, replicaName string, opts ...RequestOption) error {
+	b, err := json.Marshal(&switchoverRequest{InstanceGroup: instanceGroup, NewMaster: replicaName})
+	if err != nil {
+		return err
+	}
+	u, err := c.endpoint(nil, "switchover", instanceGroup)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPost, opts...)
+	c.InvalidateCache(instanceGroup)
+	return wrapPrecondition(err, instanceGroup, newRequestOptions(opts))
+}
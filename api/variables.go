@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// mysqlVariables is the body of the mysqlvariables endpoint.
+type mysqlVariables struct {
+	InstanceGroup  string            `json:"instance_group"`
+	MysqlVariables map[string]string `json:"mysql_variables"`
+}
+
+// GetMysqlVariables returns the proxysql mysql_variables set for an
+// instance group, by name without the mysql- prefix, like
+// query_cache_size_MB. Variables that aren't set run with the defaults
+// of the chart.
+func (c *Client) GetMysqlVariables(instanceGroup string) (map[string]string, error) {
+	u, err := c.endpoint(nil, "mysqlvariables", instanceGroup)
+	if err != nil {
+		return nil, err
+	}
+	b, err := c.makeRequest(nil, u, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	vars := mysqlVariables{}
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&vars); err != nil {
+		return nil, err
+	}
+	if vars.MysqlVariables == nil {
+		return map[string]string{}, nil
+	}
+	return vars.MysqlVariables, nil
+}
+
+// GetMysqlVariablesIfSupported is GetMysqlVariables for chester-api that
+// may be from before the mysqlvariables endpoint. Those answer 404 or
+// 405, which is read as no variables.
+func (c *Client) GetMysqlVariablesIfSupported(instanceGroup string) (map[string]string, error) {
+	vars, err := c.GetMysqlVariables(instanceGroup)
+	statusErr := &StatusError{}
+	if errors.Is(err, ErrNotFound) || errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusMethodNotAllowed {
+		return map[string]string{}, nil
+	}
+	return vars, err
+}
+
+// SetMysqlVariables replaces the proxysql mysql_variables set for an
+// instance group, variables left out go back to the defaults of the
+// chart. It takes the same api.IfMatch and api.CaptureRevision options
+// as ModifyDatabase.
+func (c *Client) SetMysqlVariables(instanceGroup string, variables map[string]string, opts ...RequestOption) error {
+	if variables == nil {
+		variables = map[string]string{}
+	}
+	b, err := json.Marshal(&mysqlVariables{InstanceGroup: instanceGroup, MysqlVariables: variables})
+	if err != nil {
+		return err
+	}
+	u, err := c.endpoint(nil, "mysqlvariables", instanceGroup)
+	if err != nil {
+		return err
+	}
+	_, err = c.makeRequest(b, u, http.MethodPut, opts...)
+	c.InvalidateCache(instanceGroup)
+	return wrapPrecondition(err, instanceGroup, newRequestOptions(opts))
+}
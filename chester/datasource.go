@@ -43,6 +43,10 @@ func dataSourceDatabase() *schema.Resource {
 				Type:     schema.TypeInt,
 				Computed: true,
 			},
+			"revision": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
 			"query_rules": &schema.Schema{
 				Type:     schema.TypeList,
 				Computed: true,
@@ -76,6 +80,71 @@ func dataSourceDatabase() *schema.Resource {
 							Type:     schema.TypeString,
 							Computed: true,
 						},
+						"match_pattern": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"negate_match_pattern": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"schemaname": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"client_addr": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"flag_in": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						// -1 is NULL
+						"flag_out": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"replace_pattern": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"cache_ttl": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"timeout": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"retries": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"delay": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"mirror_hostgroup": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"mirror_flag_out": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"multiplex": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"error_msg": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"log": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
 					},
 				},
 			},
@@ -99,6 +168,42 @@ func dataSourceDatabase() *schema.Resource {
 					},
 				},
 			},
+			"hostgroup": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"hostgroup_id": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"servers": &schema.Schema{
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": &schema.Schema{
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+									"ip_address": &schema.Schema{
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+								},
+							},
+						},
+						"max_connections": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"comment": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
 		},
 	}
 }
@@ -109,7 +214,7 @@ func dataSourcesDatabaseRead(ctx context.Context, d *schema.ResourceData, m inte
 	databaseName := d.Get("instance_name").(string)
 	var diags diag.Diagnostics
 	// Warning or errors can be collected in a slice type
-	db, err := c.GetDatabase(databaseName)
+	db, revision, err := c.GetInstanceGroup(databaseName)
 	if err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Error,
@@ -117,6 +222,13 @@ func dataSourcesDatabaseRead(ctx context.Context, d *schema.ResourceData, m inte
 		})
 		return diags
 	}
+	if err := d.Set("revision", revision); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed setting revision with error %s", err.Error()),
+		})
+		return diags
+	}
 	if err := d.Set("username", db.Username); err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Error,
@@ -162,6 +274,9 @@ func dataSourcesDatabaseRead(ctx context.Context, d *schema.ResourceData, m inte
 		})
 		return diags
 	}
+	if err := d.Set("hostgroup", flattenHostgroups(db.Hostgroups, -1)); err != nil {
+		return diag.FromErr(err)
+	}
 	if err := d.Set("username", db.Username); err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Error,
@@ -0,0 +1,85 @@
+package chester
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/proxysql"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// dataSourceProxySQLConfig renders the proxysql.cnf a proxysql pod of an
+// instance group runs, for debugging and for proxysql sidecars run outside
+// chester.
+func dataSourceProxySQLConfig() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: dataSourceProxySQLConfigRead,
+		Schema: map[string]*schema.Schema{
+			"instance_name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			// replaces the passwords, admin credentials and monitor password
+			"mask_credentials": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"config": &schema.Schema{
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"revision": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceProxySQLConfigRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*chester.Client)
+	var diags diag.Diagnostics
+	db, revision, err := c.GetInstanceGroup(d.Get("instance_name").(string))
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed getting database with error %s", err.Error()),
+		})
+		return diags
+	}
+	vars, err := c.GetMysqlVariablesIfSupported(db.InstanceName)
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed getting mysql_variables with error %s", err.Error()),
+		})
+		return diags
+	}
+	psql := proxysql.NewProxySqlConfig(db)
+	psql.Variables = vars
+	if d.Get("mask_credentials").(bool) {
+		proxysql.MaskCredentials(psql)
+	}
+	if err := d.Set("config", string(proxysql.Render(psql))); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed setting config with error %s", err.Error()),
+		})
+		return diags
+	}
+	if err := d.Set("revision", revision); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed setting revision with error %s", err.Error()),
+		})
+		return diags
+	}
+	d.SetId(strconv.FormatInt(time.Now().Unix(), 10))
+	return diags
+}
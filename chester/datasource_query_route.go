@@ -0,0 +1,204 @@
+package chester
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/proxysql"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// dataSourceQueryRoute simulates the query rules of an instance group
+// against sample statements, to see which rules a statement hits without
+// running it through proxysql.
+func dataSourceQueryRoute() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: dataSourceQueryRouteRead,
+		Schema: map[string]*schema.Schema{
+			"instance_name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"queries": &schema.Schema{
+				Type:     schema.TypeList,
+				Required: true,
+				MinItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"sql": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						// defaults to the user of the instance group
+						"username": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						// the default schema of the connection, for rules with a schemaname
+						"schemaname": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						// the address of the client, for rules with a client_addr
+						"client_addr": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+			// a route for every query, in the same order
+			"routes": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"sql": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"username": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"digest": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						// the rules that matched, in the order they were evaluated
+						"rule_ids": &schema.Schema{
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem:     &schema.Schema{Type: schema.TypeInt},
+						},
+						"destination_hostgroup": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						// false when the statement goes to the user's default hostgroup
+						"matched": &schema.Schema{
+							Type:     schema.TypeBool,
+							Computed: true,
+						},
+						// the statement after every replace_pattern
+						"rewritten_sql": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						// returned to the client instead of running the statement
+						"error_msg": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						// -1 when the result isn't cached
+						"cache_ttl": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						// -1 when the statement isn't mirrored
+						"mirror_hostgroup": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						// -1 when the mirrored copy is sent as is
+						"mirror_flag_out": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"revision": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceQueryRouteRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*chester.Client)
+	var diags diag.Diagnostics
+	db, revision, err := c.GetInstanceGroup(d.Get("instance_name").(string))
+	if err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed getting database with error %s", err.Error()),
+		})
+		return diags
+	}
+	// the instance group's user goes to the writer by default, other
+	// users are looked up once
+	defaultHostgroups := map[string]int{db.Username: db.WriteHostGroup}
+	routes := []map[string]interface{}{}
+	for _, q := range d.Get("queries").([]interface{}) {
+		query := q.(map[string]interface{})
+		username := query["username"].(string)
+		if username == "" {
+			username = db.Username
+		}
+		defaultHostgroup, ok := defaultHostgroups[username]
+		if !ok {
+			user, err := c.GetUser(username)
+			if err != nil {
+				diags = append(diags, diag.Diagnostic{
+					Severity: diag.Error,
+					Summary:  fmt.Sprintf("Failed getting user %s with error %s", username, err.Error()),
+				})
+				return diags
+			}
+			defaultHostgroup = user.DefaultHostgroup
+			defaultHostgroups[username] = defaultHostgroup
+		}
+		route, err := proxysql.RouteStatement(db.QueryRules, proxysql.Statement{
+			Username:   username,
+			SchemaName: query["schemaname"].(string),
+			ClientAddr: query["client_addr"].(string),
+			Query:      query["sql"].(string),
+		}, defaultHostgroup)
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Failed routing query with error %s", err.Error()),
+			})
+			return diags
+		}
+		ruleIDs := make([]interface{}, len(route.Rules))
+		for i, rule := range route.Rules {
+			ruleIDs[i] = rule.RuleID
+		}
+		routes = append(routes, map[string]interface{}{
+			"sql":                   query["sql"],
+			"username":              username,
+			"digest":                route.Digest,
+			"rule_ids":              ruleIDs,
+			"destination_hostgroup": route.DestinationHostgroup,
+			"matched":               !route.Default,
+			"rewritten_sql":         route.Query,
+			"error_msg":             route.ErrorMsg,
+			"cache_ttl":             flattenNullableInt(route.CacheTTL),
+			"mirror_hostgroup":      flattenNullableInt(route.MirrorHostgroup),
+			"mirror_flag_out":       flattenNullableInt(route.MirrorFlagOUT),
+		})
+	}
+	if err := d.Set("routes", routes); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed setting routes with error %s", err.Error()),
+		})
+		return diags
+	}
+	if err := d.Set("revision", revision); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed setting revision with error %s", err.Error()),
+		})
+		return diags
+	}
+	d.SetId(strconv.FormatInt(time.Now().Unix(), 10))
+	return diags
+}
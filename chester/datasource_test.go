@@ -1,3 +1,113 @@
 package chester
 
-// currently no datasources for testing
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/api/apitest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// testLocalClient is a client for srv without IAP.
+func testLocalClient(t *testing.T, srv *apitest.Server) *chester.Client {
+	t.Helper()
+	client, err := chester.NewClientWithOptions(
+		chester.WithHost(srv.URL),
+		chester.WithUsername(srv.Username),
+		chester.WithPassword(srv.Password),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestDataSourceProxySQLConfig(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{
+		InstanceName:   "orders-db",
+		Username:       "orders",
+		Password:       "secret",
+		ReadHostGroup:  10,
+		WriteHostGroup: 5,
+		MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "orders-db", IPAddress: "10.0.0.2"},
+		QueryRules: []models.ProxySqlMySqlQueryRule{
+			{RuleID: 1, Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1},
+		},
+	})
+	client := testLocalClient(t, srv)
+	if err := client.SetMysqlVariables("orders-db", map[string]string{"server_version": "8.0.27"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, mask := range []bool{true, false} {
+		d := schema.TestResourceDataRaw(t, dataSourceProxySQLConfig().Schema, map[string]interface{}{
+			"instance_name":    "orders-db",
+			"mask_credentials": mask,
+		})
+		if diags := dataSourceProxySQLConfigRead(context.Background(), d, client); diags.HasError() {
+			t.Fatalf("read failed %+v", diags)
+		}
+		config := d.Get("config").(string)
+		if !strings.Contains(config, `address="10.0.0.2" , port=3306 , hostgroup=5`) || !strings.Contains(config, `match_digest="^SELECT"`) || !strings.Contains(config, `server_version="8.0.27"`) {
+			t.Fatalf("unexpected config\n%s", config)
+		}
+		if strings.Contains(config, "secret") == mask {
+			t.Fatalf("mask_credentials = %t, got\n%s", mask, config)
+		}
+		if d.Get("revision").(string) != srv.Revision("orders-db") {
+			t.Fatalf("expected revision %s, got %s", srv.Revision("orders-db"), d.Get("revision"))
+		}
+	}
+}
+
+func TestDataSourceQueryRoute(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{
+		InstanceName:   "orders-db",
+		Username:       "orders",
+		Password:       "secret",
+		ReadHostGroup:  10,
+		WriteHostGroup: 5,
+		QueryRules: []models.ProxySqlMySqlQueryRule{
+			{RuleID: 1, Username: "orders", Active: 1, MatchDigest: "^SELECT .* FOR UPDATE", DestinationHostgroup: 5, Apply: 1},
+			{RuleID: 2, Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1},
+		},
+	})
+	client := testLocalClient(t, srv)
+	if err := client.CreateUser(models.ProxySqlMySqlUser{Username: "reporting", Password: "x", DefaultHostgroup: 10, Active: 1, InstanceGroup: "orders-db"}); err != nil {
+		t.Fatal(err)
+	}
+	d := schema.TestResourceDataRaw(t, dataSourceQueryRoute().Schema, map[string]interface{}{
+		"instance_name": "orders-db",
+		"queries": []interface{}{
+			map[string]interface{}{"sql": "SELECT * FROM orders WHERE id = 1 FOR UPDATE"},
+			map[string]interface{}{"sql": "select id from orders where customer IN (1, 2, 3, 4)"},
+			map[string]interface{}{"sql": "DELETE FROM orders WHERE id = 1"},
+			map[string]interface{}{"sql": "UPDATE reports SET seen = 1", "username": "reporting"},
+		},
+	})
+	if diags := dataSourceQueryRouteRead(context.Background(), d, client); diags.HasError() {
+		t.Fatalf("read failed %+v", diags)
+	}
+	want := []map[string]interface{}{
+		{"sql": "SELECT * FROM orders WHERE id = 1 FOR UPDATE", "username": "orders", "digest": "SELECT * FROM orders WHERE id = ? FOR UPDATE", "rule_ids": []interface{}{1}, "destination_hostgroup": 5, "matched": true, "rewritten_sql": "SELECT * FROM orders WHERE id = 1 FOR UPDATE", "error_msg": "", "cache_ttl": -1, "mirror_hostgroup": -1, "mirror_flag_out": -1},
+		{"sql": "select id from orders where customer IN (1, 2, 3, 4)", "username": "orders", "digest": "select id from orders where customer IN (?,?,?,...)", "rule_ids": []interface{}{2}, "destination_hostgroup": 10, "matched": true, "rewritten_sql": "select id from orders where customer IN (1, 2, 3, 4)", "error_msg": "", "cache_ttl": -1, "mirror_hostgroup": -1, "mirror_flag_out": -1},
+		{"sql": "DELETE FROM orders WHERE id = 1", "username": "orders", "digest": "DELETE FROM orders WHERE id = ?", "rule_ids": []interface{}{}, "destination_hostgroup": 5, "matched": false, "rewritten_sql": "DELETE FROM orders WHERE id = 1", "error_msg": "", "cache_ttl": -1, "mirror_hostgroup": -1, "mirror_flag_out": -1},
+		{"sql": "UPDATE reports SET seen = 1", "username": "reporting", "digest": "UPDATE reports SET seen = ?", "rule_ids": []interface{}{}, "destination_hostgroup": 10, "matched": false, "rewritten_sql": "UPDATE reports SET seen = 1", "error_msg": "", "cache_ttl": -1, "mirror_hostgroup": -1, "mirror_flag_out": -1},
+	}
+	routes := d.Get("routes").([]interface{})
+	if len(routes) != len(want) {
+		t.Fatalf("expected %d routes, got %+v", len(want), routes)
+	}
+	for i, route := range routes {
+		if !reflect.DeepEqual(route, want[i]) {
+			t.Errorf("route %d: expected %+v, got %+v", i, want[i], route)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package chester
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/api/apitest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// resourceFixture is a fake chester-api and a client for it. The client
+// goes through faults, which passes every call along until a test injects
+// some.
+type resourceFixture struct {
+	srv    *apitest.Server
+	faults *chester.FaultTransport
+	client *chester.Client
+}
+
+func newResourceFixture(t *testing.T) *resourceFixture {
+	t.Helper()
+	srv := apitest.NewServer()
+	t.Cleanup(srv.Close)
+	faults := chester.NewFaultTransport(nil)
+	client, err := chester.NewClientWithOptions(
+		chester.WithHost(srv.URL),
+		chester.WithUsername(srv.Username),
+		chester.WithPassword(srv.Password),
+		chester.WithHTTPClient(&http.Client{Transport: faults, Timeout: 200 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &resourceFixture{srv: srv, faults: faults, client: client}
+}
+
+// resourceConfig is the chester_database config the tests start from.
+func resourceConfig(username string) map[string]interface{} {
+	return map[string]interface{}{
+		"instance_name":         "test-db",
+		"sql_project_id":        "test-project",
+		"enable_ssl":            0,
+		"username":              username,
+		"password":              "bar",
+		"read_hostgroup":        10,
+		"write_hostgroup":       5,
+		"max_chester_instances": 4,
+		"master_instance": map[string]interface{}{
+			"name":       "test-db",
+			"ip_address": "10.0.0.2",
+		},
+		"read_replicas": []interface{}{
+			map[string]interface{}{
+				"name":       "test-read-1",
+				"ip_address": "10.0.0.11",
+			},
+		},
+	}
+}
+
+// apply plans config against state and applies it.
+func (f *resourceFixture) apply(t *testing.T, state *terraform.InstanceState, config map[string]interface{}) (*terraform.InstanceState, diag.Diagnostics) {
+	t.Helper()
+	r := resourceDatabase()
+	diff, err := r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil {
+		// nothing to change
+		return state, nil
+	}
+	return r.Apply(context.Background(), state, diff, f.client)
+}
+
+// mustApply applies config to state and fails the test if that fails.
+func (f *resourceFixture) mustApply(t *testing.T, state *terraform.InstanceState, config map[string]interface{}) *terraform.InstanceState {
+	t.Helper()
+	state, diags := f.apply(t, state, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected apply failure %v", diags)
+	}
+	return state
+}
+
+// destroy applies a destroy of state.
+func (f *resourceFixture) destroy(t *testing.T, state *terraform.InstanceState) (*terraform.InstanceState, diag.Diagnostics) {
+	t.Helper()
+	return resourceDatabase().Apply(context.Background(), state, &terraform.InstanceDiff{Destroy: true}, f.client)
+}
+
+// create applies resourceConfig.
+func (f *resourceFixture) create(t *testing.T) *terraform.InstanceState {
+	t.Helper()
+	return f.mustApply(t, nil, resourceConfig("foo"))
+}
+
+// expectNoDiff fails the test if config plans any change to state.
+func (f *resourceFixture) expectNoDiff(t *testing.T, state *terraform.InstanceState, config map[string]interface{}) {
+	t.Helper()
+	diff, err := resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != nil && len(diff.Attributes) > 0 {
+		t.Fatalf("expected no diff, got %+v", diff.Attributes)
+	}
+}
+
+// splitQueryRules are query rules sending SELECTs to the read hostgroup of
+// resourceConfig and everything else to its write hostgroup.
+func splitQueryRules() []interface{} {
+	return []interface{}{
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^SELECT", "destination_hostgroup": 10, "apply": 1},
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": ".*", "destination_hostgroup": 5, "apply": 1},
+	}
+}
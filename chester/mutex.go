@@ -0,0 +1,57 @@
+package chester
+
+import "sync"
+
+// mutexKV hands out a mutex per key, so that every mutation of one
+// instance group is serialized while different instance groups still
+// apply in parallel.
+type mutexKV struct {
+	lock  sync.Mutex
+	store map[string]*sync.Mutex
+}
+
+// newMutexKV creates an empty mutexKV.
+func newMutexKV() *mutexKV {
+	return &mutexKV{
+		store: make(map[string]*sync.Mutex),
+	}
+}
+
+// Lock locks the mutex for key, creating it if needed.
+func (m *mutexKV) Lock(key string) {
+	m.get(key).Lock()
+}
+
+// Unlock unlocks the mutex for key.
+func (m *mutexKV) Unlock(key string) {
+	m.get(key).Unlock()
+}
+
+// get returns the mutex for key, mutexes are never removed. A mutex is a
+// few bytes against the instance group it guards, so keeping one for every
+// key seen during the run costs nothing worth reclaiming.
+func (m *mutexKV) get(key string) *sync.Mutex {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	mutex, ok := m.store[key]
+	if !ok {
+		mutex = &sync.Mutex{}
+		m.store[key] = mutex
+	}
+	return mutex
+}
+
+// instanceGroupMutex serializes every mutation against chester-api for an
+// instance group, whichever resource it comes from.
+var instanceGroupMutex = newMutexKV()
+
+// lockInstanceGroup locks the instance group and returns the matching
+// unlock, meant to be deferred.
+//
+//	defer lockInstanceGroup(instanceName)()
+func lockInstanceGroup(instanceGroup string) func() {
+	instanceGroupMutex.Lock(instanceGroup)
+	return func() {
+		instanceGroupMutex.Unlock(instanceGroup)
+	}
+}
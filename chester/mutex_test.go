@@ -0,0 +1,57 @@
+package chester
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMutexKV_SerializesSameKey checks that two holders of the same
+// instance group never overlap.
+func TestMutexKV_SerializesSameKey(t *testing.T) {
+	m := newMutexKV()
+	var mu sync.Mutex
+	holders, maxHolders := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Lock("foo")
+			defer m.Unlock("foo")
+			mu.Lock()
+			holders++
+			if holders > maxHolders {
+				maxHolders = holders
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if maxHolders != 1 {
+		t.Fatalf("expected a single holder at a time, got %d", maxHolders)
+	}
+}
+
+// TestMutexKV_DifferentKeysParallel checks that different instance groups
+// don't block each other.
+func TestMutexKV_DifferentKeysParallel(t *testing.T) {
+	m := newMutexKV()
+	m.Lock("foo")
+	defer m.Unlock("foo")
+	done := make(chan struct{})
+	go func() {
+		m.Lock("shaz")
+		m.Unlock("shaz")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking a different instance group blocked")
+	}
+}
@@ -3,6 +3,7 @@ package chester
 import (
 	"context"
 	"fmt"
+	"time"
 
 	chester "github.com/eahrend/terraform-provider-chester/api"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -40,18 +41,38 @@ func Provider() *schema.Provider {
 				Required:    true,
 				DefaultFunc: schema.EnvDefaultFunc("CHESTER_PASSWORD", ""),
 			},
+			// required unless disable_iap is set
 			"client_id": &schema.Schema{
 				Type:        schema.TypeString,
-				Optional:    false,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("CHESTER_CLIENT_ID", ""),
 			},
+			// skips the IAP token, for chester-api instances that aren't behind IAP
+			"disable_iap": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CHESTER_DISABLE_IAP", false),
+			},
+			// caches reads of instance groups for the length of a run, i.e. "30s"
+			"read_cache_ttl": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CHESTER_READ_CACHE_TTL", ""),
+			},
+			// fills the read cache with a single call listing every instance group
+			"read_cache_bulk": &schema.Schema{
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("CHESTER_READ_CACHE_BULK", false),
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"chester_database": resourceDatabase(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{
-			"chester_database": dataSourceDatabase(),
+			"chester_database":        dataSourceDatabase(),
+			"chester_proxysql_config": dataSourceProxySQLConfig(),
+			"chester_query_route":     dataSourceQueryRoute(),
 		},
 		ConfigureContextFunc: providerConfigure,
 	}
@@ -65,7 +86,20 @@ func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}
 	// Warning or errors can be collected in a slice type
 	var diags diag.Diagnostics
 	if (username != "") && (password != "") {
-		c, err := chester.NewClient(host, username, password, audience)
+		var c *chester.Client
+		var err error
+		if d.Get("disable_iap").(bool) {
+			c, err = chester.NewClientWithOptions(chester.WithHost(host), chester.WithUsername(username), chester.WithPassword(password))
+		} else if audience == "" {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "Set client_id",
+				Detail:   "client_id is the IAP audience, it's required unless disable_iap is set",
+			})
+			return nil, diags
+		} else {
+			c, err = chester.NewClient(host, username, password, audience)
+		}
 		if err != nil {
 			diags = append(diags, diag.Diagnostic{
 				Severity: diag.Error,
@@ -73,6 +107,21 @@ func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}
 			})
 			return nil, diags
 		}
+		if ttl := d.Get("read_cache_ttl").(string); ttl != "" {
+			duration, err := time.ParseDuration(ttl)
+			if err != nil {
+				diags = append(diags, diag.Diagnostic{
+					Severity: diag.Error,
+					Summary:  fmt.Sprintf("Unable to parse read_cache_ttl %s", err.Error()),
+				})
+				return nil, diags
+			}
+			if d.Get("read_cache_bulk").(bool) {
+				chester.WithBulkReadCache(duration)(c)
+			} else {
+				chester.WithReadCache(duration)(c)
+			}
+		}
 		return c, diags
 	} else {
 		diags = append(diags, diag.Diagnostic{
@@ -3,6 +3,7 @@ package chester
 import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -12,7 +13,7 @@ var testAccProvider *schema.Provider
 func init() {
 	testAccProvider = Provider()
 	testAccProviders = map[string]func() (*schema.Provider, error){
-		"terraform-provider-chester": func() (*schema.Provider, error) { return testAccProvider, nil },
+		"chester": func() (*schema.Provider, error) { return testAccProvider, nil },
 	}
 }
 
@@ -29,4 +30,8 @@ func testAccPreCheck(t *testing.T) {
 	if v := os.Getenv("CHESTER_CLIENT_ID"); v == "" {
 		t.Fatal("CHESTER_CLIENT_ID must be set for acceptance tests")
 	}
+	// the sweeper only finds instance groups with its prefix
+	if v := os.Getenv("INSTANCE_NAME"); !strings.HasPrefix(v, sweepPrefix()) {
+		t.Fatalf("INSTANCE_NAME must start with %q for acceptance tests, got %q", sweepPrefix(), v)
+	}
 }
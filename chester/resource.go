@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	models "github.com/eahrend/chestermodels"
 	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/proxysql"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func resourceDatabase() *schema.Resource {
@@ -18,6 +22,10 @@ func resourceDatabase() *schema.Resource {
 		DeleteContext: resourceDatabaseDelete,
 		CreateContext: resourceDatabaseCreate,
 		UpdateContext: resourceDatabaseUpdate,
+		CustomizeDiff: customdiff.All(customizeDiffMasterSwitchover, customizeDiffHostgroups, customizeDiffPlannedQueryRules, customizeDiffQueryRules, customizeDiffLintQueryRules, customizeDiffRevision),
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
 		Schema: map[string]*schema.Schema{
 			"instance_name": &schema.Schema{
 				Type:     schema.TypeString,
@@ -40,6 +48,19 @@ func resourceDatabase() *schema.Resource {
 				Required:  true,
 				Sensitive: true,
 			},
+			// the mysql user proxysql's monitor checks replication lag and
+			// read_only with, the chart's shared monitor user when left out
+			"monitor_username": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				RequiredWith: []string{"monitor_password"},
+			},
+			"monitor_password": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Sensitive:    true,
+				RequiredWith: []string{"monitor_username"},
+			},
 			"read_hostgroup": &schema.Schema{
 				Type:     schema.TypeInt,
 				Required: true,
@@ -70,35 +91,155 @@ func resourceDatabase() *schema.Resource {
 				Type:     schema.TypeInt,
 				Required: true,
 			},
+			// revision of the instance group at the last read, sent as If-Match on changes
+			"revision": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			// chester-api generates default query rules when none are set
 			"query_rules": &schema.Schema{
 				Type:             schema.TypeList,
 				Optional:         true,
+				Computed:         true,
 				DiffSuppressFunc: supressQueryRules,
+				Elem:             queryRuleResource(),
+			},
+			// expands to the preset's rules, with prepend_query_rules before
+			// and append_query_rules after them, in place of query_rules
+			"query_rule_preset": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ValidateFunc:  validation.StringInSlice(proxysql.Presets, false),
+				ConflictsWith: []string{"query_rules"},
+			},
+			"prepend_query_rules": &schema.Schema{
+				Type:         schema.TypeList,
+				Optional:     true,
+				RequiredWith: []string{"query_rule_preset"},
+				Elem:         queryRuleResource(),
+			},
+			"append_query_rules": &schema.Schema{
+				Type:         schema.TypeList,
+				Optional:     true,
+				RequiredWith: []string{"query_rule_preset"},
+				Elem:         queryRuleResource(),
+			},
+			// caches the results of statements matching each rule for
+			// cache_ttl milliseconds, planned as query rules in front of the
+			// rest. size_mb is proxysql's query_cache_size_MB
+			"query_cache": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
-						"rule_id": &schema.Schema{
-							Type:     schema.TypeInt,
-							Computed: true,
+						"size_mb": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      256,
+							ValidateFunc: validation.IntAtLeast(1),
 						},
-						"username": &schema.Schema{
-							Type:     schema.TypeString,
+						"rule": &schema.Schema{
+							Type:     schema.TypeList,
 							Required: true,
+							MinItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"match_digest": &schema.Schema{
+										Type:         schema.TypeString,
+										Required:     true,
+										ValidateFunc: validation.StringIsValidRegExp,
+									},
+									"cache_ttl": &schema.Schema{
+										Type:         schema.TypeInt,
+										Required:     true,
+										ValidateFunc: validation.IntAtLeast(1),
+									},
+									// the instance group's user when left out
+									"username": &schema.Schema{
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									// -1, the read_hostgroup, when left out
+									"destination_hostgroup": &schema.Schema{
+										Type:         schema.TypeInt,
+										Optional:     true,
+										Default:      -1,
+										ValidateFunc: validation.IntAtLeast(-1),
+									},
+								},
+							},
 						},
-						"active": &schema.Schema{
-							Type:     schema.TypeInt,
-							Required: true,
+					},
+				},
+			},
+			// proxysql's mysql_variables by name without the mysql- prefix,
+			// in place of the chart's defaults. query_cache_size_MB is
+			// query_cache's size_mb
+			"mysql_variables": &schema.Schema{
+				Type:         schema.TypeMap,
+				Optional:     true,
+				Elem:         &schema.Schema{Type: schema.TypeString},
+				ValidateFunc: validateMysqlVariables,
+			},
+			// pairs write_hostgroup with read_hostgroup in
+			// mysql_replication_hostgroups, so proxysql's monitor moves the
+			// server that stops being read only into write_hostgroup on a
+			// failover. The servers trading places isn't drift
+			"replication_hostgroup": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"check_type": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "read_only",
+							ValidateFunc: validation.StringInSlice(chester.CheckTypes, false),
 						},
-						"match_digest": &schema.Schema{
+						"comment": &schema.Schema{
 							Type:     schema.TypeString,
-							Required: true,
+							Optional: true,
 						},
-						"destination_hostgroup": &schema.Schema{
-							Type:     schema.TypeInt,
-							Computed: true,
+					},
+				},
+			},
+			// hostgroups beyond the read and write hostgroups, for replicas
+			// that only some query rules send statements to. The servers are
+			// managed like read_replicas
+			"hostgroup": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"hostgroup_id": &schema.Schema{
+							Type:         schema.TypeInt,
+							Required:     true,
+							ValidateFunc: validation.IntAtLeast(0),
 						},
-						"apply": &schema.Schema{
-							Type:     schema.TypeInt,
+						"servers": &schema.Schema{
+							Type:     schema.TypeList,
 							Required: true,
+							MinItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": &schema.Schema{
+										Type:     schema.TypeString,
+										Required: true,
+									},
+									"ip_address": &schema.Schema{
+										Type:     schema.TypeString,
+										Required: true,
+									},
+								},
+							},
+						},
+						"max_connections": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      100,
+							ValidateFunc: validation.IntAtLeast(1),
 						},
 						"comment": &schema.Schema{
 							Type:     schema.TypeString,
@@ -107,7 +248,91 @@ func resourceDatabase() *schema.Resource {
 					},
 				},
 			},
+			// sends a copy of the statements matching match_digests to the
+			// servers of hostgroup_id as well, planned as apply = 0 query rules
+			// with mirror_hostgroup in front of the rest. The servers are
+			// managed like read_replicas
+			"mirror": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"hostgroup_id": &schema.Schema{
+							Type:         schema.TypeInt,
+							Required:     true,
+							ValidateFunc: validation.IntAtLeast(0),
+						},
+						"servers": &schema.Schema{
+							Type:     schema.TypeList,
+							Required: true,
+							MinItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": &schema.Schema{
+										Type:     schema.TypeString,
+										Required: true,
+									},
+									"ip_address": &schema.Schema{
+										Type:     schema.TypeString,
+										Required: true,
+									},
+								},
+							},
+						},
+						"match_digests": &schema.Schema{
+							Type:     schema.TypeList,
+							Required: true,
+							MinItems: 1,
+							Elem: &schema.Schema{
+								Type:         schema.TypeString,
+								ValidateFunc: validation.StringIsValidRegExp,
+							},
+						},
+						// the flag the copies are evaluated with, so query rules
+						// with that flag_in can pick which copies get mirrored.
+						// -1 sends every copy as is
+						"flag_out": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      -1,
+							ValidateFunc: validation.IntAtLeast(-1),
+						},
+						// the instance group's user when left out
+						"username": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+			// derives rule ids from the position in query_rules, the first rule
+			// gets query_rule_id_base, the next one query_rule_id_base + 1 and
+			// so on. 0 leaves rule ids to rule_id and chester-api
+			"query_rule_id_base": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			// how problems the query rule linter finds are reported, warn
+			// lists them in query_rule_warnings and error fails the plan
+			"lint_query_rules": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "warn",
+				ValidateFunc: validation.StringInSlice([]string{"off", "warn", "error"}, false),
+			},
+			// shadowed rules, duplicate rule ids, undeclared destinations and a
+			// missing catch-all to the writer, worked out at plan time
+			"query_rule_warnings": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
 			// Nothing needs to be added here, map[string]interface allows us to add/remove as needed
+			// Swapping the writer with one of the read_replicas is a switchover, any other change
+			// recreates the instance group
 			"master_instance": &schema.Schema{
 				Type:     schema.TypeMap,
 				Required: true,
@@ -138,10 +363,15 @@ func resourceDatabase() *schema.Resource {
 func resourceDatabaseRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	c := m.(*chester.Client)
 	// Warning or errors can be collected in a slice type
-	databaseName := d.Get("instance_name").(string)
+	databaseName := d.Id()
 	var diags diag.Diagnostics
 	// Warning or errors can be collected in a slice type
-	db, err := c.GetDatabase(databaseName)
+	db, revision, err := c.GetInstanceGroup(databaseName)
+	if errors.Is(err, chester.ErrNotFound) {
+		// removed outside of terraform, drop it from state so it gets recreated
+		d.SetId("")
+		return diags
+	}
 	if err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Error,
@@ -149,6 +379,13 @@ func resourceDatabaseRead(ctx context.Context, d *schema.ResourceData, m interfa
 		})
 		return diags
 	}
+	if err := d.Set("revision", revision); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed setting revision with error %s", err.Error()),
+		})
+		return diags
+	}
 	if err := d.Set("username", db.Username); err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Error,
@@ -175,6 +412,55 @@ func resourceDatabaseRead(ctx context.Context, d *schema.ResourceData, m interfa
 	if err := d.Set("query_rules", queryRules); err != nil {
 		return diag.FromErr(err)
 	}
+	mirrorHostgroup := -1
+	if mirror := d.Get("mirror").([]interface{}); len(mirror) > 0 {
+		m := mirror[0].(map[string]interface{})
+		mirrorHostgroup = m["hostgroup_id"].(int)
+		if err := d.Set("mirror", flattenMirror(m, db.Hostgroups)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	if err := d.Set("hostgroup", flattenHostgroups(db.Hostgroups, mirrorHostgroup)); err != nil {
+		return diag.FromErr(err)
+	}
+	replication := flattenReplicationHostgroup(db.ReplicationHostgroups, db.WriteHostGroup, db.ReadHostGroup)
+	if err := d.Set("replication_hostgroup", replication); err != nil {
+		return diag.FromErr(err)
+	}
+	if len(replication) > 0 {
+		db.MasterInstance, db.ReadReplicas = keepServerRoles(d, db.MasterInstance, db.ReadReplicas)
+	}
+	monitor := chester.MonitorCredentials{}
+	if db.Monitor != nil {
+		monitor = *db.Monitor
+	}
+	if err := d.Set("monitor_username", monitor.Username); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := d.Set("monitor_password", monitor.Password); err != nil {
+		return diag.FromErr(err)
+	}
+	// the variables are only worth failing the refresh over when they're
+	// managed, otherwise they're left as they were
+	vars, err := c.GetMysqlVariablesIfSupported(databaseName)
+	if err != nil && managesMysqlVariables(d) {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Failed getting mysql_variables with error %s", err.Error()),
+		})
+		return diags
+	}
+	if err == nil {
+		if err := d.Set("mysql_variables", flattenMysqlVariables(vars)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	// imported instance groups have no lint mode yet
+	if _, ok := d.GetOk("lint_query_rules"); !ok {
+		if err := d.Set("lint_query_rules", "warn"); err != nil {
+			return diag.FromErr(err)
+		}
+	}
 	mr := map[string]interface{}{}
 	mrb, err := json.Marshal(&db.MasterInstance)
 	if err != nil {
@@ -252,7 +538,7 @@ func resourceDatabaseRead(ctx context.Context, d *schema.ResourceData, m interfa
 		})
 		return diags
 	}
-	d.SetId(d.Get("instance_name").(string))
+	d.SetId(db.InstanceName)
 	return diags
 }
 
@@ -291,42 +577,94 @@ func resourceDatabaseCreate(ctx context.Context, d *schema.ResourceData, m inter
 		InstanceGroup:       d.Get("instance_name").(string),
 		MaxChesterInstances: d.Get("max_chester_instances").(int),
 	}
+	defer lockInstanceGroup(cmd.InstanceGroup)()
 	// removing the cert/sa/key stuff here, and will re-add it once it becomes a feature of proxysql
-	db := models.AddDatabaseRequest{
-		EnableSSL:    0,
-		Action:       "add",
-		InstanceName: d.Get("instance_name").(string),
-		Username:     d.Get("username").(string),
-		Password:     d.Get("password").(string),
-		MasterInstance: models.AddDatabaseRequestDatabaseInformation{
-			Name:      mi["name"].(string),
-			IPAddress: mi["ip_address"].(string),
+	db := chester.AddDatabaseRequest{
+		AddDatabaseRequest: models.AddDatabaseRequest{
+			EnableSSL:    0,
+			Action:       "add",
+			InstanceName: d.Get("instance_name").(string),
+			Username:     d.Get("username").(string),
+			Password:     d.Get("password").(string),
+			MasterInstance: models.AddDatabaseRequestDatabaseInformation{
+				Name:      mi["name"].(string),
+				IPAddress: mi["ip_address"].(string),
+			},
+			ReadReplicas:    rrs,
+			ChesterMetaData: cmd,
 		},
-		ReadReplicas:    rrs,
-		ChesterMetaData: cmd,
+		QueryRules: expandQueryRules(d.Get("query_rules").([]interface{})),
+		Hostgroups: expandHostgroups(d.Get("hostgroup").([]interface{}), d.Get("mirror").([]interface{})),
+		ReplicationHostgroups: expandReplicationHostgroups(d.Get("replication_hostgroup").([]interface{}),
+			d.Get("write_hostgroup").(int), d.Get("read_hostgroup").(int)),
+		Monitor: expandMonitor(d),
 	}
-	_, err := c.AddDatabase(db)
+	_, err := c.AddInstanceGroup(db)
 	if err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Error,
 			Summary:  fmt.Sprintf("Failed to add database to datastore %s", err.Error()),
 		})
+		// the add may have been applied before the call failed, i.e. a
+		// timeout, so track the instance group rather than orphaning it.
+		// It's tainted since the create returns an error.
+		if !rejected(err) {
+			if exists, _ := instanceGroupExists(c, db.InstanceName); exists {
+				d.SetId(db.InstanceName)
+				return append(diags, resourceDatabaseRead(ctx, d, m)...)
+			}
+		}
 		return diags
 	}
 
 	d.SetId(d.Get("instance_name").(string))
-	resourceDatabaseRead(ctx, d, m)
-	return diags
+	if managesMysqlVariables(d) {
+		if err := setMysqlVariables(c, d); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Failed setting mysql_variables with error %s", err.Error()),
+			})
+			// the instance group is there, so keep it in state, tainted
+			return append(diags, resourceDatabaseRead(ctx, d, m)...)
+		}
+	}
+	return append(diags, resourceDatabaseRead(ctx, d, m)...)
 }
 
 // TODO: Rework this to make one call, and add user/pass changes as needed
 func resourceDatabaseUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	c := m.(*chester.Client)
 	instanceName := d.Get("instance_name").(string)
+	defer lockInstanceGroup(instanceName)()
 	var diags []diag.Diagnostic
-	mdbr := models.ModifyDatabaseRequest{
-		Action:       "modify",
-		InstanceName: instanceName,
+	// the change is made against the revision terraform planned with, the
+	// new revision is unknown until after the change
+	oldRevision, _ := d.GetChange("revision")
+	revision := oldRevision.(string)
+	mdbr := chester.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{
+			Action:       "modify",
+			InstanceName: instanceName,
+		},
+	}
+	// the switchover goes first, so the rest of the change is made
+	// against the instance group with the new master
+	if d.HasChange("master_instance") {
+		newMaster := d.Get("master_instance").(map[string]interface{})["name"].(string)
+		err := c.SwitchoverMaster(instanceName, newMaster, chester.IfMatch(revision), chester.CaptureRevision(&revision))
+		if err != nil {
+			d.Partial(true)
+		}
+		if errors.Is(err, chester.ErrPreconditionFailed) {
+			return append(diags, preconditionFailedDiag(instanceName, err))
+		}
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Failed switching over to %s with error %s", newMaster, err.Error()),
+			})
+			return append(diags, resourceDatabaseRead(ctx, d, m)...)
+		}
 	}
 	callChange := false
 	if d.HasChange("username") {
@@ -339,24 +677,16 @@ func resourceDatabaseUpdate(ctx context.Context, d *schema.ResourceData, m inter
 		mdbr.NewPassword = newPassword.(string)
 		callChange = true
 	}
+	// query rules are authoritative, the old ones are swapped for the new
+	// ones in the same call so proxysql never runs without rules
 	if d.HasChange("query_rules") {
-		qrs := d.Get("query_rules").([]interface{})
-		for _, queryRule := range qrs {
-			// check if username has changed
-			queryRuleStruct := queryRule.(models.ProxySqlMySqlQueryRule)
-			if d.HasChange("username") {
-				_, newUser := d.GetChange("username")
-				queryRuleStruct.Username = newUser.(string)
-			}
-			err := c.ModifyQueryRuleByID(queryRuleStruct)
-			if err != nil {
-				diags = append(diags, diag.Diagnostic{
-					Severity: diag.Error,
-					Summary:  fmt.Sprintf("unable to modify query rule: %s", err.Error()),
-				})
-				return diags
-			}
+		callChange = true
+		oldQueryRules, newQueryRules := d.GetChange("query_rules")
+		mdbr.RemoveQueryRules = []int{}
+		for _, queryRule := range expandQueryRules(oldQueryRules.([]interface{})) {
+			mdbr.RemoveQueryRules = append(mdbr.RemoveQueryRules, queryRule.RuleID)
 		}
+		mdbr.AddQueryRules = expandQueryRules(newQueryRules.([]interface{}))
 	}
 	if d.HasChange("read_replicas") {
 		callChange = true
@@ -385,6 +715,27 @@ func resourceDatabaseUpdate(ctx context.Context, d *schema.ResourceData, m inter
 		}
 		mdbr.ReadReplicas = rrs
 	}
+	// hostgroups are authoritative too, so the servers of every one of
+	// them are sent whenever one changes
+	if d.HasChange("hostgroup") || d.HasChange("mirror") {
+		callChange = true
+		hostgroups := expandHostgroups(d.Get("hostgroup").([]interface{}), d.Get("mirror").([]interface{}))
+		mdbr.Hostgroups = &hostgroups
+	}
+	if d.HasChange("replication_hostgroup") {
+		callChange = true
+		pairs := expandReplicationHostgroups(d.Get("replication_hostgroup").([]interface{}),
+			d.Get("write_hostgroup").(int), d.Get("read_hostgroup").(int))
+		mdbr.ReplicationHostgroups = &pairs
+	}
+	// empty credentials put the chart's monitor user back
+	if d.HasChanges("monitor_username", "monitor_password") {
+		callChange = true
+		mdbr.Monitor = &chester.MonitorCredentials{}
+		if monitor := expandMonitor(d); monitor != nil {
+			mdbr.Monitor = monitor
+		}
+	}
 	if d.HasChange("max_chester_instances") {
 		callChange = true
 		mdbr.ChesterMetaData = models.ChesterMetaData{
@@ -393,12 +744,43 @@ func resourceDatabaseUpdate(ctx context.Context, d *schema.ResourceData, m inter
 		}
 	}
 	if callChange {
-		err := c.ModifyDatabase(mdbr)
+		err := c.ModifyInstanceGroup(mdbr, chester.IfMatch(revision), chester.CaptureRevision(&revision))
+		if err != nil {
+			// without this the planned values are saved to state even
+			// though the change failed
+			d.Partial(true)
+		}
+		if errors.Is(err, chester.ErrPreconditionFailed) {
+			return append(diags, preconditionFailedDiag(instanceName, err))
+		}
 		if err != nil {
 			diags = append(diags, diag.Diagnostic{
 				Severity: diag.Error,
 				Summary:  err.Error(),
 			})
+			// the change may or may not have been applied, so save
+			// whatever chester-api has now if it can be read
+			readDiags := resourceDatabaseRead(ctx, d, m)
+			if !readDiags.HasError() {
+				d.Partial(false)
+			}
+			return append(diags, readDiags...)
+		}
+	}
+	if d.HasChanges("query_cache", "mysql_variables") {
+		err := setMysqlVariables(c, d, chester.IfMatch(revision))
+		if err != nil {
+			d.Partial(true)
+		}
+		if errors.Is(err, chester.ErrPreconditionFailed) {
+			return append(diags, preconditionFailedDiag(instanceName, err))
+		}
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Failed setting mysql_variables with error %s", err.Error()),
+			})
+			return append(diags, resourceDatabaseRead(ctx, d, m)...)
 		}
 	}
 	debugdiags := resourceDatabaseRead(ctx, d, m)
@@ -418,16 +800,163 @@ func resourceDatabaseDelete(ctx context.Context, d *schema.ResourceData, m inter
 		Severity: diag.Warning,
 	})
 	instanceName := d.Get("instance_name").(string)
+	defer lockInstanceGroup(instanceName)()
 	userName := d.Get("username").(string)
 	rdr := models.RemoveDatabaseRequest{
 		Action:       "remove",
 		InstanceName: instanceName,
 		Username:     userName,
 	}
-	err := c.RemoveDatabase(rdr)
-	if err != nil {
-		return diag.FromErr(err)
+	err := c.RemoveDatabase(rdr, chester.IfMatch(d.Get("revision").(string)))
+	if errors.Is(err, chester.ErrPreconditionFailed) {
+		return append(diags, preconditionFailedDiag(instanceName, err))
+	}
+	if err != nil && !errors.Is(err, chester.ErrNotFound) {
+		// the remove may have been applied before the call failed
+		if exists, existsErr := instanceGroupExists(c, instanceName); existsErr != nil || exists {
+			return append(diags, diag.FromErr(err)...)
+		}
 	}
 	d.SetId("")
 	return diags
 }
+
+// queryRuleResource is a mysql_query_rules row, the element of query_rules
+// and of the rules placed around a query_rule_preset.
+func queryRuleResource() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			// proxysql evaluates rules in rule_id order, so rule ids that
+			// are set have to ascend with the list. Left out, chester-api
+			// assigns one, unless query_rule_id_base is set
+			"rule_id": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"username": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"active": &schema.Schema{
+				Type:     schema.TypeInt,
+				Required: true,
+			},
+			"match_digest": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			// has to be the read_hostgroup or write_hostgroup, left out
+			// chester-api picks it
+			"destination_hostgroup": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"apply": &schema.Schema{
+				Type:     schema.TypeInt,
+				Required: true,
+			},
+			// the comments of the rules query_cache and mirror plan are
+			// theirs, planning would drop a rule of the user's with them
+			"comment": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringNotInSlice([]string{queryCacheComment, mirrorComment}, false),
+			},
+			// matched against the statement itself rather than its digest
+			"match_pattern": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"negate_match_pattern": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntBetween(0, 1),
+			},
+			"schemaname": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			// a trailing % matches by prefix
+			"client_addr": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			// rules are evaluated from flag_in 0, a match moves on to its flag_out
+			"flag_in": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			// -1 leaves flag_out and the other nullable columns below NULL
+			"flag_out": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			// rewrites what match_pattern matched, \1 is the first group
+			"replace_pattern": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"cache_ttl": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			"timeout": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			"retries": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			"delay": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			"mirror_hostgroup": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			// the copy mirror_hostgroup gets is evaluated from this flag
+			"mirror_flag_out": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
+			"multiplex": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntBetween(-1, 2),
+			},
+			// returned to the client instead of running the statement
+			"error_msg": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"log": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntBetween(-1, 1),
+			},
+		},
+	}
+}
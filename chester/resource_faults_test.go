@@ -0,0 +1,241 @@
+package chester
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	models "github.com/eahrend/chestermodels"
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// These tests drive the resource the way terraform does, through Diff and
+// Apply, with chester.FaultTransport failing calls part way through. They
+// check that the state saved after every failure matches what chester-api
+// actually has.
+
+func TestResourceDatabaseCreate_Faults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults []chester.Fault
+		// existing is seeded before the create
+		existing bool
+		// tracked is whether the instance group should end up in state
+		tracked bool
+		// created is whether chester-api should have the instance group
+		created bool
+	}{
+		{
+			name:   "reset before the add is sent",
+			faults: []chester.Fault{{Method: http.MethodPost, Path: "/", Reset: true}},
+		},
+		{
+			name:   "server error before the add is sent",
+			faults: []chester.Fault{{Method: http.MethodPost, Path: "/", StatusCode: http.StatusServiceUnavailable}},
+		},
+		{
+			name:    "reset after the add is applied",
+			faults:  []chester.Fault{{Method: http.MethodPost, Path: "/", Delivered: true, Reset: true}},
+			tracked: true,
+			created: true,
+		},
+		{
+			name:    "timeout after the add is applied",
+			faults:  []chester.Fault{{Method: http.MethodPost, Path: "/", Delivered: true, Latency: time.Second}},
+			tracked: true,
+			created: true,
+		},
+		{
+			name:    "invalid json after the add is applied",
+			faults:  []chester.Fault{{Method: http.MethodPost, Path: "/", Delivered: true, InvalidJSON: true}},
+			tracked: true,
+			created: true,
+		},
+		{
+			name:    "truncated read after the add",
+			faults:  []chester.Fault{{Method: http.MethodGet, Path: "/databases/*", TruncateBody: 10}},
+			tracked: true,
+			created: true,
+		},
+		{
+			name:     "instance group that already exists",
+			existing: true,
+			created:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newResourceFixture(t)
+			if tt.existing {
+				f.srv.PutDatabase(models.InstanceData{InstanceName: "test-db", Username: "someone-else", Password: "baz"})
+			}
+			for _, fault := range tt.faults {
+				f.faults.Inject(fault)
+			}
+			state, diags := f.apply(t, nil, resourceConfig("foo"))
+			if !diags.HasError() {
+				t.Fatal("expected the create to fail")
+			}
+			if tracked := state != nil && state.ID != ""; tracked != tt.tracked {
+				t.Fatalf("expected the instance group in state: %t, got %t", tt.tracked, tracked)
+			}
+			if _, created := f.srv.Database("test-db"); created != tt.created {
+				t.Fatalf("expected the instance group in chester-api: %t, got %t", tt.created, created)
+			}
+		})
+	}
+}
+
+func TestResourceDatabaseUpdate_Faults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults []chester.Fault
+		// outside changes the instance group after the plan
+		outside bool
+		// username is the username that should end up in state
+		username string
+		// applied is whether chester-api should have the new username
+		applied bool
+	}{
+		{
+			name:     "reset before the modify is sent",
+			faults:   []chester.Fault{{Method: http.MethodPatch, Path: "/", Reset: true}},
+			username: "foo",
+		},
+		{
+			name:     "reset after the modify is applied",
+			faults:   []chester.Fault{{Method: http.MethodPatch, Path: "/", Delivered: true, Reset: true}},
+			username: "baz",
+			applied:  true,
+		},
+		{
+			name: "reset after the modify is applied and the read fails",
+			faults: []chester.Fault{
+				{Method: http.MethodPatch, Path: "/", Delivered: true, Reset: true},
+				{Method: http.MethodGet, Path: "/databases/*", Reset: true},
+			},
+			username: "foo",
+			applied:  true,
+		},
+		{
+			name:     "server error before the modify is sent",
+			faults:   []chester.Fault{{Method: http.MethodPatch, Path: "/", StatusCode: http.StatusInternalServerError}},
+			username: "foo",
+		},
+		{
+			name:     "changed since plan",
+			outside:  true,
+			username: "foo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newResourceFixture(t)
+			state := f.create(t)
+			r := resourceDatabase()
+			diff, err := r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(resourceConfig("baz")), f.client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.outside {
+				f.srv.UpdateDatabase("test-db", func(db *models.InstanceData) { db.Password = "changed" })
+			}
+			for _, fault := range tt.faults {
+				f.faults.Inject(fault)
+			}
+			newState, diags := r.Apply(context.Background(), state, diff, f.client)
+			if !diags.HasError() {
+				t.Fatal("expected the update to fail")
+			}
+			if username := newState.Attributes["username"]; username != tt.username {
+				t.Fatalf("expected username %s in state, got %s", tt.username, username)
+			}
+			if newState.Attributes["revision"] == "" {
+				t.Fatal("expected a revision in state")
+			}
+			db, _ := f.srv.Database("test-db")
+			if applied := db.Username == "baz"; applied != tt.applied {
+				t.Fatalf("expected the modify to be applied: %t, got %t", tt.applied, applied)
+			}
+			// the next run must still be able to converge
+			f.faults.Clear()
+			refreshed, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), newState, f.client)
+			if diags.HasError() {
+				t.Fatalf("unexpected refresh failure %v", diags)
+			}
+			converged, diags := f.apply(t, refreshed, resourceConfig("baz"))
+			if diags.HasError() {
+				t.Fatalf("unexpected update failure %v", diags)
+			}
+			if converged.Attributes["username"] != "baz" {
+				t.Fatalf("expected username baz after the retry, got %s", converged.Attributes["username"])
+			}
+		})
+	}
+}
+
+func TestResourceDatabaseDelete_Faults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults []chester.Fault
+		// outside removes the instance group before the delete
+		outside bool
+		// tracked is whether the instance group should stay in state
+		tracked bool
+		// removed is whether chester-api should no longer have it
+		removed bool
+	}{
+		{
+			name:    "reset before the remove is sent",
+			faults:  []chester.Fault{{Method: http.MethodDelete, Path: "/", Reset: true}},
+			tracked: true,
+		},
+		{
+			name:    "reset after the remove is applied",
+			faults:  []chester.Fault{{Method: http.MethodDelete, Path: "/", Delivered: true, Reset: true}},
+			removed: true,
+		},
+		{
+			name:    "timeout after the remove is applied",
+			faults:  []chester.Fault{{Method: http.MethodDelete, Path: "/", Delivered: true, Latency: time.Second}},
+			removed: true,
+		},
+		{
+			name: "server error and the read fails",
+			faults: []chester.Fault{
+				{Method: http.MethodDelete, Path: "/", StatusCode: http.StatusBadGateway},
+				{Method: http.MethodGet, Path: "/databases/*", Reset: true},
+			},
+			tracked: true,
+		},
+		{
+			name:    "already removed",
+			outside: true,
+			removed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newResourceFixture(t)
+			state := f.create(t)
+			if tt.outside {
+				f.srv.DeleteDatabase("test-db")
+			}
+			for _, fault := range tt.faults {
+				f.faults.Inject(fault)
+			}
+			newState, diags := f.destroy(t, state)
+			if diags.HasError() != tt.tracked {
+				t.Fatalf("expected the delete to fail: %t, got %v", tt.tracked, diags)
+			}
+			if tracked := newState != nil && newState.ID != ""; tracked != tt.tracked {
+				t.Fatalf("expected the instance group in state: %t, got %t", tt.tracked, tracked)
+			}
+			if _, exists := f.srv.Database("test-db"); exists == tt.removed {
+				t.Fatalf("expected the instance group to be removed: %t, got %t", tt.removed, !exists)
+			}
+		})
+	}
+}
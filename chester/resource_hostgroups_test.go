@@ -0,0 +1,97 @@
+package chester
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// TestResourceDatabase_Hostgroups checks that hostgroup blocks reach
+// chester-api, that query rules can send statements to them and that
+// adding a server updates them in place.
+func TestResourceDatabase_Hostgroups(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["hostgroup"] = []interface{}{
+		map[string]interface{}{
+			"hostgroup_id": 30,
+			"servers": []interface{}{
+				map[string]interface{}{"name": "test-analytics-1", "ip_address": "10.0.0.31"},
+			},
+			"max_connections": 20,
+			"comment":         "analytics",
+		},
+	}
+	config["query_rules"] = []interface{}{
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^SELECT .* FROM reports", "destination_hostgroup": 30, "apply": 1},
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^SELECT", "destination_hostgroup": 10, "apply": 1},
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": ".*", "destination_hostgroup": 5, "apply": 1},
+	}
+	state := f.mustApply(t, nil, config)
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Hostgroups) != 1 || db.Hostgroups[0].HostgroupID != 30 || db.Hostgroups[0].MaxConnections != 20 || db.Hostgroups[0].Comment != "analytics" {
+		t.Fatalf("expected hostgroup 30, got %+v", db.Hostgroups)
+	}
+	if warning := state.Attributes["query_rule_warnings.0"]; warning != "" {
+		t.Fatalf("expected hostgroup 30 to lint clean, got %s", warning)
+	}
+	f.expectNoDiff(t, state, config)
+
+	hostgroup := config["hostgroup"].([]interface{})[0].(map[string]interface{})
+	hostgroup["servers"] = append(hostgroup["servers"].([]interface{}),
+		map[string]interface{}{"name": "test-analytics-2", "ip_address": "10.0.0.32"})
+	state = f.mustApply(t, state, config)
+	if servers := f.srv.Hostgroups("test-db")[30]; len(servers) != 2 || servers[1].Name != "test-analytics-2" {
+		t.Fatalf("expected two servers in hostgroup 30, got %+v", servers)
+	}
+	if got := state.Attributes["hostgroup.0.servers.#"]; got != "2" {
+		t.Fatalf("expected two servers in state, got %s", got)
+	}
+}
+
+func TestCustomizeDiffHostgroups(t *testing.T) {
+	hostgroup := func(id int) map[string]interface{} {
+		return map[string]interface{}{
+			"hostgroup_id": id,
+			"servers": []interface{}{
+				map[string]interface{}{"name": "test-analytics", "ip_address": "10.0.0.31"},
+			},
+		}
+	}
+	mirror := func(id int) []interface{} {
+		return []interface{}{map[string]interface{}{
+			"hostgroup_id": id,
+			"servers": []interface{}{
+				map[string]interface{}{"name": "test-shadow", "ip_address": "10.0.0.21"},
+			},
+			"match_digests": []interface{}{"^SELECT"},
+		}}
+	}
+	for _, c := range []struct {
+		name       string
+		hostgroups []interface{}
+		mirror     []interface{}
+		want       string
+	}{
+		{"write", []interface{}{hostgroup(5)}, nil, "hostgroup.0: hostgroup_id 5 has to differ from the read_hostgroup 10 and the write_hostgroup 5"},
+		{"twice", []interface{}{hostgroup(30), hostgroup(30)}, nil, "hostgroup.1: hostgroup_id 30 is already used by hostgroup.0: hostgroup_id"},
+		{"mirror", []interface{}{hostgroup(30)}, mirror(30), "mirror: hostgroup_id 30 is already used by hostgroup.0: hostgroup_id"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := resourceConfig("foo")
+			config["hostgroup"] = c.hostgroups
+			if c.mirror != nil {
+				config["mirror"] = c.mirror
+			}
+			_, err := resourceDatabase().Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expected %q, got %v", c.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package chester
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+func TestCustomizeDiffLintQueryRules(t *testing.T) {
+	config := resourceConfig("foo")
+	config["query_rules"] = []interface{}{
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": ".*", "apply": 1},
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^DELETE", "apply": 1},
+	}
+	r := resourceDatabase()
+	diff, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// destination_hostgroup isn't set in config, so both rules go to hostgroup 0
+	want := []string{
+		"query_rules.0: destination_hostgroup 0 isn't one of the instance group's hostgroups [5 10]",
+		"query_rules.1: destination_hostgroup 0 isn't one of the instance group's hostgroups [5 10]",
+		"query_rules.1: never matches, query_rules.0 before it matches every statement with apply = 1",
+		"no active catch-all rule sends unmatched statements to the write hostgroup 5",
+	}
+	if got := diff.Attributes["query_rule_warnings.#"]; got == nil || got.New != "4" {
+		t.Fatalf("expected 4 warnings, got %+v", got)
+	}
+	for i, w := range want {
+		if got := diff.Attributes["query_rule_warnings."+strconv.Itoa(i)]; got == nil || got.New != w {
+			t.Errorf("warning %d: expected %q, got %+v", i, w, got)
+		}
+	}
+
+	config["lint_query_rules"] = "error"
+	if _, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil); err == nil || !strings.Contains(err.Error(), "never matches") {
+		t.Fatalf("expected the plan to fail, got %v", err)
+	}
+
+	config["lint_query_rules"] = "off"
+	diff, err = r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := diff.Attributes["query_rule_warnings.#"]; got != nil && got.New != "0" {
+		t.Fatalf("expected no warnings, got %+v", got)
+	}
+}
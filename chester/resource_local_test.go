@@ -0,0 +1,150 @@
+package chester
+
+import (
+	"context"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/api/apitest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// These tests drive the resource through Diff and Apply against apitest,
+// an in-memory chester-api, the way terraform would. They don't need
+// TF_ACC or a terraform binary.
+
+// localQueryRules are the query_rules the update step switches to.
+var localQueryRules = []interface{}{
+	map[string]interface{}{"username": "baz", "active": 1, "match_digest": "^SELECT", "apply": 1, "comment": "reads"},
+	map[string]interface{}{"username": "baz", "active": 1, "match_digest": ".*", "apply": 1, "comment": "catch-all"},
+}
+
+func TestResourceDatabase_Local(t *testing.T) {
+	f := newResourceFixture(t)
+	state := f.create(t)
+	if state.ID != "test-db" || state.Attributes["query_rules.#"] != "3" || state.Attributes["revision"] == "" {
+		t.Fatalf("unexpected state after create %+v", state.Attributes)
+	}
+	db, ok := f.srv.Database("test-db")
+	if !ok || db.Username != "foo" || db.Password != "bar" || db.ChesterMetaData.MaxChesterInstances != 4 {
+		t.Fatalf("unexpected instance group %+v", db)
+	}
+
+	// an import starts from nothing but the id
+	imported, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), &terraform.InstanceState{ID: "test-db"}, f.client)
+	if diags.HasError() {
+		t.Fatalf("unexpected import failure %v", diags)
+	}
+	for k, v := range state.Attributes {
+		if k == "sql_project_id" || k == "query_rule_id_base" {
+			// chester-api doesn't know the sql project, and the id base
+			// only shapes plans
+			continue
+		}
+		if imported.Attributes[k] != v {
+			t.Errorf("imported %s: expected %q, got %q", k, v, imported.Attributes[k])
+		}
+	}
+
+	config := resourceConfig("baz")
+	config["password"] = "qux"
+	config["max_chester_instances"] = 3
+	config["read_replicas"] = append(config["read_replicas"].([]interface{}), map[string]interface{}{"name": "test-read-2", "ip_address": "10.0.0.12"})
+	config["query_rules"] = localQueryRules
+	state, diags = f.apply(t, state, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected update failure %v", diags)
+	}
+	if state.Attributes["query_rules.#"] != "2" || state.Attributes["query_rules.1.comment"] != "catch-all" {
+		t.Fatalf("unexpected query rules in state %+v", state.Attributes)
+	}
+	db, _ = f.srv.Database("test-db")
+	if db.Username != "baz" || db.Password != "qux" || db.ChesterMetaData.MaxChesterInstances != 3 {
+		t.Fatalf("update wasn't applied %+v", db)
+	}
+	if len(db.ReadReplicas) != 2 || len(db.QueryRules) != 2 {
+		t.Fatalf("expected 2 replicas and 2 query rules, got %+v", db)
+	}
+
+	// a writer that isn't one of the read replicas can't be switched to
+	config["master_instance"] = map[string]interface{}{"name": "test-db-2", "ip_address": "10.0.0.3"}
+	diff, err := resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil || !diff.RequiresNew() {
+		t.Fatalf("expected a new writer to recreate the instance group, got %+v", diff)
+	}
+}
+
+func TestResourceDatabase_LocalDrift(t *testing.T) {
+	f := newResourceFixture(t)
+	state := f.create(t)
+	f.srv.UpdateDatabase("test-db", func(db *models.InstanceData) {
+		db.ChesterMetaData.MaxChesterInstances = 9
+		db.ReadReplicas = nil
+	})
+	state, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), state, f.client)
+	if diags.HasError() {
+		t.Fatalf("unexpected refresh failure %v", diags)
+	}
+	diff, err := resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(resourceConfig("foo")), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil || diff.Attributes["max_chester_instances"] == nil || diff.Attributes["read_replicas.#"] == nil {
+		t.Fatalf("expected the drift to be planned away, got %+v", diff)
+	}
+	if _, diags := f.apply(t, state, resourceConfig("foo")); diags.HasError() {
+		t.Fatalf("unexpected update failure %v", diags)
+	}
+	db, _ := f.srv.Database("test-db")
+	if db.ChesterMetaData.MaxChesterInstances != 4 || len(db.ReadReplicas) != 1 {
+		t.Fatalf("drift wasn't corrected %+v", db)
+	}
+}
+
+func TestResourceDatabase_LocalOutOfBandDelete(t *testing.T) {
+	f := newResourceFixture(t)
+	state := f.create(t)
+	f.srv.DeleteDatabase("test-db")
+	state, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), state, f.client)
+	if diags.HasError() {
+		t.Fatalf("unexpected refresh failure %v", diags)
+	}
+	if state != nil && state.ID != "" {
+		t.Fatalf("expected the instance group to be dropped from state, got %s", state.ID)
+	}
+	if _, diags := f.apply(t, nil, resourceConfig("foo")); diags.HasError() {
+		t.Fatalf("unexpected create failure %v", diags)
+	}
+	if _, ok := f.srv.Database("test-db"); !ok {
+		t.Fatal("expected the instance group to be recreated")
+	}
+}
+
+func TestProviderConfigure_DisableIAP(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	raw := map[string]interface{}{
+		"host":        srv.URL,
+		"username":    srv.Username,
+		"password":    srv.Password,
+		"disable_iap": true,
+	}
+	m, diags := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, raw))
+	if diags.HasError() {
+		t.Fatalf("unexpected configure failure %v", diags)
+	}
+	if _, err := m.(*chester.Client).GetDatabase("missing"); err == nil {
+		t.Fatal("expected reading a missing instance group to fail")
+	}
+
+	raw["disable_iap"] = false
+	raw["client_id"] = ""
+	if _, diags := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, raw)); !diags.HasError() {
+		t.Fatal("expected client_id to be required with IAP")
+	}
+}
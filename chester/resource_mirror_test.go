@@ -0,0 +1,114 @@
+package chester
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// TestResourceDatabase_Mirror checks that a mirror block sends its
+// hostgroup to chester-api and plans the rules that copy statements to it
+// in front of the rest.
+func TestResourceDatabase_Mirror(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["query_rules"] = splitQueryRules()
+	config["mirror"] = []interface{}{
+		map[string]interface{}{
+			"hostgroup_id": 20,
+			"servers": []interface{}{
+				map[string]interface{}{"name": "test-shadow", "ip_address": "10.0.0.21"},
+			},
+			"match_digests": []interface{}{"^SELECT .* FROM orders"},
+			"flag_out":      3,
+		},
+	}
+	state := f.mustApply(t, nil, config)
+	hostgroups := f.srv.Hostgroups("test-db")
+	if servers := hostgroups[20]; len(servers) != 1 || servers[0].IPAddress != "10.0.0.21" {
+		t.Fatalf("expected the mirror servers in hostgroup 20, got %+v", hostgroups)
+	}
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.QueryRules) != 3 {
+		t.Fatalf("expected 3 rules, got %+v", db.QueryRules)
+	}
+	mirror := db.QueryRules[0]
+	if mirror.MatchDigest != "^SELECT .* FROM orders" || mirror.Apply != 0 || mirror.DestinationHostgroup != 10 ||
+		flattenNullableInt(mirror.MirrorHostgroup) != 20 || flattenNullableInt(mirror.MirrorFlagOUT) != 3 {
+		t.Fatalf("expected the mirror rule first, got %+v", mirror)
+	}
+	if warning := state.Attributes["query_rule_warnings.0"]; warning != "" {
+		t.Fatalf("expected the mirror hostgroup to lint clean, got %s", warning)
+	}
+
+	f.expectNoDiff(t, state, config)
+
+	delete(config, "mirror")
+	state = f.mustApply(t, state, config)
+	if got := state.Attributes["query_rules.#"]; got != "2" {
+		t.Fatalf("expected the mirror rules to be removed, got %s rules", got)
+	}
+	if hostgroups := f.srv.Hostgroups("test-db"); len(hostgroups) != 0 {
+		t.Fatalf("expected the mirror hostgroup to be removed, got %+v", hostgroups)
+	}
+}
+
+// TestResourceDatabase_MirrorRemoved checks that removing mirror without
+// query_rules takes its rules out of the computed query_rules, so nothing
+// points at the removed hostgroup.
+func TestResourceDatabase_MirrorRemoved(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["mirror"] = []interface{}{
+		map[string]interface{}{
+			"hostgroup_id": 20,
+			"servers": []interface{}{
+				map[string]interface{}{"name": "test-shadow", "ip_address": "10.0.0.21"},
+			},
+			"match_digests": []interface{}{"^SELECT .* FROM orders"},
+		},
+	}
+	state := f.mustApply(t, nil, config)
+
+	delete(config, "mirror")
+	state = f.mustApply(t, state, config)
+	if got := state.Attributes["query_rules.#"]; got != "3" {
+		t.Fatalf("expected the default rules without the mirror rule, got %s rules", got)
+	}
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range db.QueryRules {
+		if rule.Comment == mirrorComment || rule.MirrorHostgroup != nil {
+			t.Fatalf("expected the mirror rule to be removed, got %+v", db.QueryRules)
+		}
+	}
+	if hostgroups := f.srv.Hostgroups("test-db"); len(hostgroups) != 0 {
+		t.Fatalf("expected the mirror hostgroup to be removed, got %+v", hostgroups)
+	}
+	f.expectNoDiff(t, state, config)
+}
+
+func TestResourceDatabase_MirrorHostgroupClash(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["mirror"] = []interface{}{
+		map[string]interface{}{
+			"hostgroup_id": 10,
+			"servers": []interface{}{
+				map[string]interface{}{"name": "test-shadow", "ip_address": "10.0.0.21"},
+			},
+			"match_digests": []interface{}{"^SELECT"},
+		},
+	}
+	_, err := resourceDatabase().Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), f.client)
+	if err == nil || !strings.Contains(err.Error(), "mirror: hostgroup_id 10 has to differ") {
+		t.Fatalf("expected the mirror hostgroup to be rejected, got %v", err)
+	}
+}
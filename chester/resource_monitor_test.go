@@ -0,0 +1,34 @@
+package chester
+
+import "testing"
+
+// TestResourceDatabase_Monitor checks that monitor_username and
+// monitor_password are sent to chester-api, and that removing them goes
+// back to the chart's monitor user.
+func TestResourceDatabase_Monitor(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["monitor_username"] = "test-monitor"
+	config["monitor_password"] = "baz"
+	state := f.mustApply(t, nil, config)
+	if username, password := f.srv.MonitorCredentials("test-db"); username != "test-monitor" || password != "baz" {
+		t.Fatalf("expected the monitor user test-monitor/baz, got %s/%s", username, password)
+	}
+	f.expectNoDiff(t, state, config)
+
+	config["monitor_password"] = "qux"
+	state = f.mustApply(t, state, config)
+	if _, password := f.srv.MonitorCredentials("test-db"); password != "qux" {
+		t.Fatalf("expected the monitor password to be qux, got %s", password)
+	}
+
+	delete(config, "monitor_username")
+	delete(config, "monitor_password")
+	state = f.mustApply(t, state, config)
+	if username, _ := f.srv.MonitorCredentials("test-db"); username != "" {
+		t.Fatalf("expected the chart's monitor user, got %s", username)
+	}
+	if got := state.Attributes["monitor_username"]; got != "" {
+		t.Fatalf("expected monitor_username to be cleared, got %s", got)
+	}
+}
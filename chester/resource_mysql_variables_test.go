@@ -0,0 +1,107 @@
+package chester
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// TestResourceDatabaseRead_MysqlVariablesUnavailable checks that a
+// chester-api without the mysqlvariables endpoint, or one failing to
+// answer it, only fails reads that manage mysql variables.
+func TestResourceDatabaseRead_MysqlVariablesUnavailable(t *testing.T) {
+	f := newResourceFixture(t)
+	state := f.create(t)
+	for _, statusCode := range []int{http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusInternalServerError} {
+		f.faults.Clear()
+		f.faults.Inject(chester.Fault{Method: http.MethodGet, Path: "/mysqlvariables/*", StatusCode: statusCode})
+		if _, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), state, f.client); diags.HasError() {
+			t.Fatalf("%d: unexpected refresh failure %v", statusCode, diags)
+		}
+	}
+	f.faults.Clear()
+	f.faults.Inject(chester.Fault{Method: http.MethodGet, Path: "/mysqlvariables/*", StatusCode: http.StatusNotFound})
+	d := schema.TestResourceDataRaw(t, dataSourceProxySQLConfig().Schema, map[string]interface{}{"instance_name": "test-db"})
+	if diags := dataSourceProxySQLConfigRead(context.Background(), d, f.client); diags.HasError() {
+		t.Fatalf("unexpected data source failure %v", diags)
+	}
+
+	f.faults.Clear()
+	config := resourceConfig("foo")
+	config["mysql_variables"] = map[string]interface{}{"server_version": "8.0.27"}
+	state, diags := f.apply(t, state, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected update failure %v", diags)
+	}
+	f.faults.Inject(chester.Fault{Method: http.MethodGet, Path: "/mysqlvariables/*", StatusCode: http.StatusInternalServerError})
+	if _, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), state, f.client); !diags.HasError() {
+		t.Fatal("expected the refresh to fail with mysql_variables set")
+	}
+}
+
+// TestResourceDatabase_MysqlVariables checks that mysql_variables replaces
+// the variables of the instance group next to query_cache's size, and that
+// variables changed outside of terraform show up as drift.
+func TestResourceDatabase_MysqlVariables(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["mysql_variables"] = map[string]interface{}{"server_version": "8.0.27", "wait_timeout": "600"}
+	config["query_cache"] = []interface{}{
+		map[string]interface{}{
+			"rule": []interface{}{
+				map[string]interface{}{"match_digest": "^SELECT .* FROM reports", "cache_ttl": 60000},
+			},
+		},
+	}
+	state := f.mustApply(t, nil, config)
+	want := map[string]string{"server_version": "8.0.27", "wait_timeout": "600", "query_cache_size_MB": "256"}
+	if vars := f.srv.MysqlVariables("test-db"); !reflect.DeepEqual(vars, want) {
+		t.Fatalf("expected mysql variables %v, got %v", want, vars)
+	}
+	if got := state.Attributes["mysql_variables.%"]; got != "2" {
+		t.Fatalf("expected query_cache_size_MB to be left out of mysql_variables, got %s variables", got)
+	}
+
+	config["mysql_variables"] = map[string]interface{}{"server_version": "5.7.36"}
+	state = f.mustApply(t, state, config)
+	want = map[string]string{"server_version": "5.7.36", "query_cache_size_MB": "256"}
+	if vars := f.srv.MysqlVariables("test-db"); !reflect.DeepEqual(vars, want) {
+		t.Fatalf("expected mysql variables %v, got %v", want, vars)
+	}
+
+	if err := f.client.SetMysqlVariables("test-db", map[string]string{"threads": "16"}); err != nil {
+		t.Fatal(err)
+	}
+	state, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), state, f.client)
+	if diags.HasError() {
+		t.Fatalf("unexpected refresh failure %v", diags)
+	}
+	diff, err := resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff == nil || diff.Attributes["mysql_variables.threads"] == nil || diff.Attributes["mysql_variables.server_version"] == nil {
+		t.Fatalf("expected the changed variables to be drift, got %+v", diff)
+	}
+}
+
+func TestValidateMysqlVariables(t *testing.T) {
+	for _, tc := range []struct {
+		vars  map[string]interface{}
+		valid bool
+	}{
+		{map[string]interface{}{"threads": "8", "server_version": "8.0.27"}, true},
+		{map[string]interface{}{"threads": "eight"}, false},
+		{map[string]interface{}{"thread": "8"}, false},
+		{map[string]interface{}{"query_cache_size_MB": "512"}, false},
+	} {
+		if _, errs := validateMysqlVariables(tc.vars, "mysql_variables"); (len(errs) == 0) != tc.valid {
+			t.Errorf("%v: expected valid %t, got %v", tc.vars, tc.valid, errs)
+		}
+	}
+}
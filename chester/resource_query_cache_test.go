@@ -0,0 +1,171 @@
+package chester
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// TestResourceDatabase_QueryCache checks that query_cache plans its rules
+// in front of query_rules without a diff on the next plan, and that
+// query_cache_size_MB follows the block.
+func TestResourceDatabase_QueryCache(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["query_rules"] = splitQueryRules()
+	config["query_cache"] = []interface{}{
+		map[string]interface{}{
+			"size_mb": 512,
+			"rule": []interface{}{
+				map[string]interface{}{"match_digest": "^SELECT .* FROM reports", "cache_ttl": 60000},
+				map[string]interface{}{"match_digest": "^SELECT .* FROM totals", "cache_ttl": 5000, "username": "reporting", "destination_hostgroup": 5},
+			},
+		},
+	}
+	state := f.mustApply(t, nil, config)
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		username    string
+		matchDigest string
+		hostgroup   int
+		cacheTTL    int
+	}{
+		{"foo", "^SELECT .* FROM reports", 10, 60000},
+		{"reporting", "^SELECT .* FROM totals", 5, 5000},
+		{"foo", "^SELECT", 10, -1},
+		{"foo", ".*", 5, -1},
+	}
+	if len(db.QueryRules) != len(want) {
+		t.Fatalf("expected %d rules, got %+v", len(want), db.QueryRules)
+	}
+	for i, w := range want {
+		rule := db.QueryRules[i]
+		if rule.Username != w.username || rule.MatchDigest != w.matchDigest || rule.DestinationHostgroup != w.hostgroup || flattenNullableInt(rule.CacheTTL) != w.cacheTTL {
+			t.Errorf("rule %d: expected %+v, got %+v", i, w, rule)
+		}
+	}
+	if size := f.srv.MysqlVariables("test-db")["query_cache_size_MB"]; size != "512" {
+		t.Fatalf("expected query_cache_size_MB 512, got %q", size)
+	}
+
+	f.expectNoDiff(t, state, config)
+
+	delete(config, "query_cache")
+	state = f.mustApply(t, state, config)
+	if got := state.Attributes["query_rules.#"]; got != "2" {
+		t.Fatalf("expected the cache rules to be removed, got %s rules", got)
+	}
+	if vars := f.srv.MysqlVariables("test-db"); len(vars) != 0 {
+		t.Fatalf("expected query_cache_size_MB to be cleared, got %v", vars)
+	}
+}
+
+// TestResourceDatabase_QueryCacheDefaultRules checks that without
+// query_rules the cache rules go in front of the default rules.
+func TestResourceDatabase_QueryCacheDefaultRules(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	delete(config, "query_rules")
+	config["query_cache"] = []interface{}{
+		map[string]interface{}{
+			"rule": []interface{}{
+				map[string]interface{}{"match_digest": "^SELECT .* FROM reports", "cache_ttl": 60000},
+			},
+		},
+	}
+	state := f.mustApply(t, nil, config)
+	want := []string{"^SELECT .* FROM reports", "^SELECT .* FOR UPDATE", "^SELECT", ".*"}
+	for i, w := range want {
+		if got := state.Attributes[fmt.Sprintf("query_rules.%d.match_digest", i)]; got != w {
+			t.Errorf("rule %d: expected match_digest %s, got %s", i, w, got)
+		}
+	}
+	if size := f.srv.MysqlVariables("test-db")["query_cache_size_MB"]; size != "256" {
+		t.Fatalf("expected the default query_cache_size_MB 256, got %q", size)
+	}
+	f.expectNoDiff(t, state, config)
+}
+
+// TestResourceDatabase_QueryCacheRemoved checks that removing query_cache
+// without query_rules takes its rules out of the computed query_rules.
+func TestResourceDatabase_QueryCacheRemoved(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["query_cache"] = []interface{}{
+		map[string]interface{}{
+			"rule": []interface{}{
+				map[string]interface{}{"match_digest": "^SELECT .* FROM reports", "cache_ttl": 60000},
+			},
+		},
+	}
+	state := f.mustApply(t, nil, config)
+
+	delete(config, "query_cache")
+	state = f.mustApply(t, state, config)
+	want := []string{"^SELECT .* FOR UPDATE", "^SELECT", ".*"}
+	if got := state.Attributes["query_rules.#"]; got != fmt.Sprint(len(want)) {
+		t.Fatalf("expected %d rules, got %s", len(want), got)
+	}
+	for i, w := range want {
+		if got := state.Attributes[fmt.Sprintf("query_rules.%d.match_digest", i)]; got != w {
+			t.Errorf("rule %d: expected match_digest %s, got %s", i, w, got)
+		}
+	}
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range db.QueryRules {
+		if rule.Comment == queryCacheComment {
+			t.Fatalf("expected the cache rule to be removed, got %+v", db.QueryRules)
+		}
+	}
+	f.expectNoDiff(t, state, config)
+}
+
+// TestResourceDatabase_QueryCacheHostgroupZero checks that a cache rule
+// sent to hostgroup 0 on purpose isn't moved to the read hostgroup.
+func TestResourceDatabase_QueryCacheHostgroupZero(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["hostgroup"] = []interface{}{
+		map[string]interface{}{
+			"hostgroup_id": 0,
+			"servers":      []interface{}{map[string]interface{}{"name": "test-zero", "ip_address": "10.0.0.30"}},
+		},
+	}
+	config["query_cache"] = []interface{}{
+		map[string]interface{}{
+			"rule": []interface{}{
+				map[string]interface{}{"match_digest": "^SELECT .* FROM reports", "cache_ttl": 60000, "destination_hostgroup": 0},
+				map[string]interface{}{"match_digest": "^SELECT .* FROM totals", "cache_ttl": 60000},
+			},
+		},
+	}
+	state := f.mustApply(t, nil, config)
+	if got := state.Attributes["query_rules.0.destination_hostgroup"]; got != "0" {
+		t.Fatalf("expected the first cache rule to go to hostgroup 0, got %s", got)
+	}
+	if got := state.Attributes["query_rules.1.destination_hostgroup"]; got != "10" {
+		t.Fatalf("expected the second cache rule to go to the read hostgroup, got %s", got)
+	}
+}
+
+// TestResourceDatabase_PlannedCommentsReserved checks that query rules
+// can't take the comments of the rules query_cache and mirror plan.
+func TestResourceDatabase_PlannedCommentsReserved(t *testing.T) {
+	for _, comment := range []string{"query_cache", "mirror"} {
+		config := resourceConfig("foo")
+		config["query_rules"] = []interface{}{
+			map[string]interface{}{"username": "foo", "active": 1, "match_digest": ".*", "destination_hostgroup": 5, "apply": 1, "comment": comment},
+		}
+		diags := resourceDatabase().Validate(terraform.NewResourceConfigRaw(config))
+		if !diags.HasError() {
+			t.Errorf("expected the comment %s to be rejected", comment)
+		}
+	}
+}
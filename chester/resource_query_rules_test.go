@@ -0,0 +1,268 @@
+package chester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	ctyjson "github.com/hashicorp/go-cty/cty/json"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// TestResourceDatabase_QueryRuleColumns checks that the query rule columns
+// beyond the ones chester started with make it to chester-api and back
+// into state, and that NULL columns don't cause a diff.
+func TestResourceDatabase_QueryRuleColumns(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["query_rules"] = []interface{}{
+		map[string]interface{}{
+			"username":              "foo",
+			"active":                1,
+			"match_digest":          "^SELECT",
+			"destination_hostgroup": 10,
+			"apply":                 0,
+			"match_pattern":         "FROM reports_v(\\d)",
+			"replace_pattern":       "FROM reports_\\1",
+			"schemaname":            "reports",
+			"flag_out":              1,
+			"cache_ttl":             5000,
+			"multiplex":             0,
+		},
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": ".*", "destination_hostgroup": 5, "apply": 1, "flag_in": 1, "error_msg": "read only"},
+	}
+	state, diags := f.apply(t, nil, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected create failure %v", diags)
+	}
+	for k, want := range map[string]string{
+		"query_rules.0.match_pattern":   "FROM reports_v(\\d)",
+		"query_rules.0.replace_pattern": "FROM reports_\\1",
+		"query_rules.0.schemaname":      "reports",
+		"query_rules.0.flag_out":        "1",
+		"query_rules.0.cache_ttl":       "5000",
+		"query_rules.0.multiplex":       "0",
+		"query_rules.0.timeout":         "-1",
+		"query_rules.1.flag_in":         "1",
+		"query_rules.1.flag_out":        "-1",
+		"query_rules.1.error_msg":       "read only",
+	} {
+		if got := state.Attributes[k]; got != want {
+			t.Errorf("%s: expected %q, got %q", k, want, got)
+		}
+	}
+	ruleID := state.Attributes["query_rules.0.rule_id"]
+	columns := f.srv.QueryRuleColumns("test-db", 1)
+	if ruleID != "1" || string(columns["flagOUT"]) != "1" || string(columns["multiplex"]) != "0" || columns["timeout"] != nil {
+		t.Fatalf("unexpected columns on chester-api for rule %s: %v", ruleID, columns)
+	}
+
+	diff, err := resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != nil && len(diff.Attributes) > 0 {
+		t.Fatalf("expected no diff, got %+v", diff.Attributes)
+	}
+
+	rules := config["query_rules"].([]interface{})
+	rules[0].(map[string]interface{})["cache_ttl"] = -1
+	state, diags = f.apply(t, state, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected update failure %v", diags)
+	}
+	if got := state.Attributes["query_rules.0.cache_ttl"]; got != "-1" {
+		t.Fatalf("expected cache_ttl to be NULL again, got %s", got)
+	}
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := db.QueryRules[0]; rule.CacheTTL != nil || rule.FlagOUT == nil || *rule.FlagOUT != 1 {
+		t.Fatalf("unexpected rule after the update %+v", rule)
+	}
+}
+
+func TestCustomizeDiffQueryRules(t *testing.T) {
+	r := resourceDatabase()
+	config := resourceConfig("foo")
+	config["query_rule_id_base"] = 100
+	config["query_rules"] = []interface{}{
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^SELECT", "destination_hostgroup": 10, "apply": 1},
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": ".*", "destination_hostgroup": 5, "apply": 1, "rule_id": 7},
+	}
+	diff, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{
+		"query_rules.0.rule_id":               "100",
+		"query_rules.1.rule_id":               "101",
+		"query_rules.0.destination_hostgroup": "10",
+		"query_rules.1.destination_hostgroup": "5",
+	} {
+		if got := diff.Attributes[k]; got == nil || got.New != want {
+			t.Errorf("%s: expected %s, got %+v", k, want, got)
+		}
+	}
+
+	delete(config, "query_rule_id_base")
+	if _, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil); err != nil {
+		t.Fatalf("expected rule ids left to chester-api to plan, got %v", err)
+	}
+	config["query_rules"].([]interface{})[0].(map[string]interface{})["rule_id"] = 7
+	if _, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil); err == nil || !strings.Contains(err.Error(), "has to be greater than the rule_id 7") {
+		t.Fatalf("expected out of order rule ids to fail the plan, got %v", err)
+	}
+
+	config["query_rules"].([]interface{})[0].(map[string]interface{})["rule_id"] = 3
+	config["query_rules"].([]interface{})[1].(map[string]interface{})["destination_hostgroup"] = 20
+	if _, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), nil); err == nil || !strings.Contains(err.Error(), "destination_hostgroup 20 has to be the read_hostgroup 10, the write_hostgroup 5 or the hostgroup_id of a hostgroup block") {
+		t.Fatalf("expected an undeclared destination to fail the plan, got %v", err)
+	}
+
+	// destination_hostgroup left out and an explicit 0 only differ in the
+	// raw config, which terraform hands over with the state
+	config["query_rules"].([]interface{})[1].(map[string]interface{})["destination_hostgroup"] = 0
+	state := &terraform.InstanceState{RawConfig: rawConfig(t, config)}
+	if _, err := r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), nil); err == nil || !strings.Contains(err.Error(), "query_rules.1: destination_hostgroup 0 has to be") {
+		t.Fatalf("expected an explicit destination_hostgroup 0 to fail the plan, got %v", err)
+	}
+	delete(config["query_rules"].([]interface{})[1].(map[string]interface{}), "destination_hostgroup")
+	state = &terraform.InstanceState{RawConfig: rawConfig(t, config)}
+	if _, err := r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), nil); err != nil {
+		t.Fatalf("expected a destination_hostgroup left to chester-api to plan, got %v", err)
+	}
+}
+
+// rawConfig converts config to the cty value terraform sends as the raw
+// config, attributes config leaves out are null.
+func rawConfig(t *testing.T, config map[string]interface{}) cty.Value {
+	t.Helper()
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ctyjson.Unmarshal(b, resourceDatabase().CoreConfigSchema().ImpliedType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// TestResourceDatabase_QueryRuleIDBase checks that rule ids derived from
+// the list position are what chester-api ends up with, and that inserting
+// a rule renumbers the ones after it.
+func TestResourceDatabase_QueryRuleIDBase(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	config["query_rule_id_base"] = 100
+	config["query_rules"] = []interface{}{
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^SELECT", "destination_hostgroup": 10, "apply": 1},
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": ".*", "destination_hostgroup": 5, "apply": 1},
+	}
+	state, diags := f.apply(t, nil, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected create failure %v", diags)
+	}
+	config["query_rules"] = append([]interface{}{
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^SELECT .* FOR UPDATE", "destination_hostgroup": 5, "apply": 1},
+	}, config["query_rules"].([]interface{})...)
+	state, diags = f.apply(t, state, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected update failure %v", diags)
+	}
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		ruleID      int
+		matchDigest string
+		hostgroup   int
+	}{{100, "^SELECT .* FOR UPDATE", 5}, {101, "^SELECT", 10}, {102, ".*", 5}}
+	if len(db.QueryRules) != len(want) {
+		t.Fatalf("expected %d rules, got %+v", len(want), db.QueryRules)
+	}
+	for i, w := range want {
+		rule := db.QueryRules[i]
+		if rule.RuleID != w.ruleID || rule.MatchDigest != w.matchDigest || rule.DestinationHostgroup != w.hostgroup {
+			t.Errorf("rule %d: expected %+v, got %+v", i, w, rule.ProxySqlMySqlQueryRule)
+		}
+		if got := state.Attributes[fmt.Sprintf("query_rules.%d.rule_id", i)]; got != strconv.Itoa(w.ruleID) {
+			t.Errorf("rule %d: expected rule_id %d in state, got %s", i, w.ruleID, got)
+		}
+	}
+}
+
+// TestResourceDatabase_QueryRulePreset checks that a preset plans its
+// rules with the custom ones around them, and that they round-trip
+// through chester-api without a diff.
+func TestResourceDatabase_QueryRulePreset(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	delete(config, "query_rules")
+	config["query_rule_preset"] = "read_write_split"
+	config["append_query_rules"] = []interface{}{
+		map[string]interface{}{"username": "reporting", "active": 1, "match_digest": ".*", "destination_hostgroup": 10, "apply": 1},
+	}
+	r := resourceDatabase()
+	diff, err := r.Diff(context.Background(), nil, terraform.NewResourceConfigRaw(config), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{
+		"query_rules.#":                       "4",
+		"query_rules.0.match_digest":          "^SELECT .* FOR UPDATE",
+		"query_rules.0.destination_hostgroup": "5",
+		"query_rules.1.match_digest":          "^SELECT",
+		"query_rules.1.destination_hostgroup": "10",
+		"query_rules.2.match_digest":          ".*",
+		"query_rules.3.username":              "reporting",
+	} {
+		if got := diff.Attributes[k]; got == nil || got.New != want {
+			t.Errorf("%s: expected %s, got %+v", k, want, got)
+		}
+	}
+
+	state, diags := f.apply(t, nil, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected create failure %v", diags)
+	}
+	diff, err = r.Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != nil && len(diff.Attributes) > 0 {
+		t.Fatalf("expected no diff, got %+v", diff.Attributes)
+	}
+
+	config["prepend_query_rules"] = []interface{}{
+		map[string]interface{}{"username": "foo", "active": 1, "match_digest": "^SELECT .* FROM reports", "destination_hostgroup": 10, "apply": 1},
+	}
+	config["query_rule_preset"] = "writer_only"
+	state, diags = f.apply(t, state, config)
+	if diags.HasError() {
+		t.Fatalf("unexpected update failure %v", diags)
+	}
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"^SELECT .* FROM reports", ".*", ".*"}
+	if len(db.QueryRules) != len(want) {
+		t.Fatalf("expected %d rules, got %+v", len(want), db.QueryRules)
+	}
+	for i, w := range want {
+		if db.QueryRules[i].MatchDigest != w {
+			t.Errorf("rule %d: expected match_digest %s, got %+v", i, w, db.QueryRules[i].ProxySqlMySqlQueryRule)
+		}
+	}
+	if got := state.Attributes["query_rules.#"]; got != "3" {
+		t.Fatalf("expected 3 rules in state, got %s", got)
+	}
+}
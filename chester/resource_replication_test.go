@@ -0,0 +1,54 @@
+package chester
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// TestResourceDatabase_ReplicationHostgroup checks that a failover
+// proxysql's monitor carries out isn't drift with replication_hostgroup
+// set, and is without it.
+func TestResourceDatabase_ReplicationHostgroup(t *testing.T) {
+	for _, replicated := range []bool{true, false} {
+		t.Run(strconv.FormatBool(replicated), func(t *testing.T) {
+			f := newResourceFixture(t)
+			config := resourceConfig("foo")
+			if replicated {
+				config["replication_hostgroup"] = []interface{}{
+					map[string]interface{}{"check_type": "super_read_only"},
+				}
+			}
+			state := f.mustApply(t, nil, config)
+			db, _, err := f.client.GetInstanceGroup("test-db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replicated && (len(db.ReplicationHostgroups) != 1 || db.ReplicationHostgroups[0].WriterHostgroup != 5 ||
+				db.ReplicationHostgroups[0].ReaderHostgroup != 10 || db.ReplicationHostgroups[0].CheckType != "super_read_only") {
+				t.Fatalf("expected write_hostgroup paired with read_hostgroup, got %+v", db.ReplicationHostgroups)
+			}
+
+			if !f.srv.Failover("test-db", "test-read-1") {
+				t.Fatal("failover failed")
+			}
+			state, diags := resourceDatabase().RefreshWithoutUpgrade(context.Background(), state, f.client)
+			if diags.HasError() {
+				t.Fatalf("unexpected refresh failure %v", diags)
+			}
+			diff, err := resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), f.client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replicated && diff != nil && len(diff.Attributes) > 0 {
+				t.Fatalf("expected no diff after a failover, got %+v", diff.Attributes)
+			}
+			drift := diff != nil && diff.Attributes["master_instance.ip_address"] != nil
+			if drift == replicated {
+				t.Fatalf("replication_hostgroup set %t, got a master_instance diff %t: %+v", replicated, drift, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package chester
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/api/apitest"
+)
+
+// ifMatchRecorder records the If-Match header of every PATCH it passes on.
+type ifMatchRecorder struct {
+	mu      sync.Mutex
+	ifMatch []string
+}
+
+func (r *ifMatchRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPatch {
+		r.mu.Lock()
+		r.ifMatch = append(r.ifMatch, req.Header.Get("If-Match"))
+		r.mu.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestResourceDatabaseUpdate_IfMatch(t *testing.T) {
+	srv := apitest.NewServer()
+	t.Cleanup(srv.Close)
+	recorder := &ifMatchRecorder{}
+	client, err := chester.NewClientWithOptions(
+		chester.WithHost(srv.URL),
+		chester.WithUsername(srv.Username),
+		chester.WithPassword(srv.Password),
+		chester.WithHTTPClient(&http.Client{Transport: recorder}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &resourceFixture{srv: srv, client: client}
+	state := f.create(t)
+	revision := state.Attributes["revision"]
+	state, diags := f.apply(t, state, resourceConfig("baz"))
+	if diags.HasError() {
+		t.Fatalf("unexpected update failure %v", diags)
+	}
+	if len(recorder.ifMatch) != 1 || recorder.ifMatch[0] != revision {
+		t.Fatalf("expected the modify to be sent with If-Match %s, got %q", revision, recorder.ifMatch)
+	}
+
+	srv.UpdateDatabase("test-db", func(db *models.InstanceData) { db.Password = "changed" })
+	_, diags = f.apply(t, state, resourceConfig("qux"))
+	want := preconditionFailedDiag("test-db", chester.ErrPreconditionFailed).Summary
+	if !diags.HasError() || diags[0].Summary != want {
+		t.Fatalf("expected %q, got %v", want, diags)
+	}
+}
@@ -0,0 +1,53 @@
+package chester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// TestResourceDatabase_MasterSwitchover checks that swapping
+// master_instance with a read replica is planned in place and carried out
+// as a switchover, while any other change to it replaces the instance
+// group.
+func TestResourceDatabase_MasterSwitchover(t *testing.T) {
+	f := newResourceFixture(t)
+	config := resourceConfig("foo")
+	state := f.mustApply(t, nil, config)
+
+	moved := resourceConfig("foo")
+	moved["master_instance"] = map[string]interface{}{"name": "test-db-2", "ip_address": "10.0.0.3"}
+	diff, err := resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(moved), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.RequiresNew() {
+		t.Fatal("expected a new master that isn't a read replica to replace the instance group")
+	}
+
+	swapped := resourceConfig("foo")
+	swapped["master_instance"] = map[string]interface{}{"name": "test-read-1", "ip_address": "10.0.0.11"}
+	swapped["read_replicas"] = []interface{}{
+		map[string]interface{}{"name": "test-db", "ip_address": "10.0.0.2"},
+	}
+	diff, err = resourceDatabase().Diff(context.Background(), state, terraform.NewResourceConfigRaw(swapped), f.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.RequiresNew() {
+		t.Fatalf("expected the swap to be planned in place, got %+v", diff.Attributes)
+	}
+	state = f.mustApply(t, state, swapped)
+	db, _, err := f.client.GetInstanceGroup("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.MasterInstance.Name != "test-read-1" || len(db.ReadReplicas) != 1 || db.ReadReplicas[0].Name != "test-db" {
+		t.Fatalf("expected test-read-1 to be the master, got %+v", db.InstanceData)
+	}
+	if got := state.Attributes["master_instance.name"]; got != "test-read-1" {
+		t.Fatalf("expected test-read-1 in state, got %s", got)
+	}
+	f.expectNoDiff(t, state, swapped)
+}
@@ -0,0 +1,85 @@
+package chester
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+)
+
+// testAccNamePrefix is the prefix every instance group the acceptance tests
+// create must start with, the sweeper only removes instance groups that
+// match it. Override it with CHESTER_SWEEP_PREFIX.
+const testAccNamePrefix = "tf-acc-"
+
+// TestMain runs the sweepers when the tests are called with -sweep,
+// i.e. go test ./chester -v -sweep=all
+func TestMain(m *testing.M) {
+	resource.TestMain(m)
+}
+
+func init() {
+	resource.AddTestSweepers("chester_database", &resource.Sweeper{
+		Name: "chester_database",
+		F:    sweepDatabases,
+	})
+}
+
+// sweepPrefix returns the prefix of the instance groups to sweep.
+func sweepPrefix() string {
+	if prefix := os.Getenv("CHESTER_SWEEP_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return testAccNamePrefix
+}
+
+// sweeperClient creates a client from the same env vars as the provider.
+func sweeperClient() (*api.Client, error) {
+	host := os.Getenv("CHESTER_HOST")
+	username := os.Getenv("CHESTER_USERNAME")
+	password := os.Getenv("CHESTER_PASSWORD")
+	if disableIAP, _ := strconv.ParseBool(os.Getenv("CHESTER_DISABLE_IAP")); disableIAP {
+		return api.NewClientWithOptions(api.WithHost(host), api.WithUsername(username), api.WithPassword(password))
+	}
+	return api.NewClient(host, username, password, os.Getenv("CHESTER_CLIENT_ID"))
+}
+
+// sweepDatabases removes every instance group left behind by a failed
+// acceptance test. chester has no regions, so the region is ignored.
+func sweepDatabases(region string) error {
+	prefix := sweepPrefix()
+	c, err := sweeperClient()
+	if err != nil {
+		return fmt.Errorf("failed to create chester client: %s", err.Error())
+	}
+	dbs, err := c.GetDatabases()
+	if err != nil {
+		return fmt.Errorf("failed to list instance groups: %s", err.Error())
+	}
+	failed := []string{}
+	for _, db := range dbs {
+		if !strings.HasPrefix(db.InstanceName, prefix) {
+			continue
+		}
+		log.Printf("[INFO] Sweeping instance group %s", db.InstanceName)
+		err := c.RemoveDatabase(models.RemoveDatabaseRequest{
+			Action:       "remove",
+			InstanceName: db.InstanceName,
+			Username:     db.Username,
+		})
+		if err != nil {
+			log.Printf("[ERROR] Failed to sweep instance group %s: %s", db.InstanceName, err.Error())
+			failed = append(failed, db.InstanceName)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to sweep instance groups %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -1,7 +1,17 @@
 package chester
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
 	models "github.com/eahrend/chestermodels"
+	chester "github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/proxysql"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
@@ -13,7 +23,47 @@ func supressQueryRules(k, old, new string, d *schema.ResourceData) bool {
 	}
 }
 
-func flattenQueryRules(queryRules []models.ProxySqlMySqlQueryRule) []interface{} {
+// expandQueryRules converts the query_rules list into query rules, rules
+// that haven't been created yet have a rule id of 0.
+func expandQueryRules(queryRules []interface{}) []chester.QueryRule {
+	qrs := make([]chester.QueryRule, 0, len(queryRules))
+	for _, queryRule := range queryRules {
+		qr, ok := queryRule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		qrs = append(qrs, chester.QueryRule{
+			ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{
+				RuleID:               qr["rule_id"].(int),
+				Username:             qr["username"].(string),
+				Active:               qr["active"].(int),
+				MatchDigest:          qr["match_digest"].(string),
+				DestinationHostgroup: qr["destination_hostgroup"].(int),
+				Apply:                qr["apply"].(int),
+				Comment:              qr["comment"].(string),
+			},
+			MatchPattern:       qr["match_pattern"].(string),
+			NegateMatchPattern: qr["negate_match_pattern"].(int),
+			SchemaName:         qr["schemaname"].(string),
+			ClientAddr:         qr["client_addr"].(string),
+			FlagIN:             qr["flag_in"].(int),
+			FlagOUT:            expandNullableInt(qr["flag_out"]),
+			ReplacePattern:     qr["replace_pattern"].(string),
+			CacheTTL:           expandNullableInt(qr["cache_ttl"]),
+			Timeout:            expandNullableInt(qr["timeout"]),
+			Retries:            expandNullableInt(qr["retries"]),
+			Delay:              expandNullableInt(qr["delay"]),
+			MirrorHostgroup:    expandNullableInt(qr["mirror_hostgroup"]),
+			Multiplex:          expandNullableInt(qr["multiplex"]),
+			ErrorMsg:           qr["error_msg"].(string),
+			Log:                expandNullableInt(qr["log"]),
+			MirrorFlagOUT:      expandNullableInt(qr["mirror_flag_out"]),
+		})
+	}
+	return qrs
+}
+
+func flattenQueryRules(queryRules []chester.QueryRule) []interface{} {
 	if queryRules != nil {
 		qrs := make([]interface{}, len(queryRules), len(queryRules))
 		for i, queryRule := range queryRules {
@@ -25,9 +75,680 @@ func flattenQueryRules(queryRules []models.ProxySqlMySqlQueryRule) []interface{}
 			qr["destination_hostgroup"] = queryRule.DestinationHostgroup
 			qr["apply"] = queryRule.Apply
 			qr["comment"] = queryRule.Comment
+			qr["match_pattern"] = queryRule.MatchPattern
+			qr["negate_match_pattern"] = queryRule.NegateMatchPattern
+			qr["schemaname"] = queryRule.SchemaName
+			qr["client_addr"] = queryRule.ClientAddr
+			qr["flag_in"] = queryRule.FlagIN
+			qr["flag_out"] = flattenNullableInt(queryRule.FlagOUT)
+			qr["replace_pattern"] = queryRule.ReplacePattern
+			qr["cache_ttl"] = flattenNullableInt(queryRule.CacheTTL)
+			qr["timeout"] = flattenNullableInt(queryRule.Timeout)
+			qr["retries"] = flattenNullableInt(queryRule.Retries)
+			qr["delay"] = flattenNullableInt(queryRule.Delay)
+			qr["mirror_hostgroup"] = flattenNullableInt(queryRule.MirrorHostgroup)
+			qr["multiplex"] = flattenNullableInt(queryRule.Multiplex)
+			qr["error_msg"] = queryRule.ErrorMsg
+			qr["log"] = flattenNullableInt(queryRule.Log)
+			qr["mirror_flag_out"] = flattenNullableInt(queryRule.MirrorFlagOUT)
 			qrs[i] = qr
 		}
 		return qrs
 	}
 	return make([]interface{}, 0)
 }
+
+// expandNullableInt reads a nullable query rule column, -1 is NULL. State
+// written before the column existed has no value, that's NULL too.
+func expandNullableInt(v interface{}) *int {
+	n, ok := v.(int)
+	if !ok || n < 0 {
+		return nil
+	}
+	return &n
+}
+
+// flattenNullableInt writes a nullable query rule column, NULL is -1.
+func flattenNullableInt(n *int) int {
+	if n == nil {
+		return -1
+	}
+	return *n
+}
+
+// customizeDiffRevision marks the revision as unknown whenever an existing
+// instance group is about to change, since chester-api hands back a new one.
+func customizeDiffRevision(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+	if len(d.GetChangedKeysPrefix("")) > 0 {
+		return d.SetNewComputed("revision")
+	}
+	return nil
+}
+
+const (
+	// queryCacheComment is the comment of the rules query_cache plans, so
+	// they can be told apart from the rest of query_rules
+	queryCacheComment = "query_cache"
+	// mirrorComment is the comment of the rules mirror plans
+	mirrorComment = "mirror"
+	// queryCacheSizeVariable is the mysql variable query_cache sizes the
+	// cache with
+	queryCacheSizeVariable = "query_cache_size_MB"
+)
+
+// customizeDiffPlannedQueryRules plans query_rules when they're made up
+// from other attributes, so the plan shows every rule proxysql gets:
+// query_rule_preset expands to its rules, with prepend_query_rules before
+// and append_query_rules after them, and the rules of query_cache go in
+// front of everything but the rules of mirror. Without query_rules or a
+// preset, those go in front of read_write_split, the preset matching the
+// rules chester-api generates. Rules without a rule_id keep the one at
+// their position in state, the way rule ids left out of query_rules do.
+func customizeDiffPlannedQueryRules(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	name := d.Get("query_rule_preset").(string)
+	queryCache := d.Get("query_cache").([]interface{})
+	mirror := d.Get("mirror").([]interface{})
+	if name == "" && len(queryCache) == 0 && len(mirror) == 0 {
+		return planRemovedQueryRules(d)
+	}
+	for _, k := range []string{"query_rule_preset", "prepend_query_rules", "append_query_rules", "query_cache", "mirror", "username", "read_hostgroup", "write_hostgroup"} {
+		if !d.NewValueKnown(k) {
+			return d.SetNewComputed("query_rules")
+		}
+	}
+	username, readHostgroup, writeHostgroup := d.Get("username").(string), d.Get("read_hostgroup").(int), d.Get("write_hostgroup").(int)
+	old, _ := d.GetChange("query_rules")
+	oldRules := old.([]interface{})
+	planned := []interface{}{}
+	if len(mirror) > 0 {
+		planned = append(planned, flattenQueryRules(expandMirrorRules(mirror[0].(map[string]interface{}), username, readHostgroup))...)
+	}
+	if len(queryCache) > 0 {
+		planned = append(planned, flattenQueryRules(expandQueryCacheRules(queryCache[0].(map[string]interface{}), username, readHostgroup))...)
+	}
+	switch {
+	case name != "":
+		preset, err := proxysql.Preset(name, username, readHostgroup, writeHostgroup)
+		if err != nil {
+			return err
+		}
+		planned = append(planned, d.Get("prepend_query_rules").([]interface{})...)
+		planned = append(planned, flattenQueryRules(preset)...)
+		planned = append(planned, d.Get("append_query_rules").([]interface{})...)
+	default:
+		queryRules := []interface{}{}
+		if d.NewValueKnown("query_rules") {
+			for i, queryRule := range d.Get("query_rules").([]interface{}) {
+				qr := copyQueryRule(queryRule)
+				if qr["comment"] == queryCacheComment || qr["comment"] == mirrorComment {
+					continue
+				}
+				// a rule_id taken from the state at the same position isn't
+				// the rule's own, the planned rules in front shift the positions
+				if i < len(oldRules) && qr["rule_id"] == copyQueryRule(oldRules[i])["rule_id"] {
+					qr["rule_id"] = 0
+				}
+				queryRules = append(queryRules, qr)
+			}
+		}
+		// the rules are sent along with the add or modify, so proxysql
+		// gets these rather than whatever chester-api would generate. An
+		// instance group still running chester-api's own defaults shows
+		// the difference in the plan if they ever stop matching
+		if len(queryRules) == 0 {
+			preset, err := proxysql.Preset("read_write_split", username, readHostgroup, writeHostgroup)
+			if err != nil {
+				return err
+			}
+			queryRules = flattenQueryRules(preset)
+		}
+		planned = append(planned, queryRules...)
+	}
+	for i, queryRule := range planned {
+		qr := copyQueryRule(queryRule)
+		if qr["rule_id"].(int) == 0 && i < len(oldRules) {
+			qr["rule_id"] = copyQueryRule(oldRules[i])["rule_id"]
+		}
+		planned[i] = qr
+	}
+	return d.SetNew("query_rules", planned)
+}
+
+// planRemovedQueryRules takes the rules of a removed query_cache or
+// mirror out of query_rules. query_rules is computed, so without it in the
+// config the rules in state would stay planned, and a mirror rule would
+// keep copying statements to a hostgroup that's gone.
+func planRemovedQueryRules(d *schema.ResourceDiff) error {
+	if !(d.HasChange("query_cache") || d.HasChange("mirror")) || !d.NewValueKnown("query_rules") {
+		return nil
+	}
+	queryRules := d.Get("query_rules").([]interface{})
+	planned := make([]interface{}, 0, len(queryRules))
+	for _, queryRule := range queryRules {
+		if comment := copyQueryRule(queryRule)["comment"]; comment == queryCacheComment || comment == mirrorComment {
+			continue
+		}
+		planned = append(planned, queryRule)
+	}
+	if len(planned) == len(queryRules) {
+		return nil
+	}
+	return d.SetNew("query_rules", planned)
+}
+
+// expandQueryCacheRules converts the rules of a query_cache block into
+// query rules that cache what they match for cache_ttl milliseconds.
+// They're sent to destination_hostgroup, the read hostgroup if it's -1.
+func expandQueryCacheRules(queryCache map[string]interface{}, username string, readHostgroup int) []chester.QueryRule {
+	rules := queryCache["rule"].([]interface{})
+	qrs := make([]chester.QueryRule, 0, len(rules))
+	for _, rule := range rules {
+		r, ok := rule.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		qr := chester.QueryRule{
+			ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{
+				Username:             r["username"].(string),
+				Active:               1,
+				MatchDigest:          r["match_digest"].(string),
+				DestinationHostgroup: r["destination_hostgroup"].(int),
+				Apply:                1,
+				Comment:              queryCacheComment,
+			},
+			CacheTTL: expandNullableInt(r["cache_ttl"]),
+		}
+		if qr.Username == "" {
+			qr.Username = username
+		}
+		if qr.DestinationHostgroup == -1 {
+			qr.DestinationHostgroup = readHostgroup
+		}
+		qrs = append(qrs, qr)
+	}
+	return qrs
+}
+
+// expandMirrorRules converts a mirror block into query rules that send a
+// copy of the statements matching its match_digests to its hostgroup. The
+// rules don't apply, so the statements themselves carry on to the rules
+// after them, and go to the read hostgroup when none of those match.
+func expandMirrorRules(mirror map[string]interface{}, username string, readHostgroup int) []chester.QueryRule {
+	if u := mirror["username"].(string); u != "" {
+		username = u
+	}
+	digests := mirror["match_digests"].([]interface{})
+	qrs := make([]chester.QueryRule, 0, len(digests))
+	for _, digest := range digests {
+		matchDigest, _ := digest.(string)
+		qrs = append(qrs, chester.QueryRule{
+			ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{
+				Username:             username,
+				Active:               1,
+				MatchDigest:          matchDigest,
+				DestinationHostgroup: readHostgroup,
+				Apply:                0,
+				Comment:              mirrorComment,
+			},
+			MirrorHostgroup: expandNullableInt(mirror["hostgroup_id"]),
+			MirrorFlagOUT:   expandNullableInt(mirror["flag_out"]),
+		})
+	}
+	return qrs
+}
+
+// expandHostgroups converts the hostgroup blocks and the mirror block into
+// the hostgroups sent along with the read and write hostgroups.
+func expandHostgroups(hostgroups []interface{}, mirror []interface{}) []chester.Hostgroup {
+	hgs := []chester.Hostgroup{}
+	for _, hostgroup := range hostgroups {
+		hg, ok := hostgroup.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		hgs = append(hgs, chester.Hostgroup{
+			HostgroupID:    hg["hostgroup_id"].(int),
+			Servers:        expandServers(hg["servers"].([]interface{})),
+			MaxConnections: hg["max_connections"].(int),
+			Comment:        hg["comment"].(string),
+		})
+	}
+	if len(mirror) > 0 {
+		m := mirror[0].(map[string]interface{})
+		hgs = append(hgs, chester.Hostgroup{
+			HostgroupID: m["hostgroup_id"].(int),
+			Servers:     expandServers(m["servers"].([]interface{})),
+			Comment:     mirrorComment,
+		})
+	}
+	return hgs
+}
+
+// expandServers converts a list of name and ip_address blocks.
+func expandServers(servers []interface{}) []models.AddDatabaseRequestDatabaseInformation {
+	infos := []models.AddDatabaseRequestDatabaseInformation{}
+	for _, server := range servers {
+		s, ok := server.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		infos = append(infos, models.AddDatabaseRequestDatabaseInformation{
+			Name:      s["name"].(string),
+			IPAddress: s["ip_address"].(string),
+		})
+	}
+	return infos
+}
+
+// flattenServers converts servers into a list of name and ip_address
+// blocks.
+func flattenServers(servers []models.AddDatabaseRequestDatabaseInformation) []interface{} {
+	flattened := make([]interface{}, len(servers))
+	for i, server := range servers {
+		flattened[i] = map[string]interface{}{
+			"name":       server.Name,
+			"ip_address": server.IPAddress,
+		}
+	}
+	return flattened
+}
+
+// flattenHostgroups converts the hostgroups of an instance group into
+// hostgroup blocks, leaving out the one of the mirror block.
+func flattenHostgroups(hostgroups []chester.Hostgroup, mirrorHostgroup int) []interface{} {
+	flattened := []interface{}{}
+	for _, hostgroup := range hostgroups {
+		if hostgroup.HostgroupID == mirrorHostgroup {
+			continue
+		}
+		maxConnections := hostgroup.MaxConnections
+		if maxConnections == 0 {
+			maxConnections = 100
+		}
+		flattened = append(flattened, map[string]interface{}{
+			"hostgroup_id":    hostgroup.HostgroupID,
+			"servers":         flattenServers(hostgroup.Servers),
+			"max_connections": maxConnections,
+			"comment":         hostgroup.Comment,
+		})
+	}
+	return flattened
+}
+
+// flattenMirror refreshes the servers of a mirror block from the
+// hostgroups of the instance group. A hostgroup that's gone leaves no
+// servers, so the next apply sends them again.
+func flattenMirror(mirror map[string]interface{}, hostgroups []chester.Hostgroup) []interface{} {
+	m := map[string]interface{}{}
+	for k, v := range mirror {
+		m[k] = v
+	}
+	m["servers"] = []interface{}{}
+	for _, hostgroup := range hostgroups {
+		if hostgroup.HostgroupID == m["hostgroup_id"].(int) {
+			m["servers"] = flattenServers(hostgroup.Servers)
+		}
+	}
+	return []interface{}{m}
+}
+
+// expandReplicationHostgroups converts the replication_hostgroup block
+// into the pair of the write and read hostgroups.
+func expandReplicationHostgroups(replication []interface{}, writeHostgroup, readHostgroup int) []chester.ReplicationHostgroup {
+	pairs := []chester.ReplicationHostgroup{}
+	if len(replication) == 0 {
+		return pairs
+	}
+	r, _ := replication[0].(map[string]interface{})
+	checkType, _ := r["check_type"].(string)
+	comment, _ := r["comment"].(string)
+	return append(pairs, chester.ReplicationHostgroup{
+		WriterHostgroup: writeHostgroup,
+		ReaderHostgroup: readHostgroup,
+		CheckType:       checkType,
+		Comment:         comment,
+	})
+}
+
+// flattenReplicationHostgroup converts the replication hostgroup pairing
+// the write and read hostgroups into a replication_hostgroup block, pairs
+// of other hostgroups aren't managed by the resource.
+func flattenReplicationHostgroup(pairs []chester.ReplicationHostgroup, writeHostgroup, readHostgroup int) []interface{} {
+	for _, pair := range pairs {
+		if pair.WriterHostgroup != writeHostgroup || pair.ReaderHostgroup != readHostgroup {
+			continue
+		}
+		checkType := pair.CheckType
+		if checkType == "" {
+			checkType = "read_only"
+		}
+		return []interface{}{map[string]interface{}{
+			"check_type": checkType,
+			"comment":    pair.Comment,
+		}}
+	}
+	return []interface{}{}
+}
+
+// keepServerRoles returns the master_instance and read_replicas in state
+// when master and replicas are the same servers in other roles. That's
+// proxysql's monitor moving them between the hostgroups of a replication
+// hostgroup, not a change to the instance group.
+func keepServerRoles(d *schema.ResourceData, master models.AddDatabaseRequestDatabaseInformation, replicas []models.AddDatabaseRequestDatabaseInformation) (models.AddDatabaseRequestDatabaseInformation, []models.AddDatabaseRequestDatabaseInformation) {
+	stateMaster := expandServer(d.Get("master_instance"))
+	stateReplicas := expandServers(d.Get("read_replicas").([]interface{}))
+	if !sameServers(master, replicas, stateMaster, stateReplicas) {
+		return master, replicas
+	}
+	return stateMaster, stateReplicas
+}
+
+// expandServer converts a master_instance map.
+func expandServer(server interface{}) models.AddDatabaseRequestDatabaseInformation {
+	s, _ := server.(map[string]interface{})
+	info := models.AddDatabaseRequestDatabaseInformation{}
+	info.Name, _ = s["name"].(string)
+	info.IPAddress, _ = s["ip_address"].(string)
+	return info
+}
+
+// sameServers reports whether two masters and their replicas are the same
+// servers, whatever their roles.
+func sameServers(master models.AddDatabaseRequestDatabaseInformation, replicas []models.AddDatabaseRequestDatabaseInformation,
+	otherMaster models.AddDatabaseRequestDatabaseInformation, otherReplicas []models.AddDatabaseRequestDatabaseInformation) bool {
+	servers := map[models.AddDatabaseRequestDatabaseInformation]int{master: 1}
+	for _, replica := range replicas {
+		servers[replica]++
+	}
+	servers[otherMaster]--
+	for _, replica := range otherReplicas {
+		servers[replica]--
+	}
+	for _, n := range servers {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// customizeDiffMasterSwitchover replaces the instance group when
+// master_instance changes, unless the new master is one of the read
+// replicas and the old master takes its place among them. That's a
+// switchover, which chester-api carries out in place.
+func customizeDiffMasterSwitchover(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" || !d.HasChange("master_instance") {
+		return nil
+	}
+	if !d.NewValueKnown("master_instance") || !d.NewValueKnown("read_replicas") {
+		return d.ForceNew("master_instance")
+	}
+	oldMaster, newMaster := d.GetChange("master_instance")
+	oldReplicas, newReplicas := d.GetChange("read_replicas")
+	oldServers := expandServers(oldReplicas.([]interface{}))
+	promoted := false
+	for _, replica := range oldServers {
+		if replica == expandServer(newMaster) {
+			promoted = true
+		}
+	}
+	if promoted && sameServers(expandServer(oldMaster), oldServers, expandServer(newMaster), expandServers(newReplicas.([]interface{}))) {
+		return nil
+	}
+	return d.ForceNew("master_instance")
+}
+
+// copyQueryRule copies an element of a query rule list, so it can be
+// changed without changing the list it came from.
+func copyQueryRule(queryRule interface{}) map[string]interface{} {
+	qr := map[string]interface{}{}
+	if m, ok := queryRule.(map[string]interface{}); ok {
+		for k, v := range m {
+			qr[k] = v
+		}
+	}
+	return qr
+}
+
+// setMysqlVariables replaces the mysql_variables of the instance group
+// with mysql_variables, and query_cache_size_MB when there's a
+// query_cache.
+func setMysqlVariables(c *chester.Client, d *schema.ResourceData, opts ...chester.RequestOption) error {
+	vars := map[string]string{}
+	for name, value := range d.Get("mysql_variables").(map[string]interface{}) {
+		vars[name] = value.(string)
+	}
+	if queryCache := d.Get("query_cache").([]interface{}); len(queryCache) > 0 {
+		vars[queryCacheSizeVariable] = strconv.Itoa(queryCache[0].(map[string]interface{})["size_mb"].(int))
+	}
+	return c.SetMysqlVariables(d.Get("instance_name").(string), vars, opts...)
+}
+
+// expandMonitor returns the monitor credentials of d, nil without a
+// monitor_username.
+func expandMonitor(d *schema.ResourceData) *chester.MonitorCredentials {
+	username := d.Get("monitor_username").(string)
+	if username == "" {
+		return nil
+	}
+	return &chester.MonitorCredentials{Username: username, Password: d.Get("monitor_password").(string)}
+}
+
+// managesMysqlVariables reports whether d sets any mysql_variables, its
+// own or query_cache's size.
+func managesMysqlVariables(d *schema.ResourceData) bool {
+	return len(d.Get("mysql_variables").(map[string]interface{})) > 0 || len(d.Get("query_cache").([]interface{})) > 0
+}
+
+// flattenMysqlVariables converts the mysql_variables of an instance group
+// for the mysql_variables attribute, leaving out the one query_cache sets.
+func flattenMysqlVariables(vars map[string]string) map[string]interface{} {
+	m := make(map[string]interface{}, len(vars))
+	for name, value := range vars {
+		if name != queryCacheSizeVariable {
+			m[name] = value
+		}
+	}
+	return m
+}
+
+// validateMysqlVariables checks that mysql_variables only has known mysql
+// variables with values of the right type.
+func validateMysqlVariables(i interface{}, k string) ([]string, []error) {
+	var errs []error
+	for name, value := range i.(map[string]interface{}) {
+		if name == queryCacheSizeVariable {
+			errs = append(errs, fmt.Errorf("%s: %s is set by the size_mb of query_cache", k, name))
+			continue
+		}
+		if err := proxysql.ValidateMysqlVariable(name, value.(string)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, err))
+		}
+	}
+	return nil, errs
+}
+
+// customizeDiffHostgroups checks that the hostgroup blocks and the mirror
+// block each have a hostgroup of their own, apart from the read and write
+// hostgroups.
+func customizeDiffHostgroups(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	for _, k := range []string{"hostgroup", "mirror", "read_hostgroup", "write_hostgroup"} {
+		if !d.NewValueKnown(k) {
+			return nil
+		}
+	}
+	readHostgroup, writeHostgroup := d.Get("read_hostgroup").(int), d.Get("write_hostgroup").(int)
+	used := map[int]string{}
+	check := func(path string, hostgroup int) error {
+		if hostgroup == readHostgroup || hostgroup == writeHostgroup {
+			return fmt.Errorf("%s %d has to differ from the read_hostgroup %d and the write_hostgroup %d", path, hostgroup, readHostgroup, writeHostgroup)
+		}
+		if other, ok := used[hostgroup]; ok {
+			return fmt.Errorf("%s %d is already used by %s", path, hostgroup, other)
+		}
+		used[hostgroup] = path
+		return nil
+	}
+	for i, hostgroup := range d.Get("hostgroup").([]interface{}) {
+		if err := check(fmt.Sprintf("hostgroup.%d: hostgroup_id", i), hostgroup.(map[string]interface{})["hostgroup_id"].(int)); err != nil {
+			return err
+		}
+	}
+	if mirror := d.Get("mirror").([]interface{}); len(mirror) > 0 {
+		return check("mirror: hostgroup_id", mirror[0].(map[string]interface{})["hostgroup_id"].(int))
+	}
+	return nil
+}
+
+// customizeDiffQueryRules plans rule ids and checks destinations, so the
+// plan shows the order proxysql evaluates the rules in and where they send
+// statements. With query_rule_id_base set the rule ids follow the list,
+// otherwise the ones that are set have to ascend with it.
+func customizeDiffQueryRules(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("query_rules") {
+		return nil
+	}
+	queryRules := d.Get("query_rules").([]interface{})
+	if base := d.Get("query_rule_id_base").(int); base > 0 {
+		planned := make([]interface{}, len(queryRules))
+		for i, queryRule := range queryRules {
+			qr := map[string]interface{}{}
+			for k, v := range queryRule.(map[string]interface{}) {
+				qr[k] = v
+			}
+			qr["rule_id"] = base + i
+			planned[i] = qr
+		}
+		if err := d.SetNew("query_rules", planned); err != nil {
+			return err
+		}
+		queryRules = planned
+	}
+	previous := 0
+	for i, queryRule := range queryRules {
+		if ruleID := queryRule.(map[string]interface{})["rule_id"].(int); ruleID != 0 {
+			if ruleID <= previous {
+				return fmt.Errorf("query_rules.%d: rule_id %d has to be greater than the rule_id %d before it, proxysql evaluates rules in rule_id order", i, ruleID, previous)
+			}
+			previous = ruleID
+		}
+	}
+	if !d.NewValueKnown("read_hostgroup") || !d.NewValueKnown("write_hostgroup") || !d.NewValueKnown("hostgroup") {
+		return nil
+	}
+	readHostgroup, writeHostgroup := d.Get("read_hostgroup").(int), d.Get("write_hostgroup").(int)
+	destinations := map[int]bool{readHostgroup: true, writeHostgroup: true}
+	for _, hostgroup := range expandHostgroups(d.Get("hostgroup").([]interface{}), nil) {
+		destinations[hostgroup.HostgroupID] = true
+	}
+	for path, hostgroup := range configuredDestinationHostgroups(d) {
+		if !destinations[hostgroup] {
+			return fmt.Errorf("%s: destination_hostgroup %d has to be the read_hostgroup %d, the write_hostgroup %d or the hostgroup_id of a hostgroup block", path, hostgroup, readHostgroup, writeHostgroup)
+		}
+	}
+	return nil
+}
+
+// configuredDestinationHostgroups returns the destination_hostgroups set
+// in the config, keyed by their path. destination_hostgroup is computed,
+// so a rule leaving it out reads as 0 just like one setting 0, only the
+// raw config tells the two apart. Without a raw config, 0 is taken as
+// left out.
+func configuredDestinationHostgroups(d *schema.ResourceDiff) map[string]int {
+	hostgroups := map[string]int{}
+	for i, queryCacheRule := range d.Get("query_cache.0.rule").([]interface{}) {
+		if hostgroup := queryCacheRule.(map[string]interface{})["destination_hostgroup"].(int); hostgroup != -1 {
+			hostgroups[fmt.Sprintf("query_cache.0.rule.%d", i)] = hostgroup
+		}
+	}
+	raw := d.GetRawConfig()
+	if raw.IsNull() || !raw.IsKnown() {
+		for i, queryRule := range d.Get("query_rules").([]interface{}) {
+			if hostgroup := queryRule.(map[string]interface{})["destination_hostgroup"].(int); hostgroup != 0 {
+				hostgroups[fmt.Sprintf("query_rules.%d", i)] = hostgroup
+			}
+		}
+		return hostgroups
+	}
+	for _, k := range []string{"query_rules", "prepend_query_rules", "append_query_rules"} {
+		queryRules := raw.GetAttr(k)
+		if queryRules.IsNull() || !queryRules.IsKnown() {
+			continue
+		}
+		for i, queryRule := range queryRules.AsValueSlice() {
+			if queryRule.IsNull() || !queryRule.IsKnown() {
+				continue
+			}
+			hostgroup := queryRule.GetAttr("destination_hostgroup")
+			if hostgroup.IsNull() || !hostgroup.IsKnown() {
+				continue
+			}
+			n, _ := hostgroup.AsBigFloat().Int64()
+			hostgroups[fmt.Sprintf("%s.%d", k, i)] = int(n)
+		}
+	}
+	return hostgroups
+}
+
+// customizeDiffLintQueryRules runs the query rule linter over the planned
+// query rules. The findings are listed in query_rule_warnings, so they show
+// up in the plan, or fail it when lint_query_rules is error.
+func customizeDiffLintQueryRules(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	mode := d.Get("lint_query_rules").(string)
+	if mode == "off" {
+		return d.SetNew("query_rule_warnings", []string{})
+	}
+	if !d.NewValueKnown("query_rules") || !d.NewValueKnown("write_hostgroup") || !d.NewValueKnown("read_hostgroup") {
+		return d.SetNewComputed("query_rule_warnings")
+	}
+	hostgroups := []int{d.Get("read_hostgroup").(int)}
+	for _, hostgroup := range expandHostgroups(d.Get("hostgroup").([]interface{}), d.Get("mirror").([]interface{})) {
+		hostgroups = append(hostgroups, hostgroup.HostgroupID)
+	}
+	findings := proxysql.Lint(expandQueryRules(d.Get("query_rules").([]interface{})), proxysql.LintOptions{
+		WriteHostgroup: d.Get("write_hostgroup").(int),
+		Hostgroups:     hostgroups,
+	})
+	warnings := make([]string, len(findings))
+	for i, finding := range findings {
+		warnings[i] = finding.String()
+	}
+	if mode == "error" && len(warnings) > 0 {
+		return fmt.Errorf("query_rules has problems, set lint_query_rules = \"warn\" to plan anyway:\n%s", strings.Join(warnings, "\n"))
+	}
+	return d.SetNew("query_rule_warnings", warnings)
+}
+
+// preconditionFailedDiag is the diagnostic for a change rejected because
+// the instance group was changed outside of this plan.
+func preconditionFailedDiag(instanceName string, err error) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  fmt.Sprintf("Instance group %s changed since plan, re-run terraform plan", instanceName),
+		Detail:   fmt.Sprintf("chester-api rejected the change because the instance group no longer matches the revision this plan was made against: %s", err.Error()),
+	}
+}
+
+// rejected reports whether chester-api turned a call down outright, in
+// which case nothing was changed. Anything else, like a timeout, a reset
+// or a 5xx, may have been applied before the call failed.
+func rejected(err error) bool {
+	statusErr := &chester.StatusError{}
+	return errors.As(err, &statusErr) && statusErr.StatusCode >= http.StatusBadRequest && statusErr.StatusCode < http.StatusInternalServerError
+}
+
+// instanceGroupExists checks whether chester-api has the instance group,
+// used to find out what a failed call did.
+func instanceGroupExists(c *chester.Client, instanceName string) (bool, error) {
+	_, err := c.GetDatabase(instanceName)
+	if errors.Is(err, chester.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
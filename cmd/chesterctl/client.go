@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+// globalOptions are the flags every command takes.
+type globalOptions struct {
+	host       string
+	username   string
+	password   string
+	clientID   string
+	disableIAP bool
+	timeout    time.Duration
+	output     string
+}
+
+// register adds the global flags to fs, defaulting to the provider's env vars.
+func (o *globalOptions) register(fs *flag.FlagSet, getenv func(string) string) {
+	disableIAP, _ := strconv.ParseBool(getenv("CHESTER_DISABLE_IAP"))
+	fs.StringVar(&o.host, "host", getenv("CHESTER_HOST"), "chester-api url, env var CHESTER_HOST")
+	fs.StringVar(&o.username, "username", getenv("CHESTER_USERNAME"), "basic auth username, env var CHESTER_USERNAME")
+	fs.StringVar(&o.password, "password", getenv("CHESTER_PASSWORD"), "basic auth password, env var CHESTER_PASSWORD")
+	fs.StringVar(&o.clientID, "client-id", getenv("CHESTER_CLIENT_ID"), "IAP client id, env var CHESTER_CLIENT_ID")
+	fs.BoolVar(&o.disableIAP, "disable-iap", disableIAP, "skip the IAP token, env var CHESTER_DISABLE_IAP")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "timeout of every call to chester-api")
+	fs.StringVar(&o.output, "output", "table", "output format: table, json or yaml")
+	fs.StringVar(&o.output, "o", "table", "shorthand for -output")
+}
+
+// cliContext is everything a command needs to run.
+type cliContext struct {
+	ctx    context.Context
+	client *api.Client
+	stdin  io.Reader
+	out    *printer
+}
+
+// newCLIContext creates the api client the same way the provider does,
+// unless connect is false.
+func newCLIContext(o *globalOptions, stdin io.Reader, stdout io.Writer, connect bool) (*cliContext, error) {
+	out, err := newPrinter(stdout, o.output)
+	if err != nil {
+		return nil, err
+	}
+	if !connect {
+		return &cliContext{ctx: context.Background(), stdin: stdin, out: out}, nil
+	}
+	if o.host == "" {
+		return nil, fmt.Errorf("no host found, set -host or CHESTER_HOST")
+	}
+	if o.username == "" || o.password == "" {
+		return nil, fmt.Errorf("set -username and -password, or CHESTER_USERNAME and CHESTER_PASSWORD")
+	}
+	opts := []api.ClientOption{
+		api.WithHost(o.host),
+		api.WithUsername(o.username),
+		api.WithPassword(o.password),
+		api.WithHTTPClient(&http.Client{Timeout: o.timeout}),
+	}
+	if !o.disableIAP {
+		if o.clientID == "" {
+			return nil, fmt.Errorf("no client id found, set -client-id or CHESTER_CLIENT_ID, or -disable-iap")
+		}
+		opts = append(opts, api.WithAudience(o.clientID))
+	}
+	c, err := api.NewClientWithOptions(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create chester client %s", err.Error())
+	}
+	return &cliContext{
+		ctx:    context.Background(),
+		client: c,
+		stdin:  stdin,
+		out:    out,
+	}, nil
+}
+
+// readFile reads a file, "-" reads stdin.
+func (ctx *cliContext) readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(ctx.stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// readJSONFile decodes a json file into v, "-" reads stdin.
+func (ctx *cliContext) readJSONFile(path string, v interface{}) error {
+	b, err := ctx.readFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// instanceFlag is a repeatable name=ip_address flag.
+type instanceFlag struct {
+	set       bool
+	instances []instance
+}
+
+// instance is a name and ip address, the shape of the master and read
+// replicas in every request.
+type instance struct {
+	name      string
+	ipAddress string
+}
+
+func (f *instanceFlag) String() string {
+	parts := make([]string, len(f.instances))
+	for i, inst := range f.instances {
+		parts[i] = fmt.Sprintf("%s=%s", inst.name, inst.ipAddress)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *instanceFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected name=ip_address, got %s", value)
+	}
+	f.set = true
+	f.instances = append(f.instances, instance{name: parts[0], ipAddress: parts[1]})
+	return nil
+}
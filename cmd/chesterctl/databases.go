@@ -0,0 +1,249 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+func init() {
+	resources["dbs"] = map[string]func() *command{
+		"list":       dbsList,
+		"get":        dbsGet,
+		"add":        dbsAdd,
+		"modify":     dbsModify,
+		"remove":     dbsRemove,
+		"export":     dbsExport,
+		"import-cnf": dbsImportCnf,
+	}
+}
+
+// printDatabases writes instance groups, passwords are left out of the table.
+func printDatabases(ctx *cliContext, v interface{}, dbs []api.InstanceData) error {
+	return ctx.out.print(v, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "NAME\tUSERNAME\tWRITE HOSTGROUP\tREAD HOSTGROUP\tWRITER\tREAD REPLICAS\tMAX INSTANCES\tQUERY RULES")
+		for _, db := range dbs {
+			replicas := make([]string, len(db.ReadReplicas))
+			for i, rr := range db.ReadReplicas {
+				replicas[i] = fmt.Sprintf("%s=%s", rr.Name, rr.IPAddress)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s=%s\t%s\t%d\t%d\n",
+				db.InstanceName, db.Username, db.WriteHostGroup, db.ReadHostGroup,
+				db.MasterInstance.Name, db.MasterInstance.IPAddress, strings.Join(replicas, ","),
+				db.ChesterMetaData.MaxChesterInstances, len(db.QueryRules))
+		}
+	})
+}
+
+func dbsList() *command {
+	var prefix string
+	labels := labelFlag{}
+	return &command{
+		help: "list instance groups",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&prefix, "prefix", "", "only list instance groups starting with prefix")
+			fs.Var(&labels, "label", "only list instance groups with label key=value, repeatable")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 0); err != nil {
+				return err
+			}
+			dbs, err := ctx.client.ListAllDatabases(ctx.ctx, api.ListOptions{
+				Filter:     true,
+				NamePrefix: prefix,
+				Labels:     labels,
+			})
+			if err != nil {
+				return err
+			}
+			return printDatabases(ctx, dbs, dbs)
+		},
+	}
+}
+
+func dbsGet() *command {
+	return &command{
+		args: "<instance-group>",
+		help: "show an instance group",
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			db, _, err := ctx.client.GetInstanceGroup(args[0])
+			if err != nil {
+				return err
+			}
+			return printDatabases(ctx, db, []api.InstanceData{db})
+		},
+	}
+}
+
+func dbsAdd() *command {
+	var file, username, password string
+	var maxInstances int
+	master := &instanceFlag{}
+	replicas := &instanceFlag{}
+	return &command{
+		args: "<instance-group>",
+		help: "add an instance group",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&file, "f", "", "json add request to send, - reads stdin, other flags override it")
+			fs.StringVar(&username, "db-username", "", "user proxysql connects as")
+			fs.StringVar(&password, "db-password", "", "password of -db-username")
+			fs.IntVar(&maxInstances, "max-instances", 0, "most proxysql instances chester scales to")
+			fs.Var(master, "master", "writer instance as name=ip_address, defaults to the instance group name")
+			fs.Var(replicas, "replica", "read replica as name=ip_address, repeatable")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			req := api.AddDatabaseRequest{}
+			if file != "" {
+				if err := ctx.readJSONFile(file, &req); err != nil {
+					return err
+				}
+			}
+			req.Action = "add"
+			req.InstanceName = args[0]
+			req.ChesterMetaData.InstanceGroup = args[0]
+			if username != "" {
+				req.Username = username
+			}
+			if password != "" {
+				req.Password = password
+			}
+			if maxInstances != 0 {
+				req.ChesterMetaData.MaxChesterInstances = maxInstances
+			}
+			if master.set {
+				inst := master.instances[len(master.instances)-1]
+				req.MasterInstance = models.AddDatabaseRequestDatabaseInformation{Name: inst.name, IPAddress: inst.ipAddress}
+			}
+			if req.MasterInstance.Name == "" {
+				req.MasterInstance.Name = req.InstanceName
+			}
+			if replicas.set {
+				req.ReadReplicas = expandInstances(replicas)
+			}
+			if req.ReadReplicas == nil {
+				req.ReadReplicas = []models.AddDatabaseRequestDatabaseInformation{}
+			}
+			if req.Username == "" || req.Password == "" || req.MasterInstance.IPAddress == "" {
+				return fmt.Errorf("-db-username, -db-password and -master are required unless set in -f")
+			}
+			resp, err := ctx.client.AddInstanceGroup(req)
+			if err != nil {
+				return err
+			}
+			if ctx.out.format != formatTable {
+				return ctx.out.print(resp, nil)
+			}
+			ctx.out.message("added instance group %s with %d query rules", resp.InstanceName, len(resp.QueryRules))
+			return nil
+		},
+	}
+}
+
+func dbsModify() *command {
+	var username, password, ifMatch string
+	var maxInstances int
+	replicas := &instanceFlag{}
+	return &command{
+		args: "<instance-group>",
+		help: "change an instance group",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&username, "db-username", "", "new user proxysql connects as")
+			fs.StringVar(&password, "db-password", "", "new password")
+			fs.IntVar(&maxInstances, "max-instances", 0, "new most proxysql instances chester scales to")
+			fs.Var(replicas, "replica", "read replica as name=ip_address, repeatable, replaces every read replica")
+			fs.StringVar(&ifMatch, "if-match", "", "only change the instance group if it's still at this revision")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			req := models.ModifyDatabaseRequest{
+				Action:       "modify",
+				InstanceName: args[0],
+				NewUsername:  username,
+				NewPassword:  password,
+			}
+			if replicas.set {
+				req.ReadReplicas = expandInstances(replicas)
+			}
+			if maxInstances != 0 {
+				req.ChesterMetaData = models.ChesterMetaData{
+					InstanceGroup:       args[0],
+					MaxChesterInstances: maxInstances,
+				}
+			}
+			if req.NewUsername == "" && req.NewPassword == "" && req.ReadReplicas == nil && maxInstances == 0 {
+				return fmt.Errorf("nothing to change, set at least one of -db-username, -db-password, -max-instances or -replica")
+			}
+			if err := ctx.client.ModifyDatabase(req, api.IfMatch(ifMatch)); err != nil {
+				return err
+			}
+			ctx.out.message("modified instance group %s", args[0])
+			return nil
+		},
+	}
+}
+
+func dbsRemove() *command {
+	var ifMatch string
+	return &command{
+		args: "<instance-group>",
+		help: "remove an instance group and its users",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&ifMatch, "if-match", "", "only remove the instance group if it's still at this revision")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			err := ctx.client.RemoveDatabase(models.RemoveDatabaseRequest{
+				Action:       "remove",
+				InstanceName: args[0],
+			}, api.IfMatch(ifMatch))
+			if err != nil {
+				return err
+			}
+			ctx.out.message("removed instance group %s", args[0])
+			return nil
+		},
+	}
+}
+
+// expandInstances converts name=ip_address flags into the request type.
+func expandInstances(f *instanceFlag) []models.AddDatabaseRequestDatabaseInformation {
+	instances := make([]models.AddDatabaseRequestDatabaseInformation, len(f.instances))
+	for i, inst := range f.instances {
+		instances[i] = models.AddDatabaseRequestDatabaseInformation{Name: inst.name, IPAddress: inst.ipAddress}
+	}
+	return instances
+}
+
+// labelFlag is a repeatable key=value flag.
+type labelFlag map[string]string
+
+func (f labelFlag) String() string {
+	parts := []string{}
+	for k, v := range f {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f labelFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected key=value, got %s", value)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
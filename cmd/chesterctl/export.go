@@ -0,0 +1,353 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/eahrend/terraform-provider-chester/api"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func dbsExport() *command {
+	var prefix string
+	var noImports bool
+	return &command{
+		help: "write terraform config and import blocks for existing instance groups",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&prefix, "prefix", "", "only export instance groups starting with prefix")
+			fs.BoolVar(&noImports, "no-imports", false, "leave out the import blocks, for terraform older than 1.5")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 0); err != nil {
+				return err
+			}
+			dbs, err := ctx.client.ListAllDatabases(ctx.ctx, api.ListOptions{Filter: true, NamePrefix: prefix})
+			if err != nil {
+				return err
+			}
+			// servers that don't page ignore the prefix
+			exported := []api.InstanceData{}
+			for _, db := range dbs {
+				if strings.HasPrefix(db.InstanceName, prefix) {
+					exported = append(exported, db)
+				}
+			}
+			variables, err := mysqlVariables(ctx.client, exported)
+			if err != nil {
+				return err
+			}
+			_, err = ctx.out.w.Write(exportHCL(exported, variables, !noImports))
+			return err
+		},
+	}
+}
+
+// mysqlVariables returns the mysql_variables of every instance group in
+// dbs, by instance name.
+func mysqlVariables(c *api.Client, dbs []api.InstanceData) (map[string]map[string]string, error) {
+	variables := map[string]map[string]string{}
+	for _, db := range dbs {
+		vars, err := c.GetMysqlVariablesIfSupported(db.InstanceName)
+		if err != nil {
+			return nil, err
+		}
+		variables[db.InstanceName] = vars
+	}
+	return variables, nil
+}
+
+// invalidLabelChars matches everything that can't be in a terraform name.
+var invalidLabelChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// resourceLabels picks a unique terraform resource name for every
+// instance group.
+func resourceLabels(dbs []api.InstanceData) []string {
+	labels := make([]string, len(dbs))
+	used := map[string]int{}
+	for i, db := range dbs {
+		label := invalidLabelChars.ReplaceAllString(strings.ToLower(db.InstanceName), "_")
+		if label == "" || !(label[0] == '_' || (label[0] >= 'a' && label[0] <= 'z')) {
+			label = "db_" + label
+		}
+		used[label]++
+		if used[label] > 1 {
+			label = fmt.Sprintf("%s_%d", label, used[label])
+		}
+		labels[i] = label
+	}
+	return labels
+}
+
+// exportHCL renders a chester_database resource for every instance
+// group, with its mysql_variables from variables. Passwords and the sql
+// project aren't exported, they're variables instead. The sql project
+// isn't known to chester-api, so the first plan after an import shows it
+// being set.
+func exportHCL(dbs []api.InstanceData, variables map[string]map[string]string, imports bool) []byte {
+	f := hclwrite.NewEmptyFile()
+	body := f.Body()
+	labels := resourceLabels(dbs)
+
+	projectVariable := body.AppendNewBlock("variable", []string{"sql_project_id"})
+	projectVariable.Body().SetAttributeTraversal("type", hcl.Traversal{hcl.TraverseRoot{Name: "string"}})
+	for i := range dbs {
+		body.AppendNewline()
+		variable := body.AppendNewBlock("variable", []string{passwordVariable(labels[i])})
+		variable.Body().SetAttributeTraversal("type", hcl.Traversal{hcl.TraverseRoot{Name: "string"}})
+		variable.Body().SetAttributeValue("sensitive", cty.True)
+		if dbs[i].Monitor != nil {
+			body.AppendNewline()
+			variable := body.AppendNewBlock("variable", []string{monitorPasswordVariable(labels[i])})
+			variable.Body().SetAttributeTraversal("type", hcl.Traversal{hcl.TraverseRoot{Name: "string"}})
+			variable.Body().SetAttributeValue("sensitive", cty.True)
+		}
+	}
+
+	for i, db := range dbs {
+		body.AppendNewline()
+		if imports {
+			importBlock := body.AppendNewBlock("import", nil)
+			importBlock.Body().SetAttributeTraversal("to", hcl.Traversal{
+				hcl.TraverseRoot{Name: "chester_database"},
+				hcl.TraverseAttr{Name: labels[i]},
+			})
+			importBlock.Body().SetAttributeValue("id", cty.StringVal(db.InstanceName))
+			body.AppendNewline()
+		}
+		appendDatabase(body, labels[i], db, variables[db.InstanceName])
+	}
+	return hclwrite.Format(f.Bytes())
+}
+
+// passwordVariable is the name of the variable holding an instance
+// group's password.
+func passwordVariable(label string) string {
+	return strings.ReplaceAll(label, "-", "_") + "_password"
+}
+
+// monitorPasswordVariable is the name of the variable holding an instance
+// group's monitor password.
+func monitorPasswordVariable(label string) string {
+	return strings.ReplaceAll(label, "-", "_") + "_monitor_password"
+}
+
+// appendDatabase renders one chester_database resource, query rules
+// are kept in the order proxysql applies them. The rules and hostgroup of
+// query_cache and mirror go back into those blocks.
+func appendDatabase(body *hclwrite.Body, label string, db api.InstanceData, vars map[string]string) {
+	resource := body.AppendNewBlock("resource", []string{"chester_database", label})
+	rb := resource.Body()
+	rb.SetAttributeValue("instance_name", cty.StringVal(db.InstanceName))
+	rb.SetAttributeTraversal("sql_project_id", hcl.Traversal{
+		hcl.TraverseRoot{Name: "var"},
+		hcl.TraverseAttr{Name: "sql_project_id"},
+	})
+	rb.SetAttributeValue("username", cty.StringVal(db.Username))
+	rb.SetAttributeTraversal("password", hcl.Traversal{
+		hcl.TraverseRoot{Name: "var"},
+		hcl.TraverseAttr{Name: passwordVariable(label)},
+	})
+	if db.Monitor != nil {
+		rb.SetAttributeValue("monitor_username", cty.StringVal(db.Monitor.Username))
+		rb.SetAttributeTraversal("monitor_password", hcl.Traversal{
+			hcl.TraverseRoot{Name: "var"},
+			hcl.TraverseAttr{Name: monitorPasswordVariable(label)},
+		})
+	}
+	rb.SetAttributeValue("enable_ssl", cty.NumberIntVal(int64(db.UseSSL)))
+	rb.SetAttributeValue("read_hostgroup", cty.NumberIntVal(int64(db.ReadHostGroup)))
+	rb.SetAttributeValue("write_hostgroup", cty.NumberIntVal(int64(db.WriteHostGroup)))
+	rb.SetAttributeValue("max_chester_instances", cty.NumberIntVal(int64(db.ChesterMetaData.MaxChesterInstances)))
+	rb.SetAttributeValue("master_instance", cty.ObjectVal(map[string]cty.Value{
+		"name":       cty.StringVal(db.MasterInstance.Name),
+		"ip_address": cty.StringVal(db.MasterInstance.IPAddress),
+	}))
+	// query_cache_size_MB is query_cache's size_mb
+	values := map[string]cty.Value{}
+	for name, value := range vars {
+		if name != "query_cache_size_MB" {
+			values[name] = cty.StringVal(value)
+		}
+	}
+	if len(values) > 0 {
+		rb.SetAttributeValue("mysql_variables", cty.MapVal(values))
+	}
+	for _, rr := range db.ReadReplicas {
+		rb.AppendNewline()
+		replica := rb.AppendNewBlock("read_replicas", nil).Body()
+		replica.SetAttributeValue("name", cty.StringVal(rr.Name))
+		replica.SetAttributeValue("ip_address", cty.StringVal(rr.IPAddress))
+	}
+	for _, pair := range db.ReplicationHostgroups {
+		// pairs of other hostgroups have no place in the resource
+		if pair.WriterHostgroup != db.WriteHostGroup || pair.ReaderHostgroup != db.ReadHostGroup {
+			continue
+		}
+		rb.AppendNewline()
+		replication := rb.AppendNewBlock("replication_hostgroup", nil).Body()
+		if pair.CheckType != "" {
+			replication.SetAttributeValue("check_type", cty.StringVal(pair.CheckType))
+		}
+		if pair.Comment != "" {
+			replication.SetAttributeValue("comment", cty.StringVal(pair.Comment))
+		}
+	}
+	queryCache, mirror, queryRules := splitQueryRules(db.QueryRules)
+	for _, hg := range db.Hostgroups {
+		// the mirror hostgroup goes in the mirror block
+		if len(mirror) > 0 && mirror[0].MirrorHostgroup != nil && hg.HostgroupID == *mirror[0].MirrorHostgroup {
+			continue
+		}
+		rb.AppendNewline()
+		hostgroup := rb.AppendNewBlock("hostgroup", nil).Body()
+		hostgroup.SetAttributeValue("hostgroup_id", cty.NumberIntVal(int64(hg.HostgroupID)))
+		if hg.MaxConnections != 0 {
+			hostgroup.SetAttributeValue("max_connections", cty.NumberIntVal(int64(hg.MaxConnections)))
+		}
+		if hg.Comment != "" {
+			hostgroup.SetAttributeValue("comment", cty.StringVal(hg.Comment))
+		}
+		for _, s := range hg.Servers {
+			server := hostgroup.AppendNewBlock("servers", nil).Body()
+			server.SetAttributeValue("name", cty.StringVal(s.Name))
+			server.SetAttributeValue("ip_address", cty.StringVal(s.IPAddress))
+		}
+	}
+	if len(queryCache) > 0 {
+		rb.AppendNewline()
+		appendQueryCache(rb.AppendNewBlock("query_cache", nil).Body(), db, queryCache, vars)
+	}
+	if len(mirror) > 0 {
+		rb.AppendNewline()
+		appendMirror(rb.AppendNewBlock("mirror", nil).Body(), db, mirror)
+	}
+	for _, qr := range queryRules {
+		rb.AppendNewline()
+		rule := rb.AppendNewBlock("query_rules", nil).Body()
+		rule.SetAttributeValue("username", cty.StringVal(qr.Username))
+		rule.SetAttributeValue("active", cty.NumberIntVal(int64(qr.Active)))
+		rule.SetAttributeValue("match_digest", cty.StringVal(qr.MatchDigest))
+		if qr.DestinationHostgroup != 0 {
+			rule.SetAttributeValue("destination_hostgroup", cty.NumberIntVal(int64(qr.DestinationHostgroup)))
+		}
+		rule.SetAttributeValue("apply", cty.NumberIntVal(int64(qr.Apply)))
+		if qr.Comment != "" {
+			rule.SetAttributeValue("comment", cty.StringVal(qr.Comment))
+		}
+		appendQueryRuleColumns(rule, qr)
+	}
+}
+
+// splitQueryRules splits out the rules the resource plans from its
+// query_cache and mirror blocks, they're told apart by their comment.
+// The resource doesn't take those comments in query_rules.
+func splitQueryRules(rules []api.QueryRule) (queryCache, mirror, rest []api.QueryRule) {
+	for _, qr := range rules {
+		switch {
+		case qr.Comment == "query_cache" && qr.CacheTTL != nil:
+			queryCache = append(queryCache, qr)
+		case qr.Comment == "mirror" && qr.MirrorHostgroup != nil:
+			mirror = append(mirror, qr)
+		default:
+			rest = append(rest, qr)
+		}
+	}
+	return queryCache, mirror, rest
+}
+
+// appendQueryCache rebuilds a query_cache block from the rules it planned
+// and query_cache_size_MB, leaving out what the block defaults to.
+func appendQueryCache(block *hclwrite.Body, db api.InstanceData, rules []api.QueryRule, vars map[string]string) {
+	if size, err := strconv.Atoi(vars["query_cache_size_MB"]); err == nil && size != 256 {
+		block.SetAttributeValue("size_mb", cty.NumberIntVal(int64(size)))
+	}
+	for _, qr := range rules {
+		rule := block.AppendNewBlock("rule", nil).Body()
+		rule.SetAttributeValue("match_digest", cty.StringVal(qr.MatchDigest))
+		rule.SetAttributeValue("cache_ttl", cty.NumberIntVal(int64(*qr.CacheTTL)))
+		if qr.Username != db.Username {
+			rule.SetAttributeValue("username", cty.StringVal(qr.Username))
+		}
+		if qr.DestinationHostgroup != db.ReadHostGroup {
+			rule.SetAttributeValue("destination_hostgroup", cty.NumberIntVal(int64(qr.DestinationHostgroup)))
+		}
+	}
+}
+
+// appendMirror rebuilds a mirror block from the rules it planned and the
+// servers of their mirror hostgroup. The block has a single hostgroup,
+// flag_out and username, they're taken from the first rule.
+func appendMirror(block *hclwrite.Body, db api.InstanceData, rules []api.QueryRule) {
+	first := rules[0]
+	block.SetAttributeValue("hostgroup_id", cty.NumberIntVal(int64(*first.MirrorHostgroup)))
+	digests := make([]cty.Value, len(rules))
+	for i, qr := range rules {
+		digests[i] = cty.StringVal(qr.MatchDigest)
+	}
+	block.SetAttributeValue("match_digests", cty.ListVal(digests))
+	if first.MirrorFlagOUT != nil {
+		block.SetAttributeValue("flag_out", cty.NumberIntVal(int64(*first.MirrorFlagOUT)))
+	}
+	if first.Username != db.Username {
+		block.SetAttributeValue("username", cty.StringVal(first.Username))
+	}
+	for _, hg := range db.Hostgroups {
+		if hg.HostgroupID != *first.MirrorHostgroup {
+			continue
+		}
+		for _, s := range hg.Servers {
+			server := block.AppendNewBlock("servers", nil).Body()
+			server.SetAttributeValue("name", cty.StringVal(s.Name))
+			server.SetAttributeValue("ip_address", cty.StringVal(s.IPAddress))
+		}
+	}
+}
+
+// appendQueryRuleColumns sets the query rule columns beyond the ones chester
+// started with, leaving out the ones that are empty or NULL so the
+// resource's defaults apply.
+func appendQueryRuleColumns(rule *hclwrite.Body, qr api.QueryRule) {
+	for _, c := range []struct {
+		name  string
+		value string
+	}{
+		{"match_pattern", qr.MatchPattern},
+		{"schemaname", qr.SchemaName},
+		{"client_addr", qr.ClientAddr},
+		{"replace_pattern", qr.ReplacePattern},
+		{"error_msg", qr.ErrorMsg},
+	} {
+		if c.value != "" {
+			rule.SetAttributeValue(c.name, cty.StringVal(c.value))
+		}
+	}
+	if qr.NegateMatchPattern != 0 {
+		rule.SetAttributeValue("negate_match_pattern", cty.NumberIntVal(int64(qr.NegateMatchPattern)))
+	}
+	if qr.FlagIN != 0 {
+		rule.SetAttributeValue("flag_in", cty.NumberIntVal(int64(qr.FlagIN)))
+	}
+	for _, c := range []struct {
+		name  string
+		value *int
+	}{
+		{"flag_out", qr.FlagOUT},
+		{"cache_ttl", qr.CacheTTL},
+		{"timeout", qr.Timeout},
+		{"retries", qr.Retries},
+		{"delay", qr.Delay},
+		{"mirror_hostgroup", qr.MirrorHostgroup},
+		{"mirror_flag_out", qr.MirrorFlagOUT},
+		{"multiplex", qr.Multiplex},
+		{"log", qr.Log},
+	} {
+		if c.value != nil {
+			rule.SetAttributeValue(c.name, cty.NumberIntVal(int64(*c.value)))
+		}
+	}
+}
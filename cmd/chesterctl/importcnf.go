@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/proxysql"
+)
+
+func dbsImportCnf() *command {
+	var maxInstances int
+	var hcl bool
+	names := labelFlag{}
+	return &command{
+		args:    "<proxysql.cnf>",
+		help:    "convert a proxysql.cnf file into add requests or terraform config",
+		offline: true,
+		flags: func(fs *flag.FlagSet) {
+			fs.Var(&names, "name", "instance group of a mysql user as username=instance-group, repeatable, defaults to the username")
+			fs.IntVar(&maxInstances, "max-instances", 1, "most proxysql instances chester scales every instance group to")
+			fs.BoolVar(&hcl, "hcl", false, "write chester_database resources instead of add requests")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			src, err := ctx.readFile(args[0])
+			if err != nil {
+				return err
+			}
+			config, err := proxysql.ParseConfig(src)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %s", args[0], err.Error())
+			}
+			imported, err := config.Import(proxysql.ImportOptions{
+				InstanceNames:       names,
+				MaxChesterInstances: maxInstances,
+			})
+			if err != nil {
+				return err
+			}
+			if hcl {
+				_, err := ctx.out.w.Write(importHCL(imported))
+				return err
+			}
+			v := struct {
+				Databases []api.AddDatabaseRequest   `json:"databases"`
+				Users     []models.ProxySqlMySqlUser `json:"users"`
+			}{imported.Databases, imported.Users}
+			return ctx.out.print(v, func(tw *tabwriter.Writer) {
+				fmt.Fprintln(tw, "INSTANCE GROUP\tUSERNAME\tWRITER\tREAD REPLICAS\tQUERY RULES")
+				for _, db := range imported.Databases {
+					fmt.Fprintf(tw, "%s\t%s\t%s=%s\t%d\t%d\n", db.InstanceName, db.Username,
+						db.MasterInstance.Name, db.MasterInstance.IPAddress, len(db.ReadReplicas), len(db.QueryRules))
+				}
+				for _, user := range imported.Users {
+					fmt.Fprintf(tw, "%s\t%s\t\t\t\n", user.InstanceGroup, user.Username)
+				}
+			})
+		},
+	}
+}
+
+// importHCL renders the instance groups of a proxysql.cnf file as
+// chester_database resources. There's no resource for the extra users, so
+// they're left as comments with the command that adds them.
+func importHCL(imported *proxysql.Import) []byte {
+	dbs := make([]api.InstanceData, len(imported.Databases))
+	for i, req := range imported.Databases {
+		hostgroups := imported.Hostgroups[req.InstanceName]
+		dbs[i] = api.InstanceData{
+			InstanceData: models.InstanceData{
+				InstanceName:    req.InstanceName,
+				Username:        req.Username,
+				ReadHostGroup:   hostgroups.Read,
+				WriteHostGroup:  hostgroups.Write,
+				MasterInstance:  req.MasterInstance,
+				ReadReplicas:    req.ReadReplicas,
+				UseSSL:          req.EnableSSL,
+				ChesterMetaData: req.ChesterMetaData,
+			},
+			QueryRules:            req.QueryRules,
+			Hostgroups:            req.Hostgroups,
+			ReplicationHostgroups: req.ReplicationHostgroups,
+		}
+	}
+	b := bytes.NewBuffer(exportHCL(dbs, nil, false))
+	for _, user := range imported.Users {
+		fmt.Fprintf(b, "\n# %s shares default hostgroup %d with %s, add it with\n", user.Username, user.DefaultHostgroup, user.InstanceGroup)
+		fmt.Fprintf(b, "# chesterctl users add %s -instance-group %s -default-hostgroup %d -user-password ...\n",
+			user.Username, user.InstanceGroup, user.DefaultHostgroup)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,178 @@
+// Command chesterctl manages chester instance groups, users and query
+// rules from the command line, using the same api client and env vars as
+// the terraform provider.
+//
+//	chesterctl dbs list -o yaml
+//	chesterctl rules add my-instance -username foo -match-digest '^SELECT' -destination-hostgroup 10
+//	chesterctl users get foo
+//	chesterctl lint my-instance
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const usage = `Usage: chesterctl <resource> <command> [flags] [args]
+
+Resources and commands:
+%s
+Every command takes these flags, which default to the env vars the
+terraform provider reads:
+  -host          chester-api url (CHESTER_HOST)
+  -username      basic auth username (CHESTER_USERNAME)
+  -password      basic auth password (CHESTER_PASSWORD)
+  -client-id     IAP client id (CHESTER_CLIENT_ID)
+  -disable-iap   skip the IAP token (CHESTER_DISABLE_IAP)
+  -timeout       timeout of every call to chester-api, default 30s
+  -o, -output    table, json or yaml, default table
+
+Run chesterctl <resource> <command> -h for the flags of a command.
+`
+
+// errUsage is returned for bad arguments, after the usage was printed.
+var errUsage = errors.New("usage")
+
+// command is a single chesterctl <resource> <command>.
+type command struct {
+	// args describes the positional arguments, shown in the usage
+	args string
+	// help is a one line description, shown in the usage
+	help string
+	// flags registers the command's own flags
+	flags func(fs *flag.FlagSet)
+	// run runs the command with the positional arguments
+	run func(ctx *cliContext, args []string) error
+	// offline commands don't call chester-api, so they run without a host
+	// or credentials and ctx.client is nil
+	offline bool
+}
+
+// resources maps every resource, and its aliases, to its commands. It's
+// filled in by the init funcs of databases.go, users.go and rules.go.
+var resources = map[string]map[string]func() *command{}
+
+// aliases maps alternative resource names to the name in resources.
+var aliases = map[string]string{
+	"db":         "dbs",
+	"databases":  "dbs",
+	"user":       "users",
+	"rule":       "rules",
+	"queryrules": "rules",
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv))
+}
+
+// run runs chesterctl with args and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) int {
+	// chesterctl lint is short for chesterctl rules lint
+	if len(args) > 0 && args[0] == "lint" {
+		args = append([]string{"rules"}, args...)
+	}
+	if len(args) < 2 {
+		fmt.Fprintf(stderr, usage, usageCommands())
+		return 2
+	}
+	resource := args[0]
+	if alias, ok := aliases[resource]; ok {
+		resource = alias
+	}
+	commands, ok := resources[resource]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown resource %s\n\n", args[0])
+		fmt.Fprintf(stderr, usage, usageCommands())
+		return 2
+	}
+	newCommand, ok := commands[args[1]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %s %s\n\n", args[0], args[1])
+		fmt.Fprintf(stderr, usage, usageCommands())
+		return 2
+	}
+	cmd := newCommand()
+	fs := flag.NewFlagSet(fmt.Sprintf("chesterctl %s %s", resource, args[1]), flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s [flags] %s\n\n%s\n\nFlags:\n", fs.Name(), cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	opts := &globalOptions{}
+	opts.register(fs, getenv)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	positional, err := parseInterspersed(fs, args[2:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	ctx, err := newCLIContext(opts, stdin, stdout, !cmd.offline)
+	if err != nil {
+		fmt.Fprintf(stderr, "chesterctl: %s\n", err.Error())
+		return 1
+	}
+	if err := cmd.run(ctx, positional); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "chesterctl: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed parses flags that come before, after or between the
+// positional arguments, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// usageCommands lists every resource and command for the usage.
+func usageCommands() string {
+	b := &strings.Builder{}
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		commandNames := make([]string, 0, len(resources[name]))
+		for commandName := range resources[name] {
+			commandNames = append(commandNames, commandName)
+		}
+		sort.Strings(commandNames)
+		for _, commandName := range commandNames {
+			cmd := resources[name][commandName]()
+			fmt.Fprintf(b, "  %-22s %s\n", fmt.Sprintf("%s %s", name, commandName), cmd.help)
+		}
+	}
+	return b.String()
+}
+
+// wantArgs returns errUsage unless there are exactly n positional arguments.
+func wantArgs(args []string, n int) error {
+	if len(args) != n {
+		return errUsage
+	}
+	return nil
+}
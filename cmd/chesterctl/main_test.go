@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/api/apitest"
+)
+
+// runCLI runs chesterctl against srv and returns stdout, stderr and the exit code.
+func runCLI(t *testing.T, srv *apitest.Server, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	env := map[string]string{
+		"CHESTER_HOST":        srv.URL,
+		"CHESTER_USERNAME":    srv.Username,
+		"CHESTER_PASSWORD":    srv.Password,
+		"CHESTER_DISABLE_IAP": "true",
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run(args, strings.NewReader(stdin), stdout, stderr, func(key string) string { return env[key] })
+	return stdout.String(), stderr.String(), code
+}
+
+// mustRun runs chesterctl and fails the test unless it exits 0.
+func mustRun(t *testing.T, srv *apitest.Server, args ...string) string {
+	t.Helper()
+	stdout, stderr, code := runCLI(t, srv, "", args...)
+	if code != 0 {
+		t.Fatalf("chesterctl %s exited %d: %s", strings.Join(args, " "), code, stderr)
+	}
+	return stdout
+}
+
+func TestDatabases(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	out := mustRun(t, srv, "dbs", "add", "foo", "-db-username", "foo", "-db-password", "bar",
+		"-master", "foo=10.0.0.2", "-replica", "foo-read=10.0.0.3", "-max-instances", "4")
+	if !strings.Contains(out, "added instance group foo with 3 query rules") {
+		t.Fatalf("unexpected add output %q", out)
+	}
+	out = mustRun(t, srv, "dbs", "list")
+	if !strings.Contains(out, "NAME") || !strings.Contains(out, "foo-read=10.0.0.3") || strings.Contains(out, "bar") {
+		t.Fatalf("unexpected list output %q", out)
+	}
+	mustRun(t, srv, "databases", "modify", "foo", "-max-instances", "6", "-replica", "foo-read=10.0.0.4")
+	db := models.InstanceData{}
+	if err := json.Unmarshal([]byte(mustRun(t, srv, "db", "get", "foo", "-o", "json")), &db); err != nil {
+		t.Fatal(err)
+	}
+	if db.ChesterMetaData.MaxChesterInstances != 6 || len(db.ReadReplicas) != 1 || db.ReadReplicas[0].IPAddress != "10.0.0.4" {
+		t.Fatalf("modify wasn't applied %+v", db)
+	}
+	mustRun(t, srv, "dbs", "remove", "foo")
+	if _, stderr, code := runCLI(t, srv, "", "dbs", "get", "foo"); code != 1 || !strings.Contains(stderr, "not found") {
+		t.Fatalf("expected get of a removed instance group to fail, got %d %s", code, stderr)
+	}
+}
+
+func TestDatabasesAddFromStdin(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	req := `{"username": "foo", "password": "bar", "master_instance": {"name": "foo", "ip_address": "10.0.0.2"}}`
+	_, stderr, code := runCLI(t, srv, req, "dbs", "add", "foo", "-f", "-", "-db-password", "baz", "-o", "json")
+	if code != 0 {
+		t.Fatalf("add exited %d: %s", code, stderr)
+	}
+	db, ok := srv.Database("foo")
+	if !ok || db.Username != "foo" || db.Password != "baz" {
+		t.Fatalf("expected flags to override the file, got %+v", db)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{InstanceName: "foo", Username: "foo", Password: "bar", WriteHostGroup: 5, ReadHostGroup: 10})
+	mustRun(t, srv, "users", "add", "reporting", "-user-password", "secret", "-instance-group", "foo", "-default-hostgroup", "10")
+	user := models.ProxySqlMySqlUser{}
+	if err := json.Unmarshal([]byte(mustRun(t, srv, "user", "get", "reporting", "-o", "json")), &user); err != nil {
+		t.Fatal(err)
+	}
+	if user.InstanceGroup != "foo" || user.DefaultHostgroup != 10 || user.Active != 1 {
+		t.Fatalf("unexpected user %+v", user)
+	}
+	mustRun(t, srv, "users", "modify", "reporting", "-default-hostgroup", "5")
+	if user, _ := srv.User("reporting"); user.DefaultHostgroup != 5 {
+		t.Fatalf("modify wasn't applied %+v", user)
+	}
+	out := mustRun(t, srv, "users", "list")
+	if !strings.Contains(out, "foo") || strings.Contains(out, "bar") {
+		t.Fatalf("unexpected list output %q", out)
+	}
+	mustRun(t, srv, "users", "remove", "reporting")
+	if _, ok := srv.User("reporting"); ok {
+		t.Fatal("expected the user to be removed")
+	}
+}
+
+func TestRules(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	mustRun(t, srv, "dbs", "add", "foo", "-db-username", "foo", "-db-password", "bar", "-master", "foo=10.0.0.2")
+	mustRun(t, srv, "rules", "add", "foo", "-match-digest", "^SELECT .* FROM reports", "-destination-hostgroup", "10", "-comment", "reports")
+	rules := []models.ProxySqlMySqlQueryRule{}
+	if err := json.Unmarshal([]byte(mustRun(t, srv, "rules", "list", "foo", "-o", "json")), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 query rules, got %+v", rules)
+	}
+	added := rules[3]
+	if added.Username != "foo" || added.DestinationHostgroup != 10 || added.Apply != 1 || added.Comment != "reports" {
+		t.Fatalf("unexpected query rule %+v", added)
+	}
+	out := mustRun(t, srv, "rules", "get", "foo", strconv.Itoa(added.RuleID), "-o", "yaml")
+	if !strings.Contains(out, "match_digest: ^SELECT .* FROM reports\n") {
+		t.Fatalf("unexpected yaml %s", out)
+	}
+	mustRun(t, srv, "queryrules", "modify", "foo", strconv.Itoa(added.RuleID), "-active", "0")
+	db, _ := srv.Database("foo")
+	if rule := db.QueryRules[3]; rule.Active != 0 || rule.MatchDigest != added.MatchDigest {
+		t.Fatalf("modify wasn't applied %+v", rule)
+	}
+	mustRun(t, srv, "rule", "remove", "foo", strconv.Itoa(added.RuleID))
+	if db, _ := srv.Database("foo"); len(db.QueryRules) != 3 {
+		t.Fatalf("expected the query rule to be removed, got %+v", db.QueryRules)
+	}
+	if _, stderr, code := runCLI(t, srv, "", "rules", "get", "foo", strconv.Itoa(added.RuleID)); code != 1 || !strings.Contains(stderr, "no query rule") {
+		t.Fatalf("expected get of a removed rule to fail, got %d %s", code, stderr)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{args: []string{}, code: 2, want: "Resources and commands"},
+		{args: []string{"tables", "list"}, code: 2, want: "unknown resource tables"},
+		{args: []string{"dbs", "drop"}, code: 2, want: "unknown command dbs drop"},
+		{args: []string{"dbs", "get"}, code: 2, want: "<instance-group>"},
+		{args: []string{"dbs", "list", "-o", "xml"}, code: 1, want: "unknown output format xml"},
+		{args: []string{"dbs", "list", "-host", ""}, code: 1, want: "no host found"},
+		{args: []string{"dbs", "list", "-disable-iap=false"}, code: 1, want: "no client id found"},
+	}
+	for _, tt := range tests {
+		_, stderr, code := runCLI(t, srv, "", tt.args...)
+		if code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("chesterctl %s: expected exit %d with %q, got %d %q", strings.Join(tt.args, " "), tt.code, tt.want, code, stderr)
+		}
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	p := &printer{w: &bytes.Buffer{}, format: formatYAML}
+	v := map[string]interface{}{
+		"name":    "foo",
+		"port":    3306,
+		"empty":   "",
+		"flag":    "yes",
+		"version": "8.0",
+		"size":    "1_000",
+		"ip":      "10.0.0.2",
+		"rules":   []interface{}{map[string]interface{}{"id": 1, "digest": "^SELECT"}},
+		"none":    []interface{}{},
+		"meta":    map[string]interface{}{"nested": true},
+	}
+	if err := p.print(v, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `empty: ""
+flag: "yes"
+ip: 10.0.0.2
+meta:
+  nested: true
+name: foo
+none: []
+port: 3306
+rules:
+- digest: ^SELECT
+  id: 1
+size: "1_000"
+version: "8.0"
+`
+	if got := p.w.(*bytes.Buffer).String(); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{
+		InstanceName:   "orders-db",
+		Username:       "orders",
+		Password:       "secret",
+		ReadHostGroup:  10,
+		WriteHostGroup: 5,
+		MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "orders-db", IPAddress: "10.0.0.2"},
+		ReadReplicas: []models.AddDatabaseRequestDatabaseInformation{
+			{Name: "orders-read", IPAddress: "10.0.0.3"},
+		},
+		QueryRules: []models.ProxySqlMySqlQueryRule{
+			{Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1, Comment: "reads"},
+			{Username: "orders", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1},
+		},
+		ChesterMetaData: models.ChesterMetaData{InstanceGroup: "orders-db", MaxChesterInstances: 3},
+	})
+	srv.PutDatabase(models.InstanceData{InstanceName: "1legacy", Username: "legacy", MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "1legacy", IPAddress: "10.0.1.2"}})
+	client, err := api.NewClientWithOptions(api.WithHost(srv.URL), api.WithUsername(srv.Username), api.WithPassword(srv.Password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetMysqlVariables("orders-db", map[string]string{"server_version": "8.0.27", "query_cache_size_MB": "256"}); err != nil {
+		t.Fatal(err)
+	}
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "orders-db"},
+		Monitor:               &api.MonitorCredentials{Username: "orders-monitor", Password: "monitorsecret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := mustRun(t, srv, "dbs", "export", "-prefix", "orders")
+	want := `variable "sql_project_id" {
+  type = string
+}
+
+variable "orders_db_password" {
+  type      = string
+  sensitive = true
+}
+
+variable "orders_db_monitor_password" {
+  type      = string
+  sensitive = true
+}
+
+import {
+  to = chester_database.orders-db
+  id = "orders-db"
+}
+
+resource "chester_database" "orders-db" {
+  instance_name         = "orders-db"
+  sql_project_id        = var.sql_project_id
+  username              = "orders"
+  password              = var.orders_db_password
+  monitor_username      = "orders-monitor"
+  monitor_password      = var.orders_db_monitor_password
+  enable_ssl            = 0
+  read_hostgroup        = 10
+  write_hostgroup       = 5
+  max_chester_instances = 3
+  master_instance       = { ip_address = "10.0.0.2", name = "orders-db" }
+  mysql_variables       = { server_version = "8.0.27" }
+
+  read_replicas {
+    name       = "orders-read"
+    ip_address = "10.0.0.3"
+  }
+
+  query_rules {
+    username              = "orders"
+    active                = 1
+    match_digest          = "^SELECT"
+    destination_hostgroup = 10
+    apply                 = 1
+    comment               = "reads"
+  }
+
+  query_rules {
+    username              = "orders"
+    active                = 1
+    match_digest          = ".*"
+    destination_hostgroup = 5
+    apply                 = 1
+  }
+}
+`
+	if out != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, out)
+	}
+	out = mustRun(t, srv, "dbs", "export", "-no-imports")
+	if strings.Contains(out, "import {") || !strings.Contains(out, `resource "chester_database" "db_1legacy"`) {
+		t.Fatalf("unexpected export %s", out)
+	}
+}
+
+// TestExportQueryCacheAndMirror checks that the rules and hostgroup of
+// query_cache and mirror are exported as those blocks, the resource
+// doesn't take them as query_rules and hostgroup blocks.
+func TestExportQueryCacheAndMirror(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{
+		InstanceName:    "orders-db",
+		Username:        "orders",
+		ReadHostGroup:   10,
+		WriteHostGroup:  5,
+		MasterInstance:  models.AddDatabaseRequestDatabaseInformation{Name: "orders-db", IPAddress: "10.0.0.2"},
+		ChesterMetaData: models.ChesterMetaData{InstanceGroup: "orders-db", MaxChesterInstances: 3},
+	})
+	client, err := api.NewClientWithOptions(api.WithHost(srv.URL), api.WithUsername(srv.Username), api.WithPassword(srv.Password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetMysqlVariables("orders-db", map[string]string{"query_cache_size_MB": "512"}); err != nil {
+		t.Fatal(err)
+	}
+	mirrorHostgroup, flagOut, cacheTTL := 20, 3, 60000
+	err = client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+		ModifyDatabaseRequest: models.ModifyDatabaseRequest{InstanceName: "orders-db"},
+		Hostgroups: &[]api.Hostgroup{{
+			HostgroupID: 20,
+			Servers:     []models.AddDatabaseRequestDatabaseInformation{{Name: "orders-shadow", IPAddress: "10.0.0.21"}},
+		}},
+		AddQueryRules: []api.QueryRule{
+			{
+				ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{Username: "orders", Active: 1, MatchDigest: "^SELECT .* FROM orders", DestinationHostgroup: 10, Comment: "mirror"},
+				MirrorHostgroup:        &mirrorHostgroup,
+				MirrorFlagOUT:          &flagOut,
+			},
+			{
+				ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{Username: "reporting", Active: 1, MatchDigest: "^SELECT .* FROM totals", DestinationHostgroup: 5, Apply: 1, Comment: "query_cache"},
+				CacheTTL:               &cacheTTL,
+			},
+			{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{Username: "orders", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := mustRun(t, srv, "dbs", "export", "-no-imports")
+	want := `  query_cache {
+    size_mb = 512
+    rule {
+      match_digest          = "^SELECT .* FROM totals"
+      cache_ttl             = 60000
+      username              = "reporting"
+      destination_hostgroup = 5
+    }
+  }
+
+  mirror {
+    hostgroup_id  = 20
+    match_digests = ["^SELECT .* FROM orders"]
+    flag_out      = 3
+    servers {
+      name       = "orders-shadow"
+      ip_address = "10.0.0.21"
+    }
+  }
+
+  query_rules {
+    username              = "orders"
+    active                = 1
+    match_digest          = ".*"
+    destination_hostgroup = 5
+    apply                 = 1
+  }
+}
+`
+	if !strings.HasSuffix(out, want) || strings.Contains(out, "hostgroup {") || strings.Contains(out, "mysql_variables") {
+		t.Fatalf("expected the export to end with\n%s\ngot\n%s", want, out)
+	}
+}
+
+func TestImportCnf(t *testing.T) {
+	cnf := `
+mysql_servers = (
+  { address = "10.0.0.2", hostgroup = 5 },
+  { address = "10.0.0.3", hostgroup = 10, comment = "orders-replica" },
+  { address = "10.0.0.4", hostgroup = 20, comment = "orders-analytics" }
+)
+mysql_replication_hostgroups = ( { writer_hostgroup = 5, reader_hostgroup = 10 } )
+mysql_users = (
+  { username = "orders", password = "secret", default_hostgroup = 5 },
+  { username = "reporting", password = "hunter2", default_hostgroup = 5 }
+)
+mysql_query_rules = (
+  { rule_id = 1, username = "orders", active = 1, match_digest = "^SELECT", destination_hostgroup = 10, apply = 0 },
+  { rule_id = 2, username = "orders", active = 1, match_digest = "^SELECT .* FROM reports", destination_hostgroup = 20, apply = 1 }
+)
+`
+	// import-cnf doesn't need chester-api, so no host or credentials are set
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := run([]string{"dbs", "import-cnf", "-", "-name", "orders=orders-db", "-o", "json"},
+		strings.NewReader(cnf), stdout, stderr, func(string) string { return "" })
+	if code != 0 {
+		t.Fatalf("import-cnf exited %d: %s", code, stderr)
+	}
+	imported := struct {
+		Databases []models.AddDatabaseRequest `json:"databases"`
+		Users     []models.ProxySqlMySqlUser  `json:"users"`
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &imported); err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Databases) != 1 || imported.Databases[0].InstanceName != "orders-db" || imported.Databases[0].ReadReplicas[0].Name != "orders-replica" {
+		t.Fatalf("unexpected databases %+v", imported.Databases)
+	}
+	if len(imported.Users) != 1 || imported.Users[0].InstanceGroup != "orders-db" {
+		t.Fatalf("unexpected users %+v", imported.Users)
+	}
+
+	srv := apitest.NewServer()
+	defer srv.Close()
+	out, stderrOut, code := runCLI(t, srv, cnf, "dbs", "import-cnf", "-", "-hcl")
+	if code != 0 {
+		t.Fatalf("import-cnf -hcl exited %d: %s", code, stderrOut)
+	}
+	for _, want := range []string{
+		`resource "chester_database" "orders"`,
+		"read_hostgroup        = 10",
+		"write_hostgroup       = 5",
+		`name       = "orders-replica"`,
+		"hostgroup_id    = 20",
+		`check_type = "read_only"`,
+		`name       = "orders-analytics"`,
+		"# chesterctl users add reporting -instance-group orders",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "import {") || strings.Contains(out, "secret") {
+		t.Fatalf("unexpected import blocks or passwords in\n%s", out)
+	}
+}
+
+func TestLint(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.PutDatabase(models.InstanceData{
+		InstanceName:   "foo",
+		Username:       "foo",
+		WriteHostGroup: 5,
+		ReadHostGroup:  10,
+		QueryRules: []models.ProxySqlMySqlQueryRule{
+			{RuleID: 1, Username: "foo", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1},
+			{RuleID: 2, Username: "foo", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1},
+			{RuleID: 3, Username: "foo", Active: 1, MatchDigest: "^DELETE", DestinationHostgroup: 5, Apply: 1},
+		},
+	})
+	out, stderr, code := runCLI(t, srv, "", "lint", "foo")
+	if code != 1 || !strings.Contains(out, "query_rules.2 (rule_id 3): never matches") || !strings.Contains(stderr, "found 1 problems") {
+		t.Fatalf("expected the shadowed rule to be reported, got %d %q %q", code, out, stderr)
+	}
+	srv.UpdateDatabase("foo", func(db *models.InstanceData) {
+		db.QueryRules = db.QueryRules[:2]
+	})
+	if out := mustRun(t, srv, "rules", "lint", "foo"); !strings.Contains(out, "no problems found") {
+		t.Fatalf("unexpected lint output %q", out)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+// printer writes command output in the format picked with -output.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case formatTable, formatJSON, formatYAML:
+		return &printer{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %s, expected table, json or yaml", format)
+}
+
+// print writes v as json or yaml, or calls table to write it as a table.
+// The yaml keys are the json keys chester-api uses.
+func (p *printer) print(v interface{}, table func(tw *tabwriter.Writer)) error {
+	switch p.format {
+	case formatJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	case formatYAML:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		var generic interface{}
+		if err := decoder.Decode(&generic); err != nil {
+			return err
+		}
+		encoder := yaml.NewEncoder(p.w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(yamlValue(generic)); err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// message writes a confirmation of a change. It's only written for table
+// output, so json and yaml output stays parsable.
+func (p *printer) message(format string, args ...interface{}) {
+	if p.format == formatTable {
+		fmt.Fprintf(p.w, format+"\n", args...)
+	}
+}
+
+// yamlValue converts a value decoded from json with UseNumber, so the
+// numbers keep their precision, to one yaml.Marshal writes plainly.
+func yamlValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = yamlValue(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = yamlValue(item)
+		}
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		f, _ := value.Float64()
+		return f
+	}
+	return v
+}
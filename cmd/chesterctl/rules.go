@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+	"github.com/eahrend/terraform-provider-chester/proxysql"
+)
+
+func init() {
+	resources["rules"] = map[string]func() *command{
+		"list":   rulesList,
+		"get":    rulesGet,
+		"add":    rulesAdd,
+		"modify": rulesModify,
+		"remove": rulesRemove,
+		"lint":   rulesLint,
+	}
+}
+
+// printRules writes query rules in the order proxysql applies them.
+func printRules(ctx *cliContext, v interface{}, rules []api.QueryRule) error {
+	return ctx.out.print(v, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "RULE ID\tUSERNAME\tACTIVE\tMATCH DIGEST\tDESTINATION HOSTGROUP\tAPPLY\tCOMMENT")
+		for _, rule := range rules {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%d\t%d\t%s\n",
+				rule.RuleID, rule.Username, rule.Active, rule.MatchDigest, rule.DestinationHostgroup, rule.Apply, rule.Comment)
+		}
+	})
+}
+
+// queryRuleFlags are the flags shared by rules add and rules modify.
+type queryRuleFlags struct {
+	fs                   *flag.FlagSet
+	username             string
+	active               int
+	matchDigest          string
+	destinationHostgroup int
+	apply                int
+	comment              string
+}
+
+func (f *queryRuleFlags) register(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.StringVar(&f.username, "rule-username", "", "user the rule applies to")
+	fs.IntVar(&f.active, "active", 1, "1 for active, 0 for inactive")
+	fs.StringVar(&f.matchDigest, "match-digest", "", "regex matched against the query digest")
+	fs.IntVar(&f.destinationHostgroup, "destination-hostgroup", 0, "hostgroup matching queries go to")
+	fs.IntVar(&f.apply, "apply", 1, "1 stops evaluating rules after a match")
+	fs.StringVar(&f.comment, "comment", "", "comment")
+}
+
+// applyTo sets every flag that was passed on rule.
+func (f *queryRuleFlags) applyTo(rule *api.QueryRule) {
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "rule-username":
+			rule.Username = f.username
+		case "active":
+			rule.Active = f.active
+		case "match-digest":
+			rule.MatchDigest = f.matchDigest
+		case "destination-hostgroup":
+			rule.DestinationHostgroup = f.destinationHostgroup
+		case "apply":
+			rule.Apply = f.apply
+		case "comment":
+			rule.Comment = f.comment
+		}
+	})
+}
+
+// findRule looks up a rule by id in an instance group, it also returns
+// the revision of the instance group.
+func findRule(ctx *cliContext, instanceGroup, id string) (api.QueryRule, string, error) {
+	ruleID, err := strconv.Atoi(id)
+	if err != nil {
+		return api.QueryRule{}, "", fmt.Errorf("rule id %s isn't a number", id)
+	}
+	db, revision, err := ctx.client.GetInstanceGroup(instanceGroup)
+	if err != nil {
+		return api.QueryRule{}, "", err
+	}
+	for _, rule := range db.QueryRules {
+		if rule.RuleID == ruleID {
+			return rule, revision, nil
+		}
+	}
+	return api.QueryRule{}, "", fmt.Errorf("instance group %s has no query rule %d", instanceGroup, ruleID)
+}
+
+func rulesList() *command {
+	return &command{
+		args: "<instance-group>",
+		help: "list the query rules of an instance group",
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			db, _, err := ctx.client.GetInstanceGroup(args[0])
+			if err != nil {
+				return err
+			}
+			rules := db.QueryRules
+			if rules == nil {
+				rules = []api.QueryRule{}
+			}
+			return printRules(ctx, rules, rules)
+		},
+	}
+}
+
+func rulesGet() *command {
+	return &command{
+		args: "<instance-group> <rule-id>",
+		help: "show a query rule",
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 2); err != nil {
+				return err
+			}
+			rule, _, err := findRule(ctx, args[0], args[1])
+			if err != nil {
+				return err
+			}
+			return printRules(ctx, rule, []api.QueryRule{rule})
+		},
+	}
+}
+
+func rulesAdd() *command {
+	f := &queryRuleFlags{}
+	return &command{
+		args: "<instance-group>",
+		help: "add a query rule after the existing ones",
+		flags: func(fs *flag.FlagSet) {
+			f.register(fs)
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			db, revision, err := ctx.client.GetDatabaseRevision(args[0])
+			if err != nil {
+				return err
+			}
+			rule := api.QueryRule{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{
+				Username:             db.Username,
+				Active:               1,
+				DestinationHostgroup: db.WriteHostGroup,
+				Apply:                1,
+			}}
+			f.applyTo(&rule)
+			if rule.MatchDigest == "" {
+				return fmt.Errorf("-match-digest is required")
+			}
+			err = ctx.client.ModifyInstanceGroup(api.ModifyDatabaseRequest{
+				ModifyDatabaseRequest: models.ModifyDatabaseRequest{
+					Action:       "modify",
+					InstanceName: args[0],
+				},
+				AddQueryRules: []api.QueryRule{rule},
+			}, api.IfMatch(revision))
+			if err != nil {
+				return err
+			}
+			ctx.out.message("added query rule %q to instance group %s", rule.MatchDigest, args[0])
+			return nil
+		},
+	}
+}
+
+func rulesModify() *command {
+	f := &queryRuleFlags{}
+	return &command{
+		args: "<instance-group> <rule-id>",
+		help: "change a query rule",
+		flags: func(fs *flag.FlagSet) {
+			f.register(fs)
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 2); err != nil {
+				return err
+			}
+			rule, revision, err := findRule(ctx, args[0], args[1])
+			if err != nil {
+				return err
+			}
+			f.applyTo(&rule)
+			if err := ctx.client.ModifyQueryRule(rule, api.IfMatch(revision)); err != nil {
+				return err
+			}
+			ctx.out.message("modified query rule %d of instance group %s", rule.RuleID, args[0])
+			return nil
+		},
+	}
+}
+
+func rulesRemove() *command {
+	return &command{
+		args: "<instance-group> <rule-id>",
+		help: "remove a query rule",
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 2); err != nil {
+				return err
+			}
+			rule, revision, err := findRule(ctx, args[0], args[1])
+			if err != nil {
+				return err
+			}
+			err = ctx.client.ModifyDatabase(models.ModifyDatabaseRequest{
+				Action:           "modify",
+				InstanceName:     args[0],
+				RemoveQueryRules: []int{rule.RuleID},
+			}, api.IfMatch(revision))
+			if err != nil {
+				return err
+			}
+			ctx.out.message("removed query rule %d from instance group %s", rule.RuleID, args[0])
+			return nil
+		},
+	}
+}
+
+func rulesLint() *command {
+	return &command{
+		args: "<instance-group>",
+		help: "check query rules for shadowed rules, duplicate ids and bad destinations",
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			db, _, err := ctx.client.GetInstanceGroup(args[0])
+			if err != nil {
+				return err
+			}
+			rules := append([]api.QueryRule{}, db.QueryRules...)
+			sort.SliceStable(rules, func(i, j int) bool {
+				return rules[i].RuleID < rules[j].RuleID
+			})
+			hostgroups := []int{db.ReadHostGroup}
+			for _, hostgroup := range db.Hostgroups {
+				hostgroups = append(hostgroups, hostgroup.HostgroupID)
+			}
+			findings := proxysql.Lint(rules, proxysql.LintOptions{
+				WriteHostgroup: db.WriteHostGroup,
+				Hostgroups:     hostgroups,
+			})
+			messages := make([]string, len(findings))
+			for i, finding := range findings {
+				messages[i] = finding.String()
+			}
+			err = ctx.out.print(messages, func(tw *tabwriter.Writer) {
+				for _, message := range messages {
+					fmt.Fprintln(tw, message)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if len(findings) > 0 {
+				return fmt.Errorf("found %d problems in the query rules of %s", len(findings), args[0])
+			}
+			ctx.out.message("no problems found in the query rules of %s", args[0])
+			return nil
+		},
+	}
+}
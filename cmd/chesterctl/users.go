@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+func init() {
+	resources["users"] = map[string]func() *command{
+		"list":   usersList,
+		"get":    usersGet,
+		"add":    usersAdd,
+		"modify": usersModify,
+		"remove": usersRemove,
+	}
+}
+
+// printUsers writes proxysql users, passwords are left out of the table.
+func printUsers(ctx *cliContext, v interface{}, users []models.ProxySqlMySqlUser) error {
+	return ctx.out.print(v, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "USERNAME\tINSTANCE GROUP\tDEFAULT HOSTGROUP\tACTIVE")
+		for _, user := range users {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", user.Username, user.InstanceGroup, user.DefaultHostgroup, user.Active)
+		}
+	})
+}
+
+func usersList() *command {
+	var instanceGroup string
+	return &command{
+		help: "list the user of every instance group",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&instanceGroup, "instance-group", "", "only list the user of this instance group")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 0); err != nil {
+				return err
+			}
+			// chester-api can't list users, but every instance group
+			// carries its own user
+			var dbs []api.InstanceData
+			if instanceGroup != "" {
+				db, _, err := ctx.client.GetInstanceGroup(instanceGroup)
+				if err != nil {
+					return err
+				}
+				dbs = []api.InstanceData{db}
+			} else {
+				var err error
+				dbs, err = ctx.client.ListAllDatabases(ctx.ctx, api.ListOptions{Filter: true})
+				if err != nil {
+					return err
+				}
+			}
+			users := []models.ProxySqlMySqlUser{}
+			for _, db := range dbs {
+				user, err := ctx.client.GetUser(db.Username)
+				if err != nil {
+					return fmt.Errorf("failed to get user %s of instance group %s: %s", db.Username, db.InstanceName, err.Error())
+				}
+				users = append(users, user)
+			}
+			return printUsers(ctx, users, users)
+		},
+	}
+}
+
+func usersGet() *command {
+	return &command{
+		args: "<username>",
+		help: "show a proxysql user",
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			user, err := ctx.client.GetUser(args[0])
+			if err != nil {
+				return err
+			}
+			return printUsers(ctx, user, []models.ProxySqlMySqlUser{user})
+		},
+	}
+}
+
+func usersAdd() *command {
+	var password, instanceGroup string
+	var defaultHostgroup, active int
+	return &command{
+		args: "<username>",
+		help: "add a proxysql user to an instance group",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&password, "user-password", "", "password of the user")
+			fs.StringVar(&instanceGroup, "instance-group", "", "instance group the user connects to")
+			fs.IntVar(&defaultHostgroup, "default-hostgroup", 0, "hostgroup queries go to without a matching query rule")
+			fs.IntVar(&active, "active", 1, "1 for active, 0 for inactive")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			if password == "" || instanceGroup == "" || defaultHostgroup == 0 {
+				return fmt.Errorf("-user-password, -instance-group and -default-hostgroup are required")
+			}
+			err := ctx.client.CreateUser(models.ProxySqlMySqlUser{
+				Username:         args[0],
+				Password:         password,
+				DefaultHostgroup: defaultHostgroup,
+				Active:           active,
+				InstanceGroup:    instanceGroup,
+			})
+			if err != nil {
+				return err
+			}
+			ctx.out.message("added user %s to instance group %s", args[0], instanceGroup)
+			return nil
+		},
+	}
+}
+
+func usersModify() *command {
+	var newUsername, password, instanceGroup string
+	var defaultHostgroup int
+	return &command{
+		args: "<username>",
+		help: "change a proxysql user",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&newUsername, "new-username", "", "rename the user")
+			fs.StringVar(&password, "user-password", "", "new password")
+			fs.StringVar(&instanceGroup, "instance-group", "", "instance group of the user")
+			fs.IntVar(&defaultHostgroup, "default-hostgroup", 0, "new default hostgroup")
+		},
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			if newUsername == "" && password == "" && defaultHostgroup == 0 {
+				return fmt.Errorf("nothing to change, set at least one of -new-username, -user-password or -default-hostgroup")
+			}
+			err := ctx.client.ModifyUser(models.ModifyUserRequest{
+				Action:           "modify",
+				Username:         args[0],
+				NewUsername:      newUsername,
+				Password:         password,
+				InstanceGroup:    instanceGroup,
+				DefaultHostgroup: defaultHostgroup,
+			})
+			if err != nil {
+				return err
+			}
+			ctx.out.message("modified user %s", args[0])
+			return nil
+		},
+	}
+}
+
+func usersRemove() *command {
+	return &command{
+		args: "<username>",
+		help: "remove a proxysql user",
+		run: func(ctx *cliContext, args []string) error {
+			if err := wantArgs(args, 1); err != nil {
+				return err
+			}
+			if err := ctx.client.DeleteUser(args[0]); err != nil {
+				return err
+			}
+			ctx.out.message("removed user %s", args[0])
+			return nil
+		},
+	}
+}
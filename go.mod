@@ -5,7 +5,11 @@ go 1.16
 require (
 	cloud.google.com/go/kms v1.1.0 // indirect
 	github.com/eahrend/chestermodels v0.0.0-20211021142845-bad2997247ea
+	github.com/hashicorp/go-cty v1.4.1-0.20200414143053-d3edf31b6320
+	github.com/hashicorp/hcl/v2 v2.3.0
 	github.com/hashicorp/terraform-plugin-sdk/v2 v2.8.0
+	github.com/zclconf/go-cty v1.8.4
 	golang.org/x/oauth2 v0.0.0-20211005180243-6b3c2da341f1
 	google.golang.org/api v0.59.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
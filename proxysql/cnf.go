@@ -0,0 +1,332 @@
+package proxysql
+
+import (
+	"fmt"
+	"sort"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+// Config is the mysql_servers, mysql_replication_hostgroups, mysql_users
+// and mysql_query_rules sections of a proxysql.cnf file, the other
+// sections aren't managed by chester.
+type Config struct {
+	Servers               []models.ProxySqlMySqlServer
+	ReplicationHostgroups []api.ReplicationHostgroup
+	Users                 []models.ProxySqlMySqlUser
+	QueryRules            []api.QueryRule
+}
+
+// ParseConfig parses a proxysql.cnf file. Missing fields get the defaults
+// proxysql gives them, and query rules are sorted by rule_id, the order
+// proxysql applies them in.
+func ParseConfig(src []byte) (*Config, error) {
+	root, err := ParseLibConfig(src)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	servers, err := sectionGroups(root, "mysql_servers")
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range servers {
+		server := models.ProxySqlMySqlServer{Port: 3306, MaxConnections: 1000}
+		if err := g.Decode(&server); err != nil {
+			return nil, fmt.Errorf("mysql_servers: %s", err.Error())
+		}
+		if server.Address == "" {
+			return nil, fmt.Errorf("mysql_servers: line %d: address is required", g.line())
+		}
+		config.Servers = append(config.Servers, server)
+	}
+	pairs, err := sectionGroups(root, "mysql_replication_hostgroups")
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range pairs {
+		pair := api.ReplicationHostgroup{CheckType: "read_only"}
+		if err := g.Decode(&pair); err != nil {
+			return nil, fmt.Errorf("mysql_replication_hostgroups: %s", err.Error())
+		}
+		for _, column := range []string{"writer_hostgroup", "reader_hostgroup"} {
+			if _, ok := g.Lookup(column); !ok {
+				return nil, fmt.Errorf("mysql_replication_hostgroups: line %d: %s is required", g.line(), column)
+			}
+		}
+		config.ReplicationHostgroups = append(config.ReplicationHostgroups, pair)
+	}
+	users, err := sectionGroups(root, "mysql_users")
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range users {
+		user := models.ProxySqlMySqlUser{Active: 1}
+		if err := g.Decode(&user); err != nil {
+			return nil, fmt.Errorf("mysql_users: %s", err.Error())
+		}
+		if user.Username == "" {
+			return nil, fmt.Errorf("mysql_users: line %d: username is required", g.line())
+		}
+		config.Users = append(config.Users, user)
+	}
+	rules, err := sectionGroups(root, "mysql_query_rules")
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{}
+	for _, g := range rules {
+		if _, ok := g.Lookup("rule_id"); !ok {
+			return nil, fmt.Errorf("mysql_query_rules: line %d: rule_id is required", g.line())
+		}
+		rule := api.QueryRule{}
+		if err := g.Decode(&rule); err != nil {
+			return nil, fmt.Errorf("mysql_query_rules: %s", err.Error())
+		}
+		if seen[rule.RuleID] {
+			return nil, fmt.Errorf("mysql_query_rules: line %d: duplicate rule_id %d", g.line(), rule.RuleID)
+		}
+		seen[rule.RuleID] = true
+		config.QueryRules = append(config.QueryRules, rule)
+	}
+	sort.SliceStable(config.QueryRules, func(i, j int) bool {
+		return config.QueryRules[i].RuleID < config.QueryRules[j].RuleID
+	})
+	return config, nil
+}
+
+// line is the line of the first setting of a group.
+func (g Group) line() int {
+	if len(g) == 0 {
+		return 0
+	}
+	return g[0].Line
+}
+
+// sectionGroups returns the groups in the list called name, a missing
+// section is empty.
+func sectionGroups(root Group, name string) ([]Group, error) {
+	setting, ok := root.Lookup(name)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := setting.Value.(List)
+	if !ok {
+		return nil, fmt.Errorf("line %d: %s must be a list", setting.Line, name)
+	}
+	groups := make([]Group, len(list))
+	for i, item := range list {
+		g, ok := item.(Group)
+		if !ok {
+			return nil, fmt.Errorf("line %d: every entry of %s must be a group", setting.Line, name)
+		}
+		groups[i] = g
+	}
+	return groups, nil
+}
+
+// ImportOptions tunes how a Config is turned into add requests.
+type ImportOptions struct {
+	// InstanceNames maps a mysql user to the name of its instance group,
+	// it defaults to the username.
+	InstanceNames map[string]string
+	// MaxChesterInstances is set on every instance group.
+	MaxChesterInstances int
+}
+
+// Import is what a proxysql.cnf file becomes in chester.
+type Import struct {
+	// Databases has an add request for every user whose default hostgroup
+	// isn't already covered by an earlier user.
+	Databases []api.AddDatabaseRequest
+	// Users are the users sharing a default hostgroup with an earlier
+	// user, they're added to that user's instance group.
+	Users []models.ProxySqlMySqlUser
+	// Hostgroups are the hostgroups of every instance group in the file,
+	// by instance group name. chester-api picks its own, but the
+	// chester_database resource needs them.
+	Hostgroups map[string]Hostgroups
+}
+
+// Hostgroups are the writer and read replica hostgroups of an instance
+// group, Read is Write when no query rule sends queries elsewhere.
+type Hostgroups struct {
+	Write int
+	Read  int
+}
+
+// Import converts the config into chester instance groups. The writer is
+// the server in the user's default hostgroup, the read replicas are the
+// servers in the reader hostgroup of the replication hostgroup with the
+// user's default hostgroup as writer, or else in the first other hostgroup
+// the user's query rules send queries to. The other hostgroups the rules
+// send or mirror queries to are the instance group's extra hostgroups.
+// A server's comment is
+// used as its instance name, otherwise the writer is named after the
+// instance group and the other servers are numbered.
+func (c *Config) Import(opts ImportOptions) (*Import, error) {
+	imported := &Import{
+		Databases:  []api.AddDatabaseRequest{},
+		Users:      []models.ProxySqlMySqlUser{},
+		Hostgroups: map[string]Hostgroups{},
+	}
+	owners := map[int]string{}
+	for _, user := range c.Users {
+		if owner, ok := owners[user.DefaultHostgroup]; ok {
+			user.InstanceGroup = owner
+			imported.Users = append(imported.Users, user)
+			continue
+		}
+		name := user.Username
+		if opts.InstanceNames[user.Username] != "" {
+			name = opts.InstanceNames[user.Username]
+		}
+		req, hostgroups, err := c.addRequest(name, user, opts)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %s", user.Username, err.Error())
+		}
+		owners[user.DefaultHostgroup] = name
+		imported.Databases = append(imported.Databases, req)
+		imported.Hostgroups[name] = hostgroups
+	}
+	return imported, nil
+}
+
+// addRequest builds the add request of the instance group owned by user.
+func (c *Config) addRequest(name string, user models.ProxySqlMySqlUser, opts ImportOptions) (api.AddDatabaseRequest, Hostgroups, error) {
+	hostgroups := Hostgroups{Write: user.DefaultHostgroup, Read: user.DefaultHostgroup}
+	writers := c.hostgroupServers(user.DefaultHostgroup)
+	if len(writers) == 0 {
+		return api.AddDatabaseRequest{}, hostgroups, fmt.Errorf("no server in default hostgroup %d", user.DefaultHostgroup)
+	}
+	if len(writers) > 1 {
+		return api.AddDatabaseRequest{}, hostgroups, fmt.Errorf("%d servers in default hostgroup %d, chester has a single writer", len(writers), user.DefaultHostgroup)
+	}
+	rules := []api.QueryRule{}
+	readHostgroups := []int{}
+	for _, rule := range c.QueryRules {
+		// rules without a username apply to every user
+		if rule.Username != "" && rule.Username != user.Username {
+			continue
+		}
+		if rule.DestinationHostgroup != user.DefaultHostgroup && !containsInt(readHostgroups, rule.DestinationHostgroup) {
+			readHostgroups = append(readHostgroups, rule.DestinationHostgroup)
+		}
+		if rule.MirrorHostgroup != nil && *rule.MirrorHostgroup != user.DefaultHostgroup && !containsInt(readHostgroups, *rule.MirrorHostgroup) {
+			readHostgroups = append(readHostgroups, *rule.MirrorHostgroup)
+		}
+		// chester assigns its own rule ids
+		rule.RuleID = 0
+		rule.Username = user.Username
+		rules = append(rules, rule)
+	}
+	// the reader hostgroup paired with the writer is the read hostgroup,
+	// the writer is left out of it when it's also a reader
+	pairs := []api.ReplicationHostgroup{}
+	for _, pair := range c.ReplicationHostgroups {
+		if pair.WriterHostgroup == user.DefaultHostgroup {
+			pairs = append(pairs, pair)
+			readHostgroups = append([]int{pair.ReaderHostgroup}, removeInt(readHostgroups, pair.ReaderHostgroup)...)
+			break
+		}
+	}
+	replicas := []models.AddDatabaseRequestDatabaseInformation{}
+	if len(readHostgroups) > 0 {
+		hostgroups.Read = readHostgroups[0]
+		readers := []models.ProxySqlMySqlServer{}
+		for _, server := range c.hostgroupServers(readHostgroups[0]) {
+			if server.Address != writers[0].Address {
+				readers = append(readers, server)
+			}
+		}
+		replicas = serverInformation(readers, name+"-read")
+		readHostgroups = readHostgroups[1:]
+	}
+	extra := []api.Hostgroup{}
+	for _, id := range readHostgroups {
+		servers := c.hostgroupServers(id)
+		if len(servers) == 0 {
+			return api.AddDatabaseRequest{}, hostgroups, fmt.Errorf("query rules send queries to hostgroup %d, which has no servers", id)
+		}
+		extra = append(extra, api.Hostgroup{
+			HostgroupID:    id,
+			Servers:        serverInformation(servers, fmt.Sprintf("%s-hostgroup-%d", name, id)),
+			MaxConnections: int(servers[0].MaxConnections),
+		})
+	}
+	master := models.AddDatabaseRequestDatabaseInformation{Name: name, IPAddress: writers[0].Address}
+	if writers[0].Comment != "" {
+		master.Name = writers[0].Comment
+	}
+	req := api.AddDatabaseRequest{AddDatabaseRequest: models.AddDatabaseRequest{
+		Action:         "add",
+		InstanceName:   name,
+		Username:       user.Username,
+		Password:       user.Password,
+		MasterInstance: master,
+		ReadReplicas:   replicas,
+		EnableSSL:      writers[0].UseSSL,
+		ChesterMetaData: models.ChesterMetaData{
+			InstanceGroup:       name,
+			MaxChesterInstances: opts.MaxChesterInstances,
+		},
+	}}
+	if len(rules) > 0 {
+		req.QueryRules = rules
+	}
+	if len(extra) > 0 {
+		req.Hostgroups = extra
+	}
+	if len(pairs) > 0 {
+		req.ReplicationHostgroups = pairs
+	}
+	return req, hostgroups, nil
+}
+
+// serverInformation names servers after their comment, or numbers them
+// after prefix when they have none.
+func serverInformation(servers []models.ProxySqlMySqlServer, prefix string) []models.AddDatabaseRequestDatabaseInformation {
+	infos := make([]models.AddDatabaseRequestDatabaseInformation, len(servers))
+	for i, server := range servers {
+		infos[i] = models.AddDatabaseRequestDatabaseInformation{
+			Name:      fmt.Sprintf("%s-%d", prefix, i+1),
+			IPAddress: server.Address,
+		}
+		if server.Comment != "" {
+			infos[i].Name = server.Comment
+		}
+	}
+	return infos
+}
+
+// hostgroupServers returns the servers in a hostgroup, in file order.
+func (c *Config) hostgroupServers(hostgroup int) []models.ProxySqlMySqlServer {
+	servers := []models.ProxySqlMySqlServer{}
+	for _, server := range c.Servers {
+		if server.Hostgroup == hostgroup {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// removeInt returns s without n.
+func removeInt(s []int, n int) []int {
+	removed := []int{}
+	for _, v := range s {
+		if v != n {
+			removed = append(removed, v)
+		}
+	}
+	return removed
+}
+
+func containsInt(s []int, n int) bool {
+	for _, v := range s {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
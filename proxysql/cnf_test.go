@@ -0,0 +1,233 @@
+package proxysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+// sampleCnf is the configmap of the proxysql helm chart with its values
+// filled in.
+const sampleCnf = `
+datadir="/var/lib/proxysql"
+admin_variables=
+{
+  admin_credentials="proxysql-admin:adminpassw0rd"
+  mysql_ifaces="0.0.0.0:6032"
+  refresh_interval=2000
+}
+mysql_variables=
+{
+  threads=4
+  have_compress=true
+  interfaces="0.0.0.0:6033;/tmp/proxysql.sock"
+}
+mysql_servers=
+(
+  { address="10.0.0.2" , port=3306 , hostgroup=5, max_connections=1000, use_ssl=0 },
+  { address="10.0.0.3" , port=3306 , hostgroup=10, max_connections=1000, use_ssl=0 },
+  { address="10.0.0.4" , port=3306 , hostgroup=10, max_connections=1000, use_ssl=0, comment="orders-read-b" },
+  { address="10.0.0.5" , port=3306 , hostgroup=20, max_connections=50, use_ssl=0 }
+)
+mysql_users=
+(
+  { username = "orders" , password = "secret" , default_hostgroup = 5 , active = 1 },
+  { username = "reporting" , password = "hunter2" , default_hostgroup = 5 }
+)
+mysql_query_rules=
+(
+  { rule_id = "1" , username="orders" , active=1 , match_digest="^SELECT .* FOR UPDATE" , destination_hostgroup=5 , apply=1, comment="select for update goes to the writer" },
+  { rule_id = "3" , username="orders" , active=1 , match_digest=".*" , destination_hostgroup=5 , apply=1, comment="catch all to writer" },
+  { rule_id = "2" , username="orders" , active=1 , match_digest="^SELECT" , destination_hostgroup=10 , apply=1, comment="selects go to the reader" },
+  { rule_id = "4" , username="orders" , active=1 , match_digest="^SELECT .* FROM reports" , destination_hostgroup=20 , apply=1, comment="reports go to analytics" }
+)
+`
+
+func TestParseLibConfig(t *testing.T) {
+	src := `
+# comment
+// another comment
+/* a
+   block comment */
+name = "a" "b";
+count: 0x10L
+ratio = 1.5e2,
+enabled = TRUE
+nested = { list = ( 1, "two", [ 3, 4 ] ); empty = () }
+escaped = "say \"hi\"\n"
+`
+	got, err := ParseLibConfig([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Group{
+		{Name: "name", Value: "ab", Line: 6},
+		{Name: "count", Value: int64(16), Line: 7},
+		{Name: "ratio", Value: 150.0, Line: 8},
+		{Name: "enabled", Value: true, Line: 9},
+		{Name: "nested", Value: Group{
+			{Name: "list", Value: List{int64(1), "two", List{int64(3), int64(4)}}, Line: 10},
+			{Name: "empty", Value: List{}, Line: 10},
+		}, Line: 10},
+		{Name: "escaped", Value: "say \"hi\"\n", Line: 11},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected\n%#v\ngot\n%#v", want, got)
+	}
+}
+
+func TestParseLibConfig_Errors(t *testing.T) {
+	tests := map[string]string{
+		"a = ":               "line 1: expected a value, got end of file",
+		"a = 1\nb 2":         "line 2: expected = after b, got 2",
+		"a = { b = 1":        "line 1: expected a setting name, got end of file",
+		"a = ( 1 2 )":        "line 1: expected , or ), got 2",
+		"a = 1\na = 2":       "line 2: duplicate setting a",
+		"a = \"open":         "line 1: unterminated string",
+		"a = 1 /* open":      "line 1: unterminated comment",
+		"a = 12abc":          "line 1: invalid integer 12abc",
+		"a = ( { b = 1 }; )": "line 1: expected , or ), got ;",
+	}
+	for src, want := range tests {
+		if _, err := ParseLibConfig([]byte(src)); err == nil || err.Error() != want {
+			t.Errorf("%q: expected error %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(sampleCnf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Servers) != 4 || config.Servers[2].Comment != "orders-read-b" || config.Servers[1].Hostgroup != 10 {
+		t.Fatalf("unexpected servers %+v", config.Servers)
+	}
+	// active defaults to 1 for users
+	if len(config.Users) != 2 || config.Users[1].Active != 1 || config.Users[1].Password != "hunter2" {
+		t.Fatalf("unexpected users %+v", config.Users)
+	}
+	ids := []int{}
+	for _, rule := range config.QueryRules {
+		ids = append(ids, rule.RuleID)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3, 4}) {
+		t.Fatalf("expected query rules sorted by rule_id, got %v", ids)
+	}
+	if rule := config.QueryRules[0]; rule.FlagOUT != nil || rule.CacheTTL != nil || rule.MatchPattern != "" {
+		t.Fatalf("expected missing columns to stay NULL, got %+v", rule)
+	}
+
+	config, err = ParseConfig([]byte(`mysql_query_rules = ( { rule_id = 1, match_pattern = "^DELETE", negate_match_pattern = true, flagIN = 1, flagOUT = 0, cache_ttl = "100", error_msg = "no" } )`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := config.QueryRules[0]
+	if rule.MatchPattern != "^DELETE" || rule.NegateMatchPattern != 1 || rule.FlagIN != 1 || rule.FlagOUT == nil || *rule.FlagOUT != 0 ||
+		rule.CacheTTL == nil || *rule.CacheTTL != 100 || rule.ErrorMsg != "no" || rule.Timeout != nil {
+		t.Fatalf("unexpected query rule %+v", rule)
+	}
+
+	invalid := map[string]string{
+		`mysql_servers = ( { port = 3306 } )`:                      "mysql_servers: line 1: address is required",
+		`mysql_servers = ( { address = "a", port = "x" } )`:        `mysql_servers: line 1: port must be an integer, got "x"`,
+		`mysql_users = { username = "a" }`:                         "line 1: mysql_users must be a list",
+		`mysql_query_rules = ( { active = 1 } )`:                   "mysql_query_rules: line 1: rule_id is required",
+		`mysql_query_rules = ( { rule_id = 1 }, { rule_id = 1 } )`: "mysql_query_rules: line 1: duplicate rule_id 1",
+	}
+	for src, want := range invalid {
+		if _, err := ParseConfig([]byte(src)); err == nil || err.Error() != want {
+			t.Errorf("%q: expected error %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	config, err := ParseConfig([]byte(sampleCnf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := config.Import(ImportOptions{
+		InstanceNames:       map[string]string{"orders": "orders-db"},
+		MaxChesterInstances: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []api.AddDatabaseRequest{{AddDatabaseRequest: models.AddDatabaseRequest{
+		Action:         "add",
+		InstanceName:   "orders-db",
+		Username:       "orders",
+		Password:       "secret",
+		MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "orders-db", IPAddress: "10.0.0.2"},
+		ReadReplicas: []models.AddDatabaseRequestDatabaseInformation{
+			{Name: "orders-db-read-1", IPAddress: "10.0.0.3"},
+			{Name: "orders-read-b", IPAddress: "10.0.0.4"},
+		},
+		ChesterMetaData: models.ChesterMetaData{InstanceGroup: "orders-db", MaxChesterInstances: 3},
+	}, QueryRules: api.NewQueryRules([]models.ProxySqlMySqlQueryRule{
+		{Username: "orders", Active: 1, MatchDigest: "^SELECT .* FOR UPDATE", DestinationHostgroup: 5, Apply: 1, Comment: "select for update goes to the writer"},
+		{Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1, Comment: "selects go to the reader"},
+		{Username: "orders", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1, Comment: "catch all to writer"},
+		{Username: "orders", Active: 1, MatchDigest: "^SELECT .* FROM reports", DestinationHostgroup: 20, Apply: 1, Comment: "reports go to analytics"},
+	}), Hostgroups: []api.Hostgroup{{
+		HostgroupID:    20,
+		Servers:        []models.AddDatabaseRequestDatabaseInformation{{Name: "orders-db-hostgroup-20-1", IPAddress: "10.0.0.5"}},
+		MaxConnections: 50,
+	}}}}
+	if !reflect.DeepEqual(imported.Databases, want) {
+		t.Fatalf("expected\n%+v\ngot\n%+v", want, imported.Databases)
+	}
+	if hg := imported.Hostgroups["orders-db"]; hg.Write != 5 || hg.Read != 10 {
+		t.Fatalf("unexpected hostgroups %+v", hg)
+	}
+	if len(imported.Users) != 1 || imported.Users[0].Username != "reporting" || imported.Users[0].InstanceGroup != "orders-db" {
+		t.Fatalf("expected reporting to join orders-db, got %+v", imported.Users)
+	}
+
+	twoWriters := `mysql_servers = ( { address = "a", hostgroup = 1 }, { address = "b", hostgroup = 1 } )
+mysql_users = ( { username = "u", password = "p", default_hostgroup = 1 } )`
+	config, err = ParseConfig([]byte(twoWriters))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Import(ImportOptions{}); err == nil || !strings.Contains(err.Error(), "single writer") {
+		t.Fatalf("expected an error for two writers, got %v", err)
+	}
+}
+
+func TestImport_ReplicationHostgroups(t *testing.T) {
+	cnf := `mysql_servers = (
+  { address = "10.0.0.2", hostgroup = 5 },
+  { address = "10.0.0.2", hostgroup = 10 },
+  { address = "10.0.0.3", hostgroup = 10 }
+)
+mysql_replication_hostgroups = ( { writer_hostgroup = 5, reader_hostgroup = 10, check_type = "super_read_only" } )
+mysql_users = ( { username = "orders", password = "secret", default_hostgroup = 5 } )`
+	config, err := ParseConfig([]byte(cnf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := config.Import(ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := imported.Databases[0]
+	want := []api.ReplicationHostgroup{{WriterHostgroup: 5, ReaderHostgroup: 10, CheckType: "super_read_only"}}
+	if !reflect.DeepEqual(req.ReplicationHostgroups, want) {
+		t.Fatalf("expected replication hostgroups %+v, got %+v", want, req.ReplicationHostgroups)
+	}
+	if hg := imported.Hostgroups["orders"]; hg.Write != 5 || hg.Read != 10 {
+		t.Fatalf("expected the reader hostgroup to be the read hostgroup, got %+v", hg)
+	}
+	if len(req.ReadReplicas) != 1 || req.ReadReplicas[0].IPAddress != "10.0.0.3" {
+		t.Fatalf("expected the writer to be left out of the read replicas, got %+v", req.ReadReplicas)
+	}
+
+	if _, err := ParseConfig([]byte(`mysql_replication_hostgroups = ( { writer_hostgroup = 5 } )`)); err == nil || !strings.Contains(err.Error(), "reader_hostgroup is required") {
+		t.Fatalf("expected a missing reader_hostgroup to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,499 @@
+// Package proxysql reads the libconfig style proxysql.cnf files proxysql
+// fleets were configured with before chester.
+package proxysql
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Group is a libconfig group, the settings keep the order of the file.
+type Group []Setting
+
+// List is a libconfig list or array.
+type List []interface{}
+
+// Setting is a single name = value in a group. Value is a string, int64,
+// float64, bool, Group or List.
+type Setting struct {
+	Name  string
+	Value interface{}
+	// Line is where the setting starts, for error messages
+	Line int
+}
+
+// Lookup returns the setting called name, libconfig names are case
+// sensitive.
+func (g Group) Lookup(name string) (Setting, bool) {
+	for _, s := range g {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+// SyntaxError is returned for a file that isn't valid libconfig.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ParseLibConfig parses a libconfig file into its top level group.
+// Comments (#, // and /* */), string concatenation, hex and L suffixed
+// integers are supported, @include directives are not.
+func ParseLibConfig(src []byte) (Group, error) {
+	p := &parser{lex: &lexer{src: src, line: 1}}
+	p.next()
+	settings, err := p.settings(tokEOF)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokString
+	tokInt
+	tokFloat
+	tokBool
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// value is the decoded string, int64, float64 or bool
+	value interface{}
+	line  int
+}
+
+type lexer struct {
+	src  []byte
+	pos  int
+	line int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: l.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skip moves past whitespace and comments.
+func (l *lexer) skip() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			l.pos++
+		case c == '#' || (c == '/' && l.peek(1) == '/'):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '/' && l.peek(1) == '*':
+			start := l.line
+			l.pos += 2
+			for {
+				if l.pos >= len(l.src) {
+					return &SyntaxError{Line: start, Msg: "unterminated comment"}
+				}
+				if l.src[l.pos] == '*' && l.peek(1) == '/' {
+					l.pos += 2
+					break
+				}
+				if l.src[l.pos] == '\n' {
+					l.line++
+				}
+				l.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.pos+n < len(l.src) {
+		return l.src[l.pos+n]
+	}
+	return 0
+}
+
+func isNameStart(c byte) bool {
+	return c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || c == '_' || c == '-' || (c >= '0' && c <= '9')
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skip(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, line: l.line}, nil
+	}
+	line := l.line
+	c := l.src[l.pos]
+	switch {
+	case c == '"':
+		s, err := l.str()
+		if err != nil {
+			return token{}, err
+		}
+		// adjacent strings are concatenated
+		for {
+			if err := l.skip(); err != nil {
+				return token{}, err
+			}
+			if l.pos >= len(l.src) || l.src[l.pos] != '"' {
+				break
+			}
+			more, err := l.str()
+			if err != nil {
+				return token{}, err
+			}
+			s += more
+		}
+		return token{kind: tokString, value: s, line: line}, nil
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+			l.pos++
+		}
+		text := string(l.src[start:l.pos])
+		switch strings.ToLower(text) {
+		case "true":
+			return token{kind: tokBool, text: text, value: true, line: line}, nil
+		case "false":
+			return token{kind: tokBool, text: text, value: false, line: line}, nil
+		}
+		return token{kind: tokName, text: text, line: line}, nil
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		start := l.pos
+		l.pos++
+		for l.pos < len(l.src) && strings.IndexByte("0123456789abcdefABCDEFxX.+-L", l.src[l.pos]) >= 0 {
+			// a sign is only part of the number after an exponent
+			if (l.src[l.pos] == '+' || l.src[l.pos] == '-') && !strings.ContainsAny(string(l.src[l.pos-1]), "eE") {
+				break
+			}
+			l.pos++
+		}
+		return l.number(string(l.src[start:l.pos]), line)
+	case strings.IndexByte("=:;,{}()[]", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, text: string(c), line: line}, nil
+	}
+	return token{}, l.errorf("unexpected character %q", c)
+}
+
+// number decodes an integer or float literal.
+func (l *lexer) number(text string, line int) (token, error) {
+	digits := strings.TrimSuffix(strings.TrimSuffix(text, "L"), "L")
+	isHex := strings.HasPrefix(strings.TrimLeft(digits, "+-"), "0x") || strings.HasPrefix(strings.TrimLeft(digits, "+-"), "0X")
+	if isHex || !strings.ContainsAny(digits, ".eE") {
+		n, err := strconv.ParseInt(digits, 0, 64)
+		if err != nil {
+			return token{}, &SyntaxError{Line: line, Msg: fmt.Sprintf("invalid integer %s", text)}
+		}
+		return token{kind: tokInt, text: text, value: n, line: line}, nil
+	}
+	if digits != text {
+		return token{}, &SyntaxError{Line: line, Msg: fmt.Sprintf("invalid float %s", text)}
+	}
+	f, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return token{}, &SyntaxError{Line: line, Msg: fmt.Sprintf("invalid float %s", text)}
+	}
+	return token{kind: tokFloat, text: text, value: f, line: line}, nil
+}
+
+// str decodes a double quoted string starting at the opening quote.
+func (l *lexer) str() (string, error) {
+	start := l.line
+	l.pos++
+	b := &strings.Builder{}
+	for {
+		if l.pos >= len(l.src) {
+			return "", &SyntaxError{Line: start, Msg: "unterminated string"}
+		}
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return b.String(), nil
+		case '\n':
+			l.line++
+		case '\\':
+			l.pos++
+			switch e := l.peek(0); e {
+			case '\\', '"':
+				b.WriteByte(e)
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'f':
+				b.WriteByte('\f')
+			case 'x':
+				n, err := strconv.ParseUint(string(l.src[l.pos+1:min(l.pos+3, len(l.src))]), 16, 8)
+				if err != nil {
+					return "", l.errorf("invalid \\x escape")
+				}
+				b.WriteByte(byte(n))
+				l.pos += 2
+			default:
+				return "", l.errorf("invalid escape \\%c", e)
+			}
+			l.pos++
+			continue
+		}
+		b.WriteByte(c)
+		l.pos++
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type parser struct {
+	lex *lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.tok.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) is(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == punct
+}
+
+func (p *parser) describe() string {
+	switch p.tok.kind {
+	case tokEOF:
+		return "end of file"
+	case tokString:
+		return strconv.Quote(p.tok.value.(string))
+	}
+	return p.tok.text
+}
+
+// settings parses settings until the end token, which is tokEOF for the
+// top level group and "}" otherwise.
+func (p *parser) settings(end tokenKind) (Group, error) {
+	group := Group{}
+	for {
+		if p.err != nil {
+			return nil, p.err
+		}
+		if end == tokEOF && p.tok.kind == tokEOF {
+			return group, nil
+		}
+		if end != tokEOF && p.is("}") {
+			return group, nil
+		}
+		if p.tok.kind != tokName {
+			return nil, p.errorf("expected a setting name, got %s", p.describe())
+		}
+		setting := Setting{Name: p.tok.text, Line: p.tok.line}
+		if _, ok := group.Lookup(setting.Name); ok {
+			return nil, p.errorf("duplicate setting %s", setting.Name)
+		}
+		p.next()
+		if !p.is("=") && !p.is(":") {
+			return nil, p.errorf("expected = after %s, got %s", setting.Name, p.describe())
+		}
+		p.next()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		setting.Value = value
+		group = append(group, setting)
+		if p.is(";") || p.is(",") {
+			p.next()
+		}
+	}
+}
+
+func (p *parser) value() (interface{}, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch p.tok.kind {
+	case tokString, tokInt, tokFloat, tokBool:
+		v := p.tok.value
+		p.next()
+		return v, p.err
+	}
+	switch {
+	case p.is("{"):
+		p.next()
+		group, err := p.settings(tokPunct)
+		if err != nil {
+			return nil, err
+		}
+		p.next()
+		return group, p.err
+	case p.is("("):
+		return p.list(")")
+	case p.is("["):
+		return p.list("]")
+	}
+	return nil, p.errorf("expected a value, got %s", p.describe())
+}
+
+// list parses the values of a list or array up to the closing token.
+func (p *parser) list(closing string) (List, error) {
+	p.next()
+	list := List{}
+	for {
+		if p.err != nil {
+			return nil, p.err
+		}
+		if p.is(closing) {
+			p.next()
+			return list, p.err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		if p.is(",") {
+			p.next()
+		} else if !p.is(closing) {
+			return nil, p.errorf("expected , or %s, got %s", closing, p.describe())
+		}
+	}
+}
+
+// Decode sets the fields of the struct v points to from the settings of
+// g, matching the libconfig tags of the chestermodels types. Numbers may be
+// quoted, and bools and 0 or 1 are interchangeable, as proxysql reads
+// them. Settings without a field are ignored. Embedded structs are
+// decoded from the same settings, and pointer fields are only set when
+// their setting is there, so they stay nil for NULL columns.
+func (g Group) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode needs a pointer to a struct, got %T", v)
+	}
+	return g.decodeStruct(rv.Elem())
+}
+
+func (g Group) decodeStruct(rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).Anonymous && rt.Field(i).Type.Kind() == reflect.Struct {
+			if err := g.decodeStruct(rv.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		name := strings.Split(rt.Field(i).Tag.Get("libconfig"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		setting, ok := g.Lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(rv.Field(i), setting.Value); err != nil {
+			return fmt.Errorf("line %d: %s %s", setting.Line, name, err.Error())
+		}
+	}
+	return nil
+}
+
+// setField converts a libconfig value to the kind of field.
+func setField(field reflect.Value, value interface{}) error {
+	if field.Kind() == reflect.Ptr {
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			field.SetString(v)
+		case int64:
+			field.SetString(strconv.FormatInt(v, 10))
+		default:
+			return fmt.Errorf("must be a string, got %v", value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch v := value.(type) {
+		case int64:
+			n = v
+		case bool:
+			if v {
+				n = 1
+			}
+		case string:
+			parsed, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return fmt.Errorf("must be an integer, got %q", v)
+			}
+			n = parsed
+		default:
+			return fmt.Errorf("must be an integer, got %v", value)
+		}
+		if field.OverflowInt(n) {
+			return fmt.Errorf("%d is out of range", n)
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			field.SetBool(v)
+		case int64:
+			field.SetBool(v != 0)
+		case string:
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("must be a bool, got %q", v)
+			}
+			field.SetBool(parsed)
+		default:
+			return fmt.Errorf("must be a bool, got %v", value)
+		}
+	default:
+		return fmt.Errorf("can't be decoded into a %s", field.Kind())
+	}
+	return nil
+}
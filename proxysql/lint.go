@@ -0,0 +1,165 @@
+package proxysql
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+// Finding is a problem Lint found in a list of query rules.
+type Finding struct {
+	// Index is the position of the rule in the list, -1 for findings about
+	// the list as a whole
+	Index int
+	// RuleID is the rule_id of the rule, 0 when it hasn't been assigned
+	RuleID int
+	// Message says what's wrong
+	Message string
+}
+
+func (f Finding) String() string {
+	switch {
+	case f.Index < 0:
+		return f.Message
+	case f.RuleID != 0:
+		return fmt.Sprintf("query_rules.%d (rule_id %d): %s", f.Index, f.RuleID, f.Message)
+	}
+	return fmt.Sprintf("query_rules.%d: %s", f.Index, f.Message)
+}
+
+// LintOptions are the hostgroups of the instance group the rules belong to.
+type LintOptions struct {
+	// WriteHostgroup is where a catch-all rule should send statements
+	WriteHostgroup int
+	// Hostgroups are the hostgroups rules may send statements to, the
+	// write hostgroup is always included
+	Hostgroups []int
+}
+
+// Lint looks for rules proxysql will never get to or that send statements
+// nowhere: rules shadowed by an earlier active catch-all or identical
+// match_digest and match_pattern with apply = 1, duplicate rule ids,
+// destinations and mirror hostgroups that aren't declared hostgroups, and
+// the lack of a catch-all to the writer. A rule only shadows later rules
+// with the same flagIN whose schemaname and client_addr it covers. rules
+// are in the order proxysql evaluates them, the order of the query_rules
+// list.
+func Lint(rules []api.QueryRule, opts LintOptions) []Finding {
+	findings := []Finding{}
+	hostgroups := map[int]bool{opts.WriteHostgroup: true}
+	for _, hg := range opts.Hostgroups {
+		hostgroups[hg] = true
+	}
+	ruleIDs := map[int]int{}
+	writerCatchAll := false
+	for i, rule := range rules {
+		finding := func(format string, args ...interface{}) {
+			findings = append(findings, Finding{Index: i, RuleID: rule.RuleID, Message: fmt.Sprintf(format, args...)})
+		}
+		if rule.RuleID != 0 {
+			if first, ok := ruleIDs[rule.RuleID]; ok {
+				finding("duplicate rule_id, query_rules.%d has it too", first)
+			} else {
+				ruleIDs[rule.RuleID] = i
+			}
+		}
+		if !hostgroups[rule.DestinationHostgroup] {
+			finding("destination_hostgroup %d isn't one of the instance group's hostgroups %s", rule.DestinationHostgroup, formatHostgroups(hostgroups))
+		}
+		if rule.MirrorHostgroup != nil && !hostgroups[*rule.MirrorHostgroup] {
+			finding("mirror_hostgroup %d isn't one of the instance group's hostgroups %s", *rule.MirrorHostgroup, formatHostgroups(hostgroups))
+		}
+		if rule.Active != 1 {
+			continue
+		}
+		if matchesAll(rule) && rule.FlagIN == 0 && rule.SchemaName == "" && rule.ClientAddr == "" &&
+			rule.ErrorMsg == "" && rule.DestinationHostgroup == opts.WriteHostgroup {
+			writerCatchAll = true
+		}
+		for j := 0; j < i; j++ {
+			earlier := rules[j]
+			if earlier.Active != 1 || earlier.Apply != 1 || earlier.FlagIN != rule.FlagIN || !covers(earlier, rule) {
+				continue
+			}
+			if matchesAll(earlier) {
+				finding("never matches, query_rules.%d before it matches every statement with apply = 1", j)
+				break
+			}
+			if earlier.MatchDigest == rule.MatchDigest && earlier.MatchPattern == rule.MatchPattern && earlier.NegateMatchPattern == rule.NegateMatchPattern {
+				if rule.MatchPattern == "" {
+					finding("never matches, query_rules.%d before it has the same match_digest with apply = 1", j)
+				} else {
+					finding("never matches, query_rules.%d before it has the same match_digest and match_pattern with apply = 1", j)
+				}
+				break
+			}
+		}
+	}
+	if len(rules) > 0 && !writerCatchAll {
+		findings = append(findings, Finding{
+			Index:   -1,
+			Message: fmt.Sprintf("no active catch-all rule sends unmatched statements to the write hostgroup %d", opts.WriteHostgroup),
+		})
+	}
+	return findings
+}
+
+// covers reports whether every statement that gets past the username,
+// schemaname and client_addr filters of rule gets past those of earlier.
+func covers(earlier, rule api.QueryRule) bool {
+	return (earlier.Username == "" || earlier.Username == rule.Username) &&
+		(earlier.SchemaName == "" || earlier.SchemaName == rule.SchemaName) &&
+		(earlier.ClientAddr == "" || earlier.ClientAddr == rule.ClientAddr)
+}
+
+// matchesAll reports whether both the match_digest and the match_pattern
+// of rule match every statement.
+func matchesAll(rule api.QueryRule) bool {
+	return isCatchAll(rule.MatchDigest) && rule.NegateMatchPattern != 1 && isCatchAll(rule.MatchPattern)
+}
+
+// isCatchAll reports whether a match_digest matches every statement, like
+// ".*", "^.*$" or an empty digest, which proxysql treats as no filter.
+func isCatchAll(matchDigest string) bool {
+	re, err := syntax.Parse(matchDigest, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return matchesEverything(re.Simplify())
+}
+
+func matchesEverything(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpBeginLine, syntax.OpEndText, syntax.OpEndLine:
+		return true
+	case syntax.OpStar:
+		return re.Sub[0].Op == syntax.OpAnyChar || re.Sub[0].Op == syntax.OpAnyCharNotNL
+	case syntax.OpCapture:
+		return matchesEverything(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !matchesEverything(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if matchesEverything(sub) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatHostgroups(hostgroups map[int]bool) string {
+	sorted := make([]int, 0, len(hostgroups))
+	for hg := range hostgroups {
+		sorted = append(sorted, hg)
+	}
+	sort.Ints(sorted)
+	return fmt.Sprint(sorted)
+}
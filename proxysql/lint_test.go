@@ -0,0 +1,86 @@
+package proxysql
+
+import (
+	"reflect"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+func TestLint(t *testing.T) {
+	// the rules of the helm chart's test config
+	rules := []models.ProxySqlMySqlQueryRule{
+		{RuleID: 1, Username: "orders", Active: 1, MatchDigest: "^SELECT .* FOR UPDATE", DestinationHostgroup: 5, Apply: 1},
+		{RuleID: 2, Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1},
+		{RuleID: 3, Username: "orders", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1},
+		{RuleID: 4, Username: "orders", Active: 1, MatchDigest: "^DELETE", DestinationHostgroup: 5, Apply: 1},
+	}
+	got := Lint(api.NewQueryRules(rules), LintOptions{WriteHostgroup: 5, Hostgroups: []int{10}})
+	want := []Finding{{Index: 3, RuleID: 4, Message: "never matches, query_rules.2 before it matches every statement with apply = 1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if got[0].String() != "query_rules.3 (rule_id 4): never matches, query_rules.2 before it matches every statement with apply = 1" {
+		t.Fatalf("unexpected message %s", got[0])
+	}
+
+	rules = []models.ProxySqlMySqlQueryRule{
+		{RuleID: 1, Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1},
+		{RuleID: 1, Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 20, Apply: 1},
+		// inactive and apply = 0 rules don't shadow anything
+		{RuleID: 3, Active: 0, MatchDigest: "^.*$", DestinationHostgroup: 5, Apply: 1},
+		{RuleID: 4, Active: 1, MatchDigest: "(.*)", DestinationHostgroup: 10, Apply: 0},
+		{RuleID: 5, Username: "reporting", Active: 1, MatchDigest: "^UPDATE", DestinationHostgroup: 5, Apply: 1},
+	}
+	got = Lint(api.NewQueryRules(rules), LintOptions{WriteHostgroup: 5, Hostgroups: []int{10}})
+	want = []Finding{
+		{Index: 1, RuleID: 1, Message: "duplicate rule_id, query_rules.0 has it too"},
+		{Index: 1, RuleID: 1, Message: "destination_hostgroup 20 isn't one of the instance group's hostgroups [5 10]"},
+		{Index: 1, RuleID: 1, Message: "never matches, query_rules.0 before it has the same match_digest with apply = 1"},
+		{Index: -1, Message: "no active catch-all rule sends unmatched statements to the write hostgroup 5"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected\n%+v\ngot\n%+v", want, got)
+	}
+}
+
+func TestLint_Filters(t *testing.T) {
+	one := 1
+	rules := []api.QueryRule{
+		// a catch-all for one schema or another flag doesn't shadow anything
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 1, Active: 1, MatchDigest: ".*", DestinationHostgroup: 10, Apply: 1}, SchemaName: "reports"},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 2, Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1}, FlagIN: 1},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 3, Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1}, MatchPattern: "FOR UPDATE", NegateMatchPattern: 1},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 4, Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 5, Apply: 1}, MirrorHostgroup: &one},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 5, Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1}, MatchPattern: "FOR UPDATE", NegateMatchPattern: 1},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 6, Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1}},
+	}
+	got := Lint(rules, LintOptions{WriteHostgroup: 5, Hostgroups: []int{10}})
+	want := []Finding{
+		{Index: 3, RuleID: 4, Message: "mirror_hostgroup 1 isn't one of the instance group's hostgroups [5 10]"},
+		{Index: 4, RuleID: 5, Message: "never matches, query_rules.2 before it has the same match_digest and match_pattern with apply = 1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected\n%+v\ngot\n%+v", want, got)
+	}
+}
+
+func TestIsCatchAll(t *testing.T) {
+	tests := map[string]bool{
+		"":           true,
+		".*":         true,
+		"^.*$":       true,
+		"(.*)":       true,
+		"(?s).*":     true,
+		"^SELECT|.*": true,
+		".+":         false,
+		"^SELECT":    false,
+		"^(?!x)":     false,
+	}
+	for re, want := range tests {
+		if got := isCatchAll(re); got != want {
+			t.Errorf("isCatchAll(%q): expected %t", re, want)
+		}
+	}
+}
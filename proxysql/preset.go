@@ -0,0 +1,73 @@
+package proxysql
+
+import (
+	"fmt"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+// Presets are the names of the built-in query rule presets:
+//
+// read_write_split is the split chester-api generates when an instance
+// group is added without query rules, SELECT ... FOR UPDATE and
+// statements that aren't a SELECT go to the writer, other SELECTs to the
+// readers.
+//
+// select_for_update_safe is read_write_split that also keeps the other
+// locking reads, SELECT ... LOCK IN SHARE MODE, SELECT ... FOR SHARE and
+// the named lock functions, on the writer.
+//
+// writer_only sends everything to the writer.
+var Presets = []string{"read_write_split", "select_for_update_safe", "writer_only"}
+
+// presetRule is a rule of a preset, writer picks the write hostgroup over
+// the read hostgroup.
+type presetRule struct {
+	matchDigest string
+	writer      bool
+	comment     string
+}
+
+var presets = map[string][]presetRule{
+	"read_write_split": {
+		{matchDigest: "^SELECT .* FOR UPDATE", writer: true, comment: "select for update goes to the writer"},
+		{matchDigest: "^SELECT", comment: "selects go to the reader"},
+		{matchDigest: ".*", writer: true, comment: "catch-all to writer"},
+	},
+	"select_for_update_safe": {
+		{matchDigest: "^SELECT .* FOR UPDATE", writer: true, comment: "select for update goes to the writer"},
+		{matchDigest: "^SELECT .* (LOCK IN SHARE MODE|FOR SHARE)", writer: true, comment: "shared locking reads go to the writer"},
+		{matchDigest: "^SELECT .*(GET_LOCK|RELEASE_LOCK|RELEASE_ALL_LOCKS|IS_FREE_LOCK|IS_USED_LOCK)\\(", writer: true, comment: "named locks go to the writer"},
+		{matchDigest: "^SELECT", comment: "selects go to the reader"},
+		{matchDigest: ".*", writer: true, comment: "catch-all to writer"},
+	},
+	"writer_only": {
+		{matchDigest: ".*", writer: true, comment: "everything goes to the writer"},
+	},
+}
+
+// Preset returns the query rules of the preset called name for username.
+// The rules have no rule ids, those are up to the caller or chester-api.
+func Preset(name, username string, readHostgroup, writeHostgroup int) ([]api.QueryRule, error) {
+	rules, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown query rule preset %q, expected one of %v", name, Presets)
+	}
+	qrs := make([]api.QueryRule, len(rules))
+	for i, rule := range rules {
+		hostgroup := readHostgroup
+		if rule.writer {
+			hostgroup = writeHostgroup
+		}
+		qrs[i] = api.QueryRule{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{
+			Username:             username,
+			Active:               1,
+			MatchDigest:          rule.matchDigest,
+			DestinationHostgroup: hostgroup,
+			Apply:                1,
+			Comment:              rule.comment,
+		}}
+	}
+	return qrs, nil
+}
@@ -0,0 +1,53 @@
+package proxysql
+
+import "testing"
+
+func TestPreset(t *testing.T) {
+	for _, name := range Presets {
+		rules, err := Preset(name, "orders", 10, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findings := Lint(rules, LintOptions{WriteHostgroup: 5, Hostgroups: []int{10}}); len(findings) != 0 {
+			t.Errorf("%s: expected no findings, got %v", name, findings)
+		}
+	}
+	if _, err := Preset("round_robin", "orders", 10, 5); err == nil {
+		t.Fatal("expected an unknown preset to fail")
+	}
+}
+
+func TestPreset_Routes(t *testing.T) {
+	for _, tc := range []struct {
+		preset string
+		query  string
+		want   int
+	}{
+		{"read_write_split", "SELECT * FROM orders WHERE id = 1", 10},
+		{"read_write_split", "SELECT * FROM orders WHERE id = 1 FOR UPDATE", 5},
+		{"read_write_split", "SELECT * FROM orders WHERE id = 1 LOCK IN SHARE MODE", 10},
+		{"read_write_split", "UPDATE orders SET paid = 1", 5},
+		{"select_for_update_safe", "SELECT * FROM orders WHERE id = 1", 10},
+		{"select_for_update_safe", "SELECT * FROM orders WHERE id = 1 FOR UPDATE", 5},
+		{"select_for_update_safe", "SELECT * FROM orders WHERE id = 1 LOCK IN SHARE MODE", 5},
+		{"select_for_update_safe", "select * from orders for share", 5},
+		{"select_for_update_safe", "SELECT GET_LOCK('orders', 10)", 5},
+		{"select_for_update_safe", "INSERT INTO orders VALUES (1)", 5},
+		{"writer_only", "SELECT * FROM orders", 5},
+	} {
+		rules, err := Preset(tc.preset, "orders", 10, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range rules {
+			rules[i].RuleID = i + 1
+		}
+		route, err := RouteQuery(rules, "orders", tc.query, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if route.Default || route.DestinationHostgroup != tc.want {
+			t.Errorf("%s: %s: expected hostgroup %d, got %+v", tc.preset, tc.query, tc.want, route)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package proxysql
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+// Mask replaces credentials in a masked config.
+const Mask = "********"
+
+// ProxySqlConfig is a models.ProxySqlConfig with every query rule column,
+// the replication hostgroups and the mysql variables of the instance
+// group. QueryRules replaces MySqlQueryRules, which Render ignores.
+type ProxySqlConfig struct {
+	*models.ProxySqlConfig
+	QueryRules            []api.QueryRule
+	ReplicationHostgroups []api.ReplicationHostgroup
+	// Variables are the mysql_variables set for the instance group, by
+	// name without the mysql- prefix, they override MysqlVariables
+	Variables map[string]string
+}
+
+// NewProxySqlConfig builds the config chester runs an instance group with,
+// the chestermodels defaults with the servers, user and query rules of db.
+// The writer is in the write hostgroup and the read replicas are in the
+// read hostgroup, the servers of db.Hostgroups are in theirs. Every server
+// is commented with its instance name and takes 100 connections unless its
+// hostgroup says otherwise. db.Monitor replaces the default monitor user.
+func NewProxySqlConfig(db api.InstanceData) *ProxySqlConfig {
+	psql := models.NewProxySqlConfig()
+	psql.InitDefaults()
+	psql.ReadHostGroup = db.ReadHostGroup
+	psql.WriteHostGroup = db.WriteHostGroup
+	psql.UseSSL = db.UseSSL
+	psql.MySqlServers = []models.ProxySqlMySqlServer{{
+		Address:        db.MasterInstance.IPAddress,
+		Port:           3306,
+		Hostgroup:      db.WriteHostGroup,
+		MaxConnections: 100,
+		Comment:        db.MasterInstance.Name,
+		UseSSL:         db.UseSSL,
+	}}
+	for _, rr := range db.ReadReplicas {
+		psql.AddReadReplica(models.ProxySqlMySqlServer{
+			Address:        rr.IPAddress,
+			Port:           3306,
+			Hostgroup:      db.ReadHostGroup,
+			MaxConnections: 100,
+			Comment:        rr.Name,
+			UseSSL:         db.UseSSL,
+		})
+	}
+	for _, hostgroup := range db.Hostgroups {
+		maxConnections := int64(100)
+		if hostgroup.MaxConnections > 0 {
+			maxConnections = int64(hostgroup.MaxConnections)
+		}
+		for _, server := range hostgroup.Servers {
+			psql.MySqlServers = append(psql.MySqlServers, models.ProxySqlMySqlServer{
+				Address:        server.IPAddress,
+				Port:           3306,
+				Hostgroup:      hostgroup.HostgroupID,
+				MaxConnections: maxConnections,
+				Comment:        server.Name,
+				UseSSL:         db.UseSSL,
+			})
+		}
+	}
+	psql.MySqlUsers = []models.ProxySqlMySqlUser{{
+		Username:         db.Username,
+		Password:         db.Password,
+		DefaultHostgroup: db.WriteHostGroup,
+		Active:           1,
+		InstanceGroup:    db.InstanceName,
+	}}
+	if db.Monitor != nil {
+		psql.MysqlVariables.MonitorUsername = db.Monitor.Username
+		psql.MysqlVariables.MonitorPassword = db.Monitor.Password
+	}
+	return &ProxySqlConfig{
+		ProxySqlConfig:        psql,
+		QueryRules:            append([]api.QueryRule{}, db.QueryRules...),
+		ReplicationHostgroups: append([]api.ReplicationHostgroup{}, db.ReplicationHostgroups...),
+	}
+}
+
+// MaskCredentials replaces the user passwords, the admin credentials and
+// the monitor password of psql with Mask.
+func MaskCredentials(psql *ProxySqlConfig) {
+	if i := strings.Index(psql.AdminVariables.AdminCredentials, ":"); i >= 0 {
+		psql.AdminVariables.AdminCredentials = psql.AdminVariables.AdminCredentials[:i+1] + Mask
+	} else if psql.AdminVariables.AdminCredentials != "" {
+		psql.AdminVariables.AdminCredentials = Mask
+	}
+	if psql.MysqlVariables.MonitorPassword != "" {
+		psql.MysqlVariables.MonitorPassword = Mask
+	}
+	users := make([]models.ProxySqlMySqlUser, len(psql.MySqlUsers))
+	for i, user := range psql.MySqlUsers {
+		user.Password = Mask
+		users[i] = user
+	}
+	psql.MySqlUsers = users
+}
+
+// Render writes psql as a proxysql.cnf. It's laid out like
+// ProxySqlConfig.ToLibConfig, but strings are escaped, so match digests
+// with quotes or backslashes survive, and ParseConfig reads it back. Query
+// rule columns that are NULL or empty are left out, and so is
+// mysql_replication_hostgroups without any replication hostgroups. The
+// instance group's Variables take the place of the defaults in
+// mysql_variables.
+func Render(psql *ProxySqlConfig) []byte {
+	b := &bytes.Buffer{}
+	admin := psql.AdminVariables
+	fmt.Fprintf(b, "datadir=%s\n", quote(psql.DataDir))
+	b.WriteString("admin_variables=\n{\n")
+	fmt.Fprintf(b, "  admin_credentials=%s\n", quote(admin.AdminCredentials))
+	fmt.Fprintf(b, "  mysql_ifaces=%s\n", quote(admin.MysqlIFaces))
+	fmt.Fprintf(b, "  refresh_interval=%d\n", admin.RefreshInterval)
+	b.WriteString("}\nmysql_variables=\n{\n")
+	for _, v := range mysqlVariableLines(psql) {
+		fmt.Fprintf(b, "  %s=%s\n", v.name, v.value)
+	}
+	b.WriteString("}\n")
+
+	servers := make([]string, len(psql.MySqlServers))
+	for i, s := range psql.MySqlServers {
+		servers[i] = fmt.Sprintf("{ address=%s , port=%d , hostgroup=%d, max_connections=%d, use_ssl=%d, comment=%s }",
+			quote(s.Address), s.Port, s.Hostgroup, s.MaxConnections, s.UseSSL, quote(s.Comment))
+	}
+	writeList(b, "mysql_servers", servers)
+	if len(psql.ReplicationHostgroups) > 0 {
+		pairs := make([]string, len(psql.ReplicationHostgroups))
+		for i, p := range psql.ReplicationHostgroups {
+			checkType := p.CheckType
+			if checkType == "" {
+				checkType = "read_only"
+			}
+			pairs[i] = fmt.Sprintf("{ writer_hostgroup=%d, reader_hostgroup=%d, check_type=%s, comment=%s }",
+				p.WriterHostgroup, p.ReaderHostgroup, quote(checkType), quote(p.Comment))
+		}
+		writeList(b, "mysql_replication_hostgroups", pairs)
+	}
+	users := make([]string, len(psql.MySqlUsers))
+	for i, u := range psql.MySqlUsers {
+		users[i] = fmt.Sprintf("{ username = %s , password = %s , default_hostgroup = %d , active = %d }",
+			quote(u.Username), quote(u.Password), u.DefaultHostgroup, u.Active)
+	}
+	writeList(b, "mysql_users", users)
+	rules := make([]string, len(psql.QueryRules))
+	for i, r := range psql.QueryRules {
+		rules[i] = renderQueryRule(r)
+	}
+	writeList(b, "mysql_query_rules", rules)
+	return b.Bytes()
+}
+
+// renderQueryRule writes a mysql_query_rules entry, the columns chester
+// started with are always there, the others only when they're set.
+func renderQueryRule(r api.QueryRule) string {
+	columns := []string{
+		fmt.Sprintf("rule_id = %d", r.RuleID),
+		fmt.Sprintf("username=%s", quote(r.Username)),
+		fmt.Sprintf("active=%d", r.Active),
+		fmt.Sprintf("match_digest=%s", quote(r.MatchDigest)),
+		fmt.Sprintf("destination_hostgroup=%d", r.DestinationHostgroup),
+		fmt.Sprintf("apply=%d", r.Apply),
+		fmt.Sprintf("comment=%s", quote(r.Comment)),
+	}
+	for _, c := range []struct {
+		name  string
+		value string
+	}{
+		{"match_pattern", r.MatchPattern},
+		{"schemaname", r.SchemaName},
+		{"client_addr", r.ClientAddr},
+		{"replace_pattern", r.ReplacePattern},
+		{"error_msg", r.ErrorMsg},
+	} {
+		if c.value != "" {
+			columns = append(columns, fmt.Sprintf("%s=%s", c.name, quote(c.value)))
+		}
+	}
+	if r.NegateMatchPattern != 0 {
+		columns = append(columns, fmt.Sprintf("negate_match_pattern=%d", r.NegateMatchPattern))
+	}
+	if r.FlagIN != 0 {
+		columns = append(columns, fmt.Sprintf("flagIN=%d", r.FlagIN))
+	}
+	for _, c := range []struct {
+		name  string
+		value *int
+	}{
+		{"flagOUT", r.FlagOUT},
+		{"cache_ttl", r.CacheTTL},
+		{"timeout", r.Timeout},
+		{"retries", r.Retries},
+		{"delay", r.Delay},
+		{"mirror_hostgroup", r.MirrorHostgroup},
+		{"mirror_flagOUT", r.MirrorFlagOUT},
+		{"multiplex", r.Multiplex},
+		{"log", r.Log},
+	} {
+		if c.value != nil {
+			columns = append(columns, fmt.Sprintf("%s=%d", c.name, *c.value))
+		}
+	}
+	return "{ " + strings.Join(columns, " , ") + " }"
+}
+
+// writeList writes a list setting with an entry per line.
+func writeList(b *bytes.Buffer, name string, entries []string) {
+	fmt.Fprintf(b, "%s=\n(\n", name)
+	for i, entry := range entries {
+		b.WriteString("  " + entry)
+		if i < len(entries)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")\n")
+}
+
+// quote writes s as a libconfig string.
+func quote(s string) string {
+	b := &strings.Builder{}
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(b, `\x%02x`, c)
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
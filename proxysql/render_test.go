@@ -0,0 +1,136 @@
+package proxysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+var cacheTTL, zero = 5000, 0
+
+var renderedDB = api.InstanceData{
+	InstanceData: models.InstanceData{
+		InstanceName:   "orders-db",
+		Username:       "orders",
+		Password:       "secret",
+		ReadHostGroup:  10,
+		WriteHostGroup: 5,
+		MasterInstance: models.AddDatabaseRequestDatabaseInformation{Name: "orders-db", IPAddress: "10.0.0.2"},
+		ReadReplicas: []models.AddDatabaseRequestDatabaseInformation{
+			{Name: "orders-read", IPAddress: "10.0.0.3"},
+		},
+	},
+	Hostgroups: []api.Hostgroup{{
+		HostgroupID:    20,
+		Servers:        []models.AddDatabaseRequestDatabaseInformation{{Name: "orders-analytics", IPAddress: "10.0.0.4"}},
+		MaxConnections: 20,
+	}},
+	ReplicationHostgroups: []api.ReplicationHostgroup{{WriterHostgroup: 5, ReaderHostgroup: 10, CheckType: "read_only", Comment: "orders"}},
+	QueryRules: []api.QueryRule{
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 1, Username: "orders", Active: 1, MatchDigest: `^SELECT .* WHERE name = "x\y"`, DestinationHostgroup: 10, Apply: 1, Comment: "quoted"}},
+		{
+			ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 2, Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 0},
+			MatchPattern:           `FROM reports WHERE day = '(\d+)'`,
+			ReplacePattern:         `FROM reports_daily WHERE day = '\1'`,
+			SchemaName:             "orders",
+			FlagOUT:                &zero,
+			CacheTTL:               &cacheTTL,
+			Multiplex:              &zero,
+		},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 3, Username: "orders", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1}},
+	},
+}
+
+func TestRender(t *testing.T) {
+	psql := NewProxySqlConfig(renderedDB)
+	rendered := Render(psql)
+	config, err := ParseConfig(rendered)
+	if err != nil {
+		t.Fatalf("failed to read back the rendered config: %s\n%s", err, rendered)
+	}
+	wantServers := []models.ProxySqlMySqlServer{
+		{Address: "10.0.0.2", Port: 3306, Hostgroup: 5, MaxConnections: 100, Comment: "orders-db"},
+		{Address: "10.0.0.3", Port: 3306, Hostgroup: 10, MaxConnections: 100, Comment: "orders-read"},
+		{Address: "10.0.0.4", Port: 3306, Hostgroup: 20, MaxConnections: 20, Comment: "orders-analytics"},
+	}
+	if !reflect.DeepEqual(config.Servers, wantServers) {
+		t.Fatalf("expected servers %+v, got %+v", wantServers, config.Servers)
+	}
+	wantUsers := []models.ProxySqlMySqlUser{{Username: "orders", Password: "secret", DefaultHostgroup: 5, Active: 1}}
+	if !reflect.DeepEqual(config.Users, wantUsers) {
+		t.Fatalf("expected users %+v, got %+v", wantUsers, config.Users)
+	}
+	if !reflect.DeepEqual(config.ReplicationHostgroups, renderedDB.ReplicationHostgroups) {
+		t.Fatalf("expected replication hostgroups %+v, got %+v", renderedDB.ReplicationHostgroups, config.ReplicationHostgroups)
+	}
+	if !reflect.DeepEqual(config.QueryRules, renderedDB.QueryRules) {
+		t.Fatalf("expected query rules %+v, got %+v", renderedDB.QueryRules, config.QueryRules)
+	}
+	root, err := ParseLibConfig(rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, _ := root.Lookup("mysql_variables")
+	if threads, _ := vars.Value.(Group).Lookup("threads"); threads.Value != int64(4) {
+		t.Fatalf("expected the chestermodels default threads, got %v", threads.Value)
+	}
+}
+
+func TestRender_Variables(t *testing.T) {
+	psql := NewProxySqlConfig(renderedDB)
+	psql.Variables = map[string]string{"threads": "8", "server_version": "8.0.27", "wait_timeout": "600", "multiplexing": "false"}
+	root, err := ParseLibConfig(Render(psql))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, _ := root.Lookup("mysql_variables")
+	want := map[string]interface{}{
+		"threads":         int64(8),
+		"server_version":  "8.0.27",
+		"wait_timeout":    int64(600),
+		"multiplexing":    false,
+		"max_connections": int64(2048),
+	}
+	for name, value := range want {
+		if got, _ := vars.Value.(Group).Lookup(name); got.Value != value {
+			t.Errorf("expected %s %v, got %v", name, value, got.Value)
+		}
+	}
+	if n := len(vars.Value.(Group)); n != 25 {
+		t.Fatalf("expected the overrides in place of the defaults and 2 more variables, got %d", n)
+	}
+}
+
+func TestRender_Monitor(t *testing.T) {
+	db := renderedDB
+	db.Monitor = &api.MonitorCredentials{Username: "orders-monitor", Password: "monitorsecret"}
+	rendered := string(Render(NewProxySqlConfig(db)))
+	if !strings.Contains(rendered, `monitor_username="orders-monitor"`) || !strings.Contains(rendered, `monitor_password="monitorsecret"`) {
+		t.Fatalf("expected the instance group's monitor user in\n%s", rendered)
+	}
+	psql := NewProxySqlConfig(db)
+	MaskCredentials(psql)
+	if rendered := string(Render(psql)); strings.Contains(rendered, "monitorsecret") {
+		t.Fatalf("expected the monitor password to be masked in\n%s", rendered)
+	}
+}
+
+func TestRender_Masked(t *testing.T) {
+	psql := NewProxySqlConfig(renderedDB)
+	MaskCredentials(psql)
+	rendered := string(Render(psql))
+	for _, secret := range []string{"secret", "adminpassw0rd", "proxysqlpassw0rd"} {
+		if strings.Contains(rendered, secret) {
+			t.Errorf("expected %s to be masked in\n%s", secret, rendered)
+		}
+	}
+	if !strings.Contains(rendered, `admin_credentials="proxysql-admin:********"`) {
+		t.Fatalf("expected the admin username to be kept in\n%s", rendered)
+	}
+	if renderedDB.Password != "secret" {
+		t.Fatal("masking changed the instance data")
+	}
+}
@@ -0,0 +1,297 @@
+package proxysql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+// digestGroupingLimit is proxysql's default mysql-query_digests_grouping_limit,
+// lists of more placeholders than this are cut short with "...".
+const digestGroupingLimit = 3
+
+// placeholderList matches a comma separated list of placeholders longer
+// than digestGroupingLimit.
+var placeholderList = regexp.MustCompile(fmt.Sprintf(`\?(,\?){%d,}`, digestGroupingLimit))
+
+// Digest normalizes a statement the way proxysql does before matching it
+// against match_digest, with proxysql's default mysql-query_digests
+// variables. Comments are dropped, string, number and hex literals become
+// ?, whitespace is collapsed, spaces around commas are removed and long
+// lists of placeholders are cut to "?,?,?,...". Keywords keep their case
+// and NULL isn't replaced.
+func Digest(query string) string {
+	b := &strings.Builder{}
+	space := false
+	// emit writes s, preceded by a single space if whitespace or a
+	// comment was skipped since the last token
+	emit := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			space = true
+			i++
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+			space = true
+		case c == '#' || (c == '-' && strings.HasPrefix(query[i:], "-- ")):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+			space = true
+		case c == '\'' || c == '"':
+			i = skipQuoted(query, i)
+			emit("?")
+		case c == '`':
+			end := strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				emit(query[i:])
+				i = len(query)
+			} else {
+				emit(query[i : i+end+2])
+				i += end + 2
+			}
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			i = skipNumber(query, i)
+			emit("?")
+		case isWordChar(c):
+			start := i
+			for i < len(query) && isWordChar(query[i]) {
+				i++
+			}
+			emit(query[start:i])
+		default:
+			emit(string(c))
+			i++
+		}
+	}
+	digest := strings.ReplaceAll(strings.ReplaceAll(b.String(), " ,", ","), ", ", ",")
+	digest = placeholderList.ReplaceAllString(digest, strings.Repeat("?,", digestGroupingLimit)+"...")
+	return digest
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return isDigit(c) || c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// skipQuoted returns the index after the string literal starting at i,
+// backslash escapes and doubled quotes are part of the literal.
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	i++
+	for i < len(query) {
+		switch query[i] {
+		case '\\':
+			i += 2
+			continue
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return len(query)
+}
+
+// skipNumber returns the index after the number literal starting at i.
+func skipNumber(query string, i int) int {
+	if strings.HasPrefix(query[i:], "0x") || strings.HasPrefix(query[i:], "0X") {
+		i += 2
+		for i < len(query) && strings.IndexByte("0123456789abcdefABCDEF", query[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+	for i < len(query) && (isDigit(query[i]) || query[i] == '.') {
+		i++
+	}
+	if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+		if j < len(query) && (query[j] == '+' || query[j] == '-') {
+			j++
+		}
+		if j < len(query) && isDigit(query[j]) {
+			i = j
+			for i < len(query) && isDigit(query[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// Route is where proxysql sends a statement.
+type Route struct {
+	// Digest is the normalized statement the rules were matched against
+	Digest string
+	// Query is the statement as it's sent on, after every replace_pattern
+	Query string
+	// Rules are the rules that matched, in the order they were evaluated
+	Rules []api.QueryRule
+	// DestinationHostgroup is the hostgroup the statement is sent to
+	DestinationHostgroup int
+	// Default is true when no rule matched and the statement goes to the
+	// user's default hostgroup
+	Default bool
+	// ErrorMsg is returned to the client instead of running the statement
+	ErrorMsg string
+	// CacheTTL is how long the result is cached, nil when it isn't
+	CacheTTL *int
+	// MirrorHostgroup is where the statement is mirrored to, nil when it isn't
+	MirrorHostgroup *int
+	// MirrorFlagOUT is the flag the mirrored copy is evaluated with, nil
+	// when it's sent as is
+	MirrorFlagOUT *int
+}
+
+// Statement is a statement and the connection it arrives on.
+type Statement struct {
+	Username string
+	// SchemaName is the default schema of the connection, rules with a
+	// schemaname only match statements on that schema
+	SchemaName string
+	// ClientAddr is the address of the client, rules with a client_addr
+	// only match statements from that address
+	ClientAddr string
+	Query      string
+}
+
+// RouteQuery is RouteStatement for a statement from username on a
+// connection without a default schema or known client address.
+func RouteQuery(rules []api.QueryRule, username, query string, defaultHostgroup int) (Route, error) {
+	return RouteStatement(rules, Statement{Username: username, Query: query}, defaultHostgroup)
+}
+
+// RouteStatement works out which rules a statement matches and where it's
+// sent, the way proxysql evaluates mysql_query_rules. Active rules are
+// evaluated in rule_id order, starting at flagIN 0. A rule is skipped
+// unless its flagIN is the current flag and its username, schemaname and
+// client_addr match, empty ones match everything and a client_addr ending
+// in % matches by prefix. match_digest is matched case insensitively
+// against the digest and match_pattern against the statement, inverted
+// by negate_match_pattern.
+//
+// Every matching rule sets the destination hostgroup, error_msg, cache_ttl
+// and mirror_hostgroup it has, rewrites the statement with replace_pattern
+// and moves on to flagOUT when it's set. A match with apply = 1 stops the
+// evaluation. Without a match the statement goes to defaultHostgroup.
+//
+// match_digest and match_pattern are compiled as go regexps, which are
+// close to the PCRE proxysql uses but don't support lookarounds or
+// backreferences, those rules return an error.
+func RouteStatement(rules []api.QueryRule, stmt Statement, defaultHostgroup int) (Route, error) {
+	sorted := append([]api.QueryRule{}, rules...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].RuleID < sorted[j].RuleID
+	})
+	route := Route{
+		Digest:               Digest(stmt.Query),
+		Query:                stmt.Query,
+		Rules:                []api.QueryRule{},
+		DestinationHostgroup: defaultHostgroup,
+		Default:              true,
+	}
+	flag := 0
+	for _, rule := range sorted {
+		if rule.Active != 1 || rule.FlagIN != flag || !matchesConnection(rule, stmt) {
+			continue
+		}
+		digestRe, err := compileRule(rule, "match_digest", rule.MatchDigest)
+		if err != nil {
+			return Route{}, err
+		}
+		if !digestRe.MatchString(route.Digest) {
+			continue
+		}
+		patternRe, err := compileRule(rule, "match_pattern", rule.MatchPattern)
+		if err != nil {
+			return Route{}, err
+		}
+		if patternRe.MatchString(route.Query) == (rule.NegateMatchPattern == 1) {
+			continue
+		}
+		route.Rules = append(route.Rules, rule)
+		route.DestinationHostgroup = rule.DestinationHostgroup
+		route.Default = false
+		if rule.ReplacePattern != "" && rule.MatchPattern != "" {
+			route.Query = patternRe.ReplaceAllString(route.Query, replacement(rule.ReplacePattern))
+		}
+		if rule.ErrorMsg != "" {
+			route.ErrorMsg = rule.ErrorMsg
+		}
+		if rule.CacheTTL != nil {
+			route.CacheTTL = rule.CacheTTL
+		}
+		if rule.MirrorHostgroup != nil {
+			route.MirrorHostgroup = rule.MirrorHostgroup
+		}
+		if rule.MirrorFlagOUT != nil {
+			route.MirrorFlagOUT = rule.MirrorFlagOUT
+		}
+		if rule.FlagOUT != nil {
+			flag = *rule.FlagOUT
+		}
+		if rule.Apply == 1 {
+			break
+		}
+	}
+	return route, nil
+}
+
+// matchesConnection reports whether the username, schemaname and
+// client_addr filters of rule let stmt through.
+func matchesConnection(rule api.QueryRule, stmt Statement) bool {
+	if rule.Username != "" && rule.Username != stmt.Username {
+		return false
+	}
+	if rule.SchemaName != "" && rule.SchemaName != stmt.SchemaName {
+		return false
+	}
+	if strings.HasSuffix(rule.ClientAddr, "%") {
+		return strings.HasPrefix(stmt.ClientAddr, strings.TrimSuffix(rule.ClientAddr, "%"))
+	}
+	return rule.ClientAddr == "" || rule.ClientAddr == stmt.ClientAddr
+}
+
+// compileRule compiles a match_digest or match_pattern case insensitively.
+func compileRule(rule api.QueryRule, column, pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("rule %d: %s %q: %s", rule.RuleID, column, pattern, err.Error())
+	}
+	return re, nil
+}
+
+// backreference matches the \1 style backreferences of a replace_pattern.
+var backreference = regexp.MustCompile(`\\([0-9])`)
+
+// replacement converts a proxysql replace_pattern to a go regexp
+// replacement, \1 becomes ${1} and literal dollars are escaped.
+func replacement(replacePattern string) string {
+	return backreference.ReplaceAllString(strings.ReplaceAll(replacePattern, "$", "$$"), "$${$1}")
+}
@@ -0,0 +1,118 @@
+package proxysql
+
+import (
+	"reflect"
+	"testing"
+
+	models "github.com/eahrend/chestermodels"
+	"github.com/eahrend/terraform-provider-chester/api"
+)
+
+func TestDigest(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM t WHERE id = 1":                           "SELECT * FROM t WHERE id = ?",
+		"select  name\n\tfrom `t1` where name='o''brien'":        "select name from `t1` where name=?",
+		`SELECT "a\"b", 1.5e3, 0xFF, -2 FROM t2`:                 "SELECT ?,?,?,-? FROM t2",
+		"SELECT * FROM t WHERE id IN (1, 2, 3, 4, 5)":            "SELECT * FROM t WHERE id IN (?,?,?,...)",
+		"SELECT * FROM t WHERE id IN (1,2,3)":                    "SELECT * FROM t WHERE id IN (?,?,?)",
+		"/* app=web */ SELECT 1 -- trailing\n":                   "SELECT ?",
+		"INSERT INTO t (a, b) VALUES (NULL, 'x') # comment":      "INSERT INTO t (a,b) VALUES (NULL,?)",
+		"UPDATE t SET c = c + 1 WHERE d > .5 AND col2 = 'it''s'": "UPDATE t SET c = c + ? WHERE d > ? AND col2 = ?",
+	}
+	for query, want := range tests {
+		if got := Digest(query); got != want {
+			t.Errorf("Digest(%q): expected %q, got %q", query, want, got)
+		}
+	}
+}
+
+func TestRouteQuery(t *testing.T) {
+	rules := []models.ProxySqlMySqlQueryRule{
+		{RuleID: 3, Username: "orders", Active: 1, MatchDigest: ".*", DestinationHostgroup: 5, Apply: 1},
+		{RuleID: 1, Username: "orders", Active: 1, MatchDigest: "^SELECT .* FOR UPDATE", DestinationHostgroup: 5, Apply: 1},
+		{RuleID: 2, Username: "orders", Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1},
+		{RuleID: 4, Username: "", Active: 1, MatchDigest: "FROM reports", DestinationHostgroup: 20, Apply: 0},
+		{RuleID: 5, Username: "", Active: 0, MatchDigest: ".*", DestinationHostgroup: 30, Apply: 1},
+	}
+	tests := []struct {
+		username    string
+		query       string
+		ruleIDs     []int
+		destination int
+		isDefault   bool
+	}{
+		{username: "orders", query: "SELECT * FROM orders WHERE id = 1 FOR UPDATE", ruleIDs: []int{1}, destination: 5},
+		{username: "orders", query: "select * from orders", ruleIDs: []int{2}, destination: 10},
+		{username: "orders", query: "DELETE FROM orders WHERE id = 1", ruleIDs: []int{3}, destination: 5},
+		// rule 4 doesn't apply, so evaluation goes on
+		{username: "reporting", query: "SELECT * FROM reports", ruleIDs: []int{4}, destination: 20},
+		{username: "reporting", query: "SELECT 1", ruleIDs: []int{}, destination: 7, isDefault: true},
+	}
+	for _, tt := range tests {
+		route, err := RouteQuery(api.NewQueryRules(rules), tt.username, tt.query, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, rule := range route.Rules {
+			ids = append(ids, rule.RuleID)
+		}
+		if !reflect.DeepEqual(ids, tt.ruleIDs) || route.DestinationHostgroup != tt.destination || route.Default != tt.isDefault {
+			t.Errorf("%s %q: expected rules %v to hostgroup %d (default %t), got %v to %d (default %t)",
+				tt.username, tt.query, tt.ruleIDs, tt.destination, tt.isDefault, ids, route.DestinationHostgroup, route.Default)
+		}
+	}
+	bad := []models.ProxySqlMySqlQueryRule{{RuleID: 1, Active: 1, MatchDigest: "^(?!SELECT)"}}
+	if _, err := RouteQuery(api.NewQueryRules(bad), "orders", "SELECT 1", 5); err == nil {
+		t.Fatal("expected an error for a lookahead")
+	}
+}
+
+func TestRouteStatement(t *testing.T) {
+	one, twenty := 1, 20
+	rules := []api.QueryRule{
+		// statements on the reports schema move on to flag 1
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 1, Active: 1, DestinationHostgroup: 10}, SchemaName: "reports", FlagOUT: &one},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 2, Active: 1, MatchDigest: "^SELECT", DestinationHostgroup: 10, Apply: 1}, FlagIN: 1, MatchPattern: "FOR UPDATE", NegateMatchPattern: 1, CacheTTL: &twenty},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 3, Active: 1, DestinationHostgroup: 5, Apply: 1}, FlagIN: 1},
+		// rewrites the table and carries on
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 4, Active: 1, DestinationHostgroup: 5}, MatchPattern: `FROM orders_v(\d)`, ReplacePattern: `FROM orders_\1_$x`},
+		{ProxySqlMySqlQueryRule: models.ProxySqlMySqlQueryRule{RuleID: 5, Active: 1, DestinationHostgroup: 5, Apply: 1}, ClientAddr: "10.1.%", ErrorMsg: "blocked", MirrorHostgroup: &twenty},
+	}
+	tests := []struct {
+		stmt        Statement
+		ruleIDs     []int
+		destination int
+		query       string
+		errorMsg    string
+	}{
+		{stmt: Statement{SchemaName: "reports", Query: "SELECT * FROM daily"}, ruleIDs: []int{1, 2}, destination: 10},
+		{stmt: Statement{SchemaName: "reports", Query: "SELECT * FROM daily FOR UPDATE"}, ruleIDs: []int{1, 3}, destination: 5},
+		// flag 1 rules are skipped without a flagOUT to get there
+		{stmt: Statement{SchemaName: "orders", Query: "SELECT * FROM daily"}, ruleIDs: []int{}, destination: 7},
+		{stmt: Statement{Query: "SELECT * FROM orders_v2"}, ruleIDs: []int{4}, destination: 5, query: "SELECT * FROM orders_2_$x"},
+		{stmt: Statement{ClientAddr: "10.1.0.7", Query: "DELETE FROM t"}, ruleIDs: []int{5}, destination: 5, errorMsg: "blocked"},
+		{stmt: Statement{ClientAddr: "10.2.0.7", Query: "DELETE FROM t"}, ruleIDs: []int{}, destination: 7},
+	}
+	for _, tt := range tests {
+		route, err := RouteStatement(rules, tt.stmt, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, rule := range route.Rules {
+			ids = append(ids, rule.RuleID)
+		}
+		if tt.query == "" {
+			tt.query = tt.stmt.Query
+		}
+		if !reflect.DeepEqual(ids, tt.ruleIDs) || route.DestinationHostgroup != tt.destination || route.Query != tt.query || route.ErrorMsg != tt.errorMsg {
+			t.Errorf("%+v: expected rules %v to hostgroup %d as %q (error %q), got %v to %d as %q (error %q)",
+				tt.stmt, tt.ruleIDs, tt.destination, tt.query, tt.errorMsg, ids, route.DestinationHostgroup, route.Query, route.ErrorMsg)
+		}
+	}
+	route, _ := RouteStatement(rules, Statement{SchemaName: "reports", Query: "SELECT 1"}, 7)
+	if route.CacheTTL == nil || *route.CacheTTL != 20 || route.MirrorHostgroup != nil {
+		t.Fatalf("expected rule 2's cache_ttl, got %+v", route)
+	}
+}
@@ -0,0 +1,138 @@
+package proxysql
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// MysqlVariableType is the type of a mysql variable's value.
+type MysqlVariableType int
+
+const (
+	// MysqlVariableInt is an integer, like threads
+	MysqlVariableInt MysqlVariableType = iota
+	// MysqlVariableBool is true or false, like have_compress
+	MysqlVariableBool
+	// MysqlVariableString is any string, like server_version
+	MysqlVariableString
+)
+
+// MysqlVariables are the mysql_variables an instance group can set, by
+// name without the mysql- prefix. The listener interfaces and the stack
+// size belong to the chart, and the monitor credentials aren't plain
+// settings, so they're left out.
+var MysqlVariables = map[string]MysqlVariableType{
+	"threads":                    MysqlVariableInt,
+	"max_connections":            MysqlVariableInt,
+	"default_query_delay":        MysqlVariableInt,
+	"default_query_timeout":      MysqlVariableInt,
+	"have_compress":              MysqlVariableBool,
+	"poll_timeout":               MysqlVariableInt,
+	"default_schema":             MysqlVariableString,
+	"server_version":             MysqlVariableString,
+	"default_charset":            MysqlVariableString,
+	"connect_timeout_server":     MysqlVariableInt,
+	"connect_timeout_server_max": MysqlVariableInt,
+	"connection_max_age_ms":      MysqlVariableInt,
+	"free_connections_pct":       MysqlVariableInt,
+	"max_allowed_packet":         MysqlVariableInt,
+	"max_transaction_time":       MysqlVariableInt,
+	"wait_timeout":               MysqlVariableInt,
+	"query_retries_on_failure":   MysqlVariableInt,
+	"query_cache_size_MB":        MysqlVariableInt,
+	"multiplexing":               MysqlVariableBool,
+	"long_query_time":            MysqlVariableInt,
+	"threshold_query_length":     MysqlVariableInt,
+	"threshold_resultset_size":   MysqlVariableInt,
+	"monitor_history":            MysqlVariableInt,
+	"monitor_connect_interval":   MysqlVariableInt,
+	"monitor_ping_interval":      MysqlVariableInt,
+	"monitor_read_only_interval": MysqlVariableInt,
+	"monitor_read_only_timeout":  MysqlVariableInt,
+	"ping_interval_server_msec":  MysqlVariableInt,
+	"ping_timeout_server":        MysqlVariableInt,
+	"commands_stats":             MysqlVariableBool,
+	"sessions_sort":              MysqlVariableBool,
+}
+
+// ValidateMysqlVariable checks that name is one of MysqlVariables and that
+// value is of its type.
+func ValidateMysqlVariable(name, value string) error {
+	t, ok := MysqlVariables[name]
+	if !ok {
+		return fmt.Errorf("unknown mysql variable %s", name)
+	}
+	switch t {
+	case MysqlVariableInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%s has to be an integer, got %q", name, value)
+		}
+	case MysqlVariableBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%s has to be true or false, got %q", name, value)
+		}
+	}
+	return nil
+}
+
+// mysqlVariable is a line of the mysql_variables section, value is written
+// as is.
+type mysqlVariable struct {
+	name  string
+	value string
+}
+
+// mysqlVariableLines lays out the mysql_variables section of psql: the
+// chestermodels defaults, with the instance group's variables in place of
+// the defaults they override and the others after them.
+func mysqlVariableLines(psql *ProxySqlConfig) []mysqlVariable {
+	vars := psql.MysqlVariables
+	lines := []mysqlVariable{
+		{"threads", strconv.Itoa(vars.Threads)},
+		{"max_connections", strconv.FormatInt(vars.MaxConnections, 10)},
+		{"default_query_delay", strconv.Itoa(vars.DefaultQueryDelay)},
+		{"default_query_timeout", strconv.FormatInt(vars.DefaultQueryTimeout, 10)},
+		{"have_compress", strconv.FormatBool(vars.HaveCompress)},
+		{"poll_timeout", strconv.FormatInt(vars.PollTimeout, 10)},
+		{"interfaces", quote(vars.Interfaces)},
+		{"default_schema", quote(vars.DefaultSchema)},
+		{"stacksize", strconv.FormatInt(vars.StackSize, 10)},
+		{"server_version", quote(vars.ServerVersion)},
+		{"connect_timeout_server", strconv.FormatInt(vars.ConnectTimeoutServer, 10)},
+		{"monitor_history", strconv.FormatInt(vars.MonitorHistory, 10)},
+		{"monitor_connect_interval", strconv.FormatInt(vars.MonitorConnectInterval, 10)},
+		{"monitor_ping_interval", strconv.FormatInt(vars.MonitorPingInterval, 10)},
+		{"ping_interval_server_msec", strconv.FormatInt(vars.PingInternalServerMsec, 10)},
+		{"ping_timeout_server", strconv.Itoa(vars.PingTimeoutServer)},
+		{"commands_stats", strconv.FormatBool(vars.CommandsStats)},
+		{"sessions_sort", strconv.FormatBool(vars.SessionsSort)},
+		{"monitor_username", quote(vars.MonitorUsername)},
+		{"monitor_password", quote(vars.MonitorPassword)},
+		{"ssl_p2s_cert", quote(vars.SSLP2SCert)},
+		{"ssl_p2s_key", quote(vars.SSLP2SKey)},
+		{"ssl_p2s_ca", quote(vars.SSLP2SCA)},
+	}
+	names := make([]string, 0, len(psql.Variables))
+	for name := range psql.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := psql.Variables[name]
+		if MysqlVariables[name] == MysqlVariableString {
+			value = quote(value)
+		}
+		replaced := false
+		for i := range lines {
+			if lines[i].name == name {
+				lines[i].value = value
+				replaced = true
+			}
+		}
+		if !replaced {
+			lines = append(lines, mysqlVariable{name, value})
+		}
+	}
+	return lines
+}
@@ -0,0 +1,22 @@
+package proxysql
+
+import "testing"
+
+func TestValidateMysqlVariable(t *testing.T) {
+	for _, tc := range []struct {
+		name, value string
+		valid       bool
+	}{
+		{"threads", "8", true},
+		{"threads", "eight", false},
+		{"have_compress", "false", true},
+		{"have_compress", "1", false},
+		{"server_version", "8.0.27", true},
+		{"interfaces", "0.0.0.0:6033", false},
+		{"monitor_password", "secret", false},
+	} {
+		if err := ValidateMysqlVariable(tc.name, tc.value); (err == nil) != tc.valid {
+			t.Errorf("%s=%s: expected valid %t, got %v", tc.name, tc.value, tc.valid, err)
+		}
+	}
+}